@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// graph holds the artifacts that a dry run would publish.
+// Nodes and edges are held in a deterministic order.
+type graph struct {
+	Nodes []*graphNode `json:"nodes"`
+	Edges []graphEdge  `json:"edges"`
+}
+
+type graphNode struct {
+	ID        string        `json:"id"`
+	Kind      string        `json:"kind"` // "blob", "manifest" or "tag"
+	Repo      string        `json:"repo"`
+	Tag       string        `json:"tag,omitempty"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Digest    digest.Digest `json:"digest,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+
+	// Tasks holds the paths of the tasks that created the node.
+	Tasks []string `json:"tasks,omitempty"`
+
+	// External is set when the node is referred to
+	// but is not pushed by the configuration.
+	External bool `json:"external,omitempty"`
+}
+
+// graphEdge records a reference from one node to another.
+// The label describes where the reference comes from,
+// for example "config", "layers[1]" or "subject".
+type graphEdge struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Label string `json:"label"`
+}
+
+func blobNodeID(repoName string, dig digest.Digest) string {
+	return repoName + "@" + string(dig)
+}
+
+func tagNodeID(repoName string, tag string) string {
+	return repoName + ":" + tag
+}
+
+var refPat = regexp.MustCompile("sha256:[a-f0-9]{64}")
+
+// graph returns the graph of everything pushed to r.
+func (r *loggingRegistry) graph() *graph {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nodes := make(map[string]*graphNode)
+	addNode := func(n *graphNode) *graphNode {
+		if n1, ok := nodes[n.ID]; ok {
+			return n1
+		}
+		n.Tasks = append([]string(nil), r.tasks[n.ID]...)
+		sort.Strings(n.Tasks)
+		nodes[n.ID] = n
+		return n
+	}
+	descNode := func(repoName string, desc ocispec.Descriptor) *graphNode {
+		kind := "blob"
+		if ociregistry.IsManifest(desc.MediaType) {
+			kind = "manifest"
+		}
+		return addNode(&graphNode{
+			ID:        blobNodeID(repoName, desc.Digest),
+			Kind:      kind,
+			Repo:      repoName,
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		})
+	}
+	for rd, c := range r.contents {
+		descNode(rd.repo, c.desc)
+	}
+	for repoName, tags := range r.tags {
+		for tag, desc := range tags {
+			addNode(&graphNode{
+				ID:     tagNodeID(repoName, tag),
+				Kind:   "tag",
+				Repo:   repoName,
+				Tag:    tag,
+				Digest: desc.Digest,
+			})
+		}
+	}
+	// Sort the nodes that we have so far so that
+	// edges are added in a deterministic order.
+	sorted := sortedNodes(nodes)
+	var edges []graphEdge
+	addEdge := func(from *graphNode, repoName string, desc ocispec.Descriptor, label string) {
+		to := nodes[blobNodeID(repoName, desc.Digest)]
+		if to == nil {
+			to = descNode(repoName, desc)
+			to.External = true
+		}
+		edges = append(edges, graphEdge{
+			From:  from.ID,
+			To:    to.ID,
+			Label: label,
+		})
+	}
+	for _, n := range sorted {
+		if n.Kind == "tag" {
+			addEdge(n, n.Repo, r.tags[n.Repo][n.Tag], "tag")
+			continue
+		}
+		c, ok := r.contents[repoDigest{n.Repo, n.Digest}]
+		if !ok {
+			continue
+		}
+		if n.Kind == "manifest" {
+			for _, child := range manifestRefs(c.data) {
+				addEdge(n, n.Repo, child.desc, child.label)
+			}
+			continue
+		}
+		if !isJSON(n.MediaType) && !isText(n.MediaType) {
+			continue
+		}
+		refs := refPat.FindAllString(string(c.data), -1)
+		sort.Strings(refs)
+		for i, ref := range refs {
+			if i > 0 && refs[i-1] == ref {
+				continue
+			}
+			if to, ok := nodes[blobNodeID(n.Repo, digest.Digest(ref))]; ok && to != n {
+				edges = append(edges, graphEdge{
+					From:  n.ID,
+					To:    to.ID,
+					Label: "ref",
+				})
+			}
+		}
+	}
+	return &graph{
+		Nodes: sortedNodes(nodes),
+		Edges: edges,
+	}
+}
+
+func sortedNodes(nodes map[string]*graphNode) []*graphNode {
+	sorted := make([]*graphNode, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		n0, n1 := sorted[i], sorted[j]
+		if n0.Repo != n1.Repo {
+			return n0.Repo < n1.Repo
+		}
+		if n0.Kind != n1.Kind {
+			return kindOrder[n0.Kind] < kindOrder[n1.Kind]
+		}
+		return n0.ID < n1.ID
+	})
+	return sorted
+}
+
+type manifestRef struct {
+	label string
+	desc  ocispec.Descriptor
+}
+
+// manifestRefs returns all the descriptors in the
+// given manifest or index, labelled by the field that
+// holds them.
+func manifestRefs(data []byte) []manifestRef {
+	var m struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+		Blobs     []ocispec.Descriptor `json:"blobs"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+		Subject   *ocispec.Descriptor  `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	var refs []manifestRef
+	if m.Config != nil {
+		refs = append(refs, manifestRef{"config", *m.Config})
+	}
+	for _, f := range []struct {
+		name  string
+		descs []ocispec.Descriptor
+	}{
+		{"layers", m.Layers},
+		{"blobs", m.Blobs},
+		{"manifests", m.Manifests},
+	} {
+		for i, desc := range f.descs {
+			refs = append(refs, manifestRef{fmt.Sprintf("%s[%d]", f.name, i), desc})
+		}
+	}
+	if m.Subject != nil {
+		refs = append(refs, manifestRef{"subject", *m.Subject})
+	}
+	return refs
+}
+
+// writeGraph writes g to w in the given format,
+// which must be one of "mermaid", "dot" or "json".
+func writeGraph(w io.Writer, g *graph, format string) error {
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(g, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case "mermaid":
+		writeMermaid(w, g)
+		return nil
+	case "dot":
+		writeDot(w, g)
+		return nil
+	}
+	return fmt.Errorf("unknown graph format %q", format)
+}
+
+// shortIDs returns a map from node ID to a short
+// identifier suitable for use in mermaid and dot output.
+func shortIDs(g *graph) map[string]string {
+	ids := make(map[string]string)
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i+1)
+	}
+	return ids
+}
+
+// labelLines returns the lines of the label for n.
+func labelLines(n *graphNode) []string {
+	var lines []string
+	if n.Kind == "tag" {
+		lines = append(lines, "tag "+n.Tag)
+	} else {
+		lines = append(lines, n.Kind+" "+shortDigest(n.Digest))
+	}
+	lines = append(lines, n.Repo)
+	if n.MediaType != "" {
+		lines = append(lines, n.MediaType)
+	}
+	lines = append(lines, n.Tasks...)
+	if n.External {
+		lines = append(lines, "(external)")
+	}
+	return lines
+}
+
+func shortDigest(dig digest.Digest) string {
+	if s := string(dig); len(s) > len("sha256:")+12 {
+		return s[:len("sha256:")+12]
+	}
+	return string(dig)
+}
+
+func writeMermaid(w io.Writer, g *graph) {
+	ids := shortIDs(g)
+	fmt.Fprintf(w, "flowchart LR\n")
+	for _, n := range g.Nodes {
+		lines := labelLines(n)
+		for i, line := range lines {
+			lines[i] = strings.ReplaceAll(line, `"`, "#quot;")
+		}
+		label := `"` + strings.Join(lines, "<br>") + `"`
+		switch n.Kind {
+		case "tag":
+			fmt.Fprintf(w, "\t%s([%s])\n", ids[n.ID], label)
+		case "manifest":
+			fmt.Fprintf(w, "\t%s[[%s]]\n", ids[n.ID], label)
+		default:
+			fmt.Fprintf(w, "\t%s[%s]\n", ids[n.ID], label)
+		}
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "\t%s -->|\"%s\"| %s\n", ids[e.From], e.Label, ids[e.To])
+	}
+}
+
+func writeDot(w io.Writer, g *graph) {
+	ids := shortIDs(g)
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+	fmt.Fprintf(w, "digraph artifacts {\n")
+	fmt.Fprintf(w, "\trankdir=LR;\n")
+	for _, n := range g.Nodes {
+		shape := "box"
+		switch n.Kind {
+		case "tag":
+			shape = "ellipse"
+		case "manifest":
+			shape = "box3d"
+		}
+		lines := labelLines(n)
+		for i, line := range lines {
+			lines[i] = escape(line)
+		}
+		fmt.Fprintf(w, "\t%s [shape=%s, label=\"%s\"];\n", ids[n.ID], shape, strings.Join(lines, `\n`))
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "\t%s -> %s [label=\"%s\"];\n", ids[e.From], ids[e.To], escape(e.Label))
+	}
+	fmt.Fprintf(w, "}\n")
+}
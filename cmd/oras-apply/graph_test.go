@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cue-exp/oras/orasflow"
+)
+
+var updateGraphs = flag.Bool("update-graphs", false, "update the graph.* files in testdata")
+
+// TestGraph checks the output of -n -graph in each format
+// against the graph.* files in some testdata directories.
+// Run with -update-graphs to update them.
+func TestGraph(t *testing.T) {
+	for _, dir := range []string{"index", "referrers"} {
+		t.Run(dir, func(t *testing.T) {
+			inst, v := loadTestdata(t, dir)
+			r := newLoggingRegistry()
+			err := orasflow.Apply(context.Background(), v, r, &orasflow.Options{
+				Dir:   inst.Dir,
+				Event: r.event,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			g := r.graph()
+			for _, format := range []string{"mermaid", "dot", "json"} {
+				var buf bytes.Buffer
+				if err := writeGraph(&buf, g, format); err != nil {
+					t.Fatal(err)
+				}
+				file := filepath.Join("testdata", dir, "graph."+format)
+				if *updateGraphs {
+					if err := os.WriteFile(file, buf.Bytes(), 0o666); err != nil {
+						t.Fatal(err)
+					}
+					continue
+				}
+				want, err := os.ReadFile(file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := buf.String(); got != string(want) {
+					t.Errorf("unexpected %s output; got:\n%s\nwant:\n%s", format, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteGraphUnknownFormat(t *testing.T) {
+	err := writeGraph(new(bytes.Buffer), &graph{}, "svg")
+	if err == nil || err.Error() != `unknown graph format "svg"` {
+		t.Errorf("got error %v; want unknown graph format error", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// jsonEvent is the JSON form of an orasflow.Event
+// as printed by the -json flag.
+type jsonEvent struct {
+	Type      string        `json:"type"` // always "task"
+	Task      string        `json:"task"`
+	Action    string        `json:"action"`
+	Repo      string        `json:"repo,omitempty"`
+	Reference string        `json:"reference,omitempty"`
+	MediaType string        `json:"mediaType,omitempty"`
+	Digest    digest.Digest `json:"digest,omitempty"`
+	Size      int64         `json:"size,omitempty"`
+	Duration  float64       `json:"duration"` // in seconds
+	Status    string        `json:"status"`   // "ok" or "error"
+	Error     string        `json:"error,omitempty"`
+}
+
+// jsonOutput is printed by the -json flag for each
+// output task that writes to standard output.
+type jsonOutput struct {
+	Type  string          `json:"type"` // always "output"
+	Task  string          `json:"task"`
+	Name  string          `json:"name,omitempty"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonSummary is printed by the -json flag after all tasks have run.
+type jsonSummary struct {
+	Type     string  `json:"type"` // always "summary"
+	Tasks    int     `json:"tasks"`
+	Failed   int     `json:"failed"`
+	Duration float64 `json:"duration"` // in seconds
+	Status   string  `json:"status"`   // "ok" or "error"
+	Error    string  `json:"error,omitempty"`
+
+	// The following fields are only present when
+	// talking to a registry.
+	New       *int `json:"new,omitempty"`
+	Changed   *int `json:"changed,omitempty"`
+	Deleted   *int `json:"deleted,omitempty"`
+	Unchanged *int `json:"unchanged,omitempty"`
+
+	// Results holds the values of all results outputs by name.
+	Results map[string]json.RawMessage `json:"results,omitempty"`
+}
+
+// jsonWriter writes one JSON object per line for each
+// event and for the final summary.
+type jsonWriter struct {
+	enc    *json.Encoder
+	start  time.Time
+	tasks  int
+	failed int
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{
+		enc:   json.NewEncoder(w),
+		start: time.Now(),
+	}
+}
+
+// event implements orasflow.Options.Event.
+func (w *jsonWriter) event(ev orasflow.Event) {
+	w.tasks++
+	e := jsonEvent{
+		Type:      "task",
+		Task:      ev.Task.String(),
+		Action:    ev.Action,
+		Repo:      ev.Repo,
+		Reference: ev.Reference,
+		MediaType: ev.MediaType,
+		Digest:    ev.Digest,
+		Size:      ev.Size,
+		Duration:  ev.Duration.Seconds(),
+		Status:    "ok",
+	}
+	if ev.Err != nil {
+		w.failed++
+		e.Status = "error"
+		e.Error = ev.Err.Error()
+	}
+	w.enc.Encode(e)
+}
+
+// output writes an output that would otherwise
+// have gone to standard output.
+func (w *jsonWriter) output(o orasflow.Output) {
+	w.enc.Encode(jsonOutput{
+		Type:  "output",
+		Task:  o.Task.String(),
+		Name:  o.Name,
+		Value: o.Value,
+	})
+}
+
+// summary writes the final summary. If diff is non-nil,
+// the summary includes counts of the changes it recorded.
+// The summary includes any results recorded by out.
+func (w *jsonWriter) summary(diff *diffRegistry, out *outputWriter, err error) {
+	s := jsonSummary{
+		Type:     "summary",
+		Tasks:    w.tasks,
+		Failed:   w.failed,
+		Duration: time.Since(w.start).Seconds(),
+		Status:   "ok",
+	}
+	if len(out.results) > 0 {
+		s.Results = out.results
+	}
+	if err != nil {
+		s.Status = "error"
+		s.Error = err.Error()
+	}
+	if diff != nil {
+		counts := make(map[changeOp]int)
+		for _, c := range diff.sortedChanges() {
+			counts[c.op]++
+		}
+		n, c, d, u := counts[opAdd], counts[opChange], counts[opDelete], counts[opUnchanged]
+		s.New, s.Changed, s.Deleted, s.Unchanged = &n, &c, &d, &u
+	}
+	w.enc.Encode(s)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+func TestJSONOutput(t *testing.T) {
+	ctx := context.Background()
+	inst, v := loadTestdata(t, "simple")
+	r := orasflow.RegistryFromInterface(ociregistry.NewMemRegistry())
+
+	// The first run pushes everything; the second
+	// finds it all there already.
+	events, summary := applyJSON(t, ctx, inst.Dir, v, r)
+	if len(events) == 0 {
+		t.Fatalf("no task events")
+	}
+	for _, e := range events {
+		if e.Type != "task" || e.Task == "" || e.Action == "" || e.Status != "ok" || e.Error != "" {
+			t.Errorf("unexpected event %+v", e)
+		}
+	}
+	checkSummary(t, summary, len(events), map[string]int{
+		"new":       5,
+		"changed":   0,
+		"deleted":   0,
+		"unchanged": 0,
+	})
+	events, summary = applyJSON(t, ctx, inst.Dir, v, r)
+	checkSummary(t, summary, len(events), map[string]int{
+		"new":       0,
+		"changed":   0,
+		"deleted":   0,
+		"unchanged": 5,
+	})
+}
+
+func TestJSONOutputError(t *testing.T) {
+	var buf bytes.Buffer
+	w := newJSONWriter(&buf)
+	w.event(orasflow.Event{
+		Task:   cue.ParsePath("tags.v1"),
+		Action: "tag",
+		Repo:   "foo",
+		Err:    fmt.Errorf("no such manifest"),
+	})
+	w.summary(nil, newOutputWriter(), fmt.Errorf("some tasks failed"))
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines; want 2:\n%s", len(lines), buf.String())
+	}
+	var e jsonEvent
+	if err := json.Unmarshal([]byte(lines[0]), &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.Task != "tags.v1" || e.Status != "error" || e.Error != "no such manifest" {
+		t.Errorf("unexpected event %+v", e)
+	}
+	var s map[string]any
+	if err := json.Unmarshal([]byte(lines[1]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if s["type"] != "summary" || s["tasks"] != 1.0 || s["failed"] != 1.0 || s["status"] != "error" || s["error"] != "some tasks failed" {
+		t.Errorf("unexpected summary %s", lines[1])
+	}
+	// Without a registry diff, there are no change counts.
+	for _, field := range []string{"new", "changed", "deleted", "unchanged"} {
+		if _, ok := s[field]; ok {
+			t.Errorf("summary has unexpected field %q", field)
+		}
+	}
+}
+
+// applyJSON applies v to r as the -json flag does and returns
+// the task events and the summary that were printed.
+func applyJSON(t *testing.T, ctx context.Context, dir string, v cue.Value, r orasflow.Registry) ([]jsonEvent, map[string]any) {
+	t.Helper()
+	var buf bytes.Buffer
+	diff := newDiffRegistry(r, true)
+	w := newJSONWriter(&buf)
+	out := newOutputWriter()
+	out.json = w
+	err := runFlow(ctx, v, diff, &orasflow.Options{
+		Dir:    dir,
+		Event:  w.event,
+		Output: out.output,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.summary(diff, out, nil)
+
+	var events []jsonEvent
+	var summary map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			t.Fatal(err)
+		}
+		if summary != nil {
+			t.Fatalf("output after summary: %s", raw)
+		}
+		var e jsonEvent
+		if err := json.Unmarshal(raw, &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Type == "task" {
+			events = append(events, e)
+			continue
+		}
+		if e.Type != "summary" {
+			t.Fatalf("unexpected object %s", raw)
+		}
+		if err := json.Unmarshal(raw, &summary); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if summary == nil {
+		t.Fatalf("no summary")
+	}
+	return events, summary
+}
+
+func checkSummary(t *testing.T, s map[string]any, tasks int, counts map[string]int) {
+	t.Helper()
+	if s["status"] != "ok" || s["tasks"] != float64(tasks) || s["failed"] != 0.0 {
+		t.Errorf("unexpected summary %v; want %d tasks", s, tasks)
+	}
+	if _, ok := s["duration"].(float64); !ok {
+		t.Errorf("summary has no duration")
+	}
+	for field, want := range counts {
+		if got := s[field]; got != float64(want) {
+			t.Errorf("summary %s: got %v; want %d", field, got, want)
+		}
+	}
+}
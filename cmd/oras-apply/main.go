@@ -1,15 +1,17 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
+	"net"
 	"os"
-	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
@@ -24,27 +26,68 @@ import (
 	"github.com/cue-exp/oras/orasflow"
 )
 
-const (
-	debug          = true
-	singleThreaded = true
-)
-
 var (
-	nflag      = flag.Bool("n", false, "print what we're doing but do not actually do anything")
-	scriptFlag = flag.Bool("script", false, "generate command line script")
+	nflag           = flag.Bool("n", false, "print what we're doing but do not actually do anything")
+	scriptFlag      = flag.Bool("script", false, "generate command line script")
+	dialectFlag     = flag.String("script-dialect", "oras", "tool used by the generated script to talk to the registry (oras or curl)")
+	allowDeleteFlag = flag.Bool("allow-delete", false, "allow tasks that delete tags or manifests")
+	planFlag        = flag.Bool("plan", false, "print the differences between the configuration and the registry without changing anything")
+	pruneFlag       = flag.Bool("prune", false, "delete tags in repositories pushed to by the configuration that it does not declare")
+	jsonFlag        = flag.Bool("json", false, "print a JSON object for each completed task and a final summary")
+	verboseFlag     = flag.Bool("v", false, "log debugging information to stderr")
+	outputFlag      = flag.String("output", "", "write to an OCI image layout (layout:dir) or a tar archive of one (tar:file) instead of a registry")
+	graphFlag       = flag.String("graph", "", "with -n, print the artifact graph in the given format (mermaid, dot or json)")
+	resultsFlag     = flag.String("results", "", "write the values of results outputs to the given file as a JSON object")
+	rawJSONFlag     = flag.Bool("raw-json", false, "push manifests and JSON blobs as CUE encodes them rather than as canonical JSON")
+	concurrencyFlag = flag.Int("max-concurrency", 0, "maximum number of tasks to run at once (0 means no limit)")
 )
 
-const orasPkg = "github.com/cue-exp/oras"
-
-var orasField = cue.MakePath(cue.Hid("_oras", orasPkg))
-
 func main() {
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: oras-apply [pkg]\n")
+		fmt.Fprintf(os.Stderr, "usage: oras-apply [flags] [pkg]\n")
+		fmt.Fprintf(os.Stderr, "       oras-apply [flags] import layout-dir|file.tar\n")
+		flag.PrintDefaults()
 		os.Exit(2)
 	}
 	flag.Parse()
+	if *planFlag && (*nflag || *scriptFlag) {
+		fmt.Fprintf(os.Stderr, "oras-apply: -plan cannot be used with -n or -script\n")
+		os.Exit(2)
+	}
+	if *jsonFlag && (*planFlag || *nflag || *scriptFlag) {
+		fmt.Fprintf(os.Stderr, "oras-apply: -json cannot be used with -plan, -n or -script\n")
+		os.Exit(2)
+	}
+	if *outputFlag != "" && (*planFlag || *nflag || *scriptFlag || *pruneFlag) {
+		fmt.Fprintf(os.Stderr, "oras-apply: -output cannot be used with -plan, -n, -script or -prune\n")
+		os.Exit(2)
+	}
+	switch *graphFlag {
+	case "":
+	case "mermaid", "dot", "json":
+		if !*nflag {
+			fmt.Fprintf(os.Stderr, "oras-apply: -graph requires -n\n")
+			os.Exit(2)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "oras-apply: unknown -graph format %q\n", *graphFlag)
+		os.Exit(2)
+	}
+	if *pruneFlag && !*planFlag && !*allowDeleteFlag {
+		fmt.Fprintf(os.Stderr, "oras-apply: -prune requires -allow-delete (or -plan)\n")
+		os.Exit(2)
+	}
 
+	if flag.Arg(0) == "import" {
+		if flag.NArg() != 2 || *nflag || *scriptFlag || *outputFlag != "" {
+			flag.Usage()
+		}
+		if err := runImport(flag.Arg(1)); err != nil {
+			fmt.Fprintf(os.Stderr, "oras-apply: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
 	pkg := "."
 	switch flag.NArg() {
 	case 0:
@@ -74,11 +117,77 @@ func runApply(pkg string) error {
 	if err != nil {
 		return err
 	}
-	if err := orasflow.Apply(ctx, v, registry); err != nil {
+	diff, _ := registry.(*diffRegistry)
+	if *pruneFlag && diff == nil {
+		return fmt.Errorf("-prune is only supported when talking to a registry")
+	}
+	opts := orasflow.Options{
+		MaxConcurrency: *concurrencyFlag,
+		RawJSON:        *rawJSONFlag,
+		Dir:            inst.Dir,
+		SourceRegistry: openSourceRegistry,
+	}
+	if *verboseFlag {
+		opts.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		}))
+	}
+	out := newOutputWriter()
+	out.writeFiles = !*nflag && !*planFlag
+	opts.Output = out.output
+	var jw *jsonWriter
+	if *jsonFlag {
+		jw = newJSONWriter(os.Stdout)
+		opts.Event = jw.event
+		out.json = jw
+	}
+	if r, ok := registry.(*loggingRegistry); ok {
+		opts.Event = r.event
+		if *graphFlag != "" {
+			out.stdout = os.Stderr
+		}
+	}
+	if r, ok := registry.(*scriptRegistry); ok {
+		out.script = r
+	}
+	err = runFlow(ctx, v, registry, &opts)
+	if err == nil && *resultsFlag != "" {
+		err = out.writeResults(*resultsFlag)
+	}
+	if jw != nil {
+		jw.summary(diff, out, err)
+		return err
+	}
+	if err != nil {
 		return err
 	}
 	if r, ok := registry.(*loggingRegistry); ok {
-		r.dumpMermaid()
+		if *graphFlag != "" {
+			r.printDeletes(os.Stderr)
+			return writeGraph(os.Stdout, r.graph(), *graphFlag)
+		}
+		r.printDeletes(os.Stdout)
+	}
+	if diff != nil {
+		diff.printChanges(os.Stdout)
+	}
+	return nil
+}
+
+func runFlow(ctx context.Context, v cue.Value, registry orasflow.Registry, opts *orasflow.Options) error {
+	flowRegistry := registry
+	if !deleteAllowed() {
+		flowRegistry = noDeleteRegistry{registry}
+	}
+	err := orasflow.Apply(ctx, v, flowRegistry, opts)
+	if out, ok := registry.(*layoutOutput); ok {
+		return out.finish(err)
+	}
+	if err != nil {
+		return err
+	}
+	if diff, ok := registry.(*diffRegistry); ok && *pruneFlag {
+		return diff.prune(ctx)
 	}
 	return nil
 }
@@ -87,13 +196,20 @@ func getRegistry(ctx context.Context) (orasflow.Registry, error) {
 	if *nflag {
 		return newLoggingRegistry(), nil
 	}
+	if *outputFlag != "" {
+		return newLayoutOutput(*outputFlag)
+	}
 
 	reg := os.Getenv("OCI_REGISTRY")
 	if reg == "" {
 		reg = "localhost:5000" // TODO lose this default
 	}
 	if *scriptFlag {
-		return newScriptRegistry(reg), nil
+		dialect, ok := scriptDialects[*dialectFlag]
+		if !ok {
+			return nil, fmt.Errorf("unknown script dialect %q", *dialectFlag)
+		}
+		return newScriptRegistry(os.Stdout, reg, dialect), nil
 	}
 	registry, err := remote.NewRegistry(reg)
 	if err != nil {
@@ -103,127 +219,277 @@ func getRegistry(ctx context.Context) (orasflow.Registry, error) {
 	if err := registry.Ping(ctx); err != nil {
 		return nil, fmt.Errorf("cannot ping registry: %v", err)
 	}
+	return newDiffRegistry(orasflow.RegistryFromRemote(registry), !*planFlag), nil
+}
+
+// openSourceRegistry opens the registry with the given host name,
+// for copy tasks that copy from another registry. Only registries
+// on the local host are talked to over plain HTTP.
+func openSourceRegistry(ctx context.Context, host string) (orasflow.Registry, error) {
+	registry, err := remote.NewRegistry(host)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make registry instance: %v", err)
+	}
+	registry.PlainHTTP = isLocalHost(host)
 	return orasflow.RegistryFromRemote(registry), nil
 }
 
-type arc struct {
-	n1, n2 string
+// isLocalHost reports whether the given host, which
+// may include a port, refers to the local machine.
+func isLocalHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// deleteAllowed reports whether delete tasks may run. They always
+// may when nothing is being changed: a plan reports the deletions,
+// and a dry run lists them.
+func deleteAllowed() bool {
+	return *allowDeleteFlag || *planFlag || *nflag
+}
+
+// noDeleteRegistry hides any delete methods of the
+// registry it wraps, so that orasflow refuses to run
+// delete tasks.
+type noDeleteRegistry struct {
+	orasflow.Registry
+}
+
+// Mount implements [orasflow.Mounter] by passing
+// the mount on to the underlying registry.
+func (r noDeleteRegistry) Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error {
+	mounter, ok := r.Registry.(orasflow.Mounter)
+	if !ok {
+		return fmt.Errorf("registry does not support mounting")
+	}
+	return mounter.Mount(ctx, repoName, fromRepo, desc)
+}
+
+// Exists implements [orasflow.Exister] by passing
+// the check on to the underlying registry.
+func (r noDeleteRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	exister, ok := r.Registry.(orasflow.Exister)
+	if !ok {
+		return false, nil
+	}
+	return exister.Exists(ctx, repoName, desc)
 }
 
 func newLoggingRegistry() *loggingRegistry {
 	return &loggingRegistry{
-		pushed:       make(map[string]string),
-		repositories: make(map[string]int),
+		contents:  make(map[repoDigest]storedContent),
+		tags:      make(map[string]map[string]ocispec.Descriptor),
+		referrers: make(map[repoDigest][]ocispec.Descriptor),
+		tasks:     make(map[string][]string),
 	}
 }
 
+// loggingRegistry is used for dry runs. It keeps
+// everything that's pushed to it in memory so that
+// it can be read back by later tasks and so that the
+// resulting artifact graph can be printed.
 type loggingRegistry struct {
-	mu           sync.Mutex
-	pushed       map[string]string // map from node name to node content
-	arcs         []arc
-	repositories map[string]int
+	mu        sync.Mutex
+	contents  map[repoDigest]storedContent
+	tags      map[string]map[string]ocispec.Descriptor // map from repo to tag to descriptor
+	referrers map[repoDigest][]ocispec.Descriptor      // map from subject to referrers
+	tasks     map[string][]string                      // map from graph node ID to task paths
+	deletes   []string                                 // deletions that would be made
 }
 
-//	r1:sha256:24433[example.com/foo text/plain]
-//	r2:sha256:335[arble.com application/zip]
-//	r1:sha256:335-->|.foo.bar|r2sha256:24433
-
-var refPat = regexp.MustCompile("sha256:[a-f0-9]{64,}")
-
-func init() {
-	refPat.Longest()
+// storedContent holds content pushed to a loggingRegistry
+// so that it can be read back by later tasks.
+type storedContent struct {
+	desc ocispec.Descriptor
+	data []byte
 }
 
-func (r *loggingRegistry) dumpMermaid() {
-	var buf strings.Builder
-	printf := func(f string, a ...any) {
-		fmt.Fprintf(&buf, f, a...)
+// event implements orasflow.Options.Event by recording
+// which task created each blob, manifest and tag.
+func (r *loggingRegistry) event(ev orasflow.Event) {
+	if ev.Err != nil || ev.Repo == "" {
+		return
 	}
-	printf("flowchart LR\n")
-	for ref, name := range r.pushed {
-		printf("\t%s[%s]\n", ref, name)
+	var id string
+	switch ev.Action {
+	case "blob", "manifest", "index":
+		id = blobNodeID(ev.Repo, ev.Digest)
+	case "tag":
+		id = tagNodeID(ev.Repo, ev.Reference)
+	case "copy":
+		id = blobNodeID(ev.Repo, ev.Digest)
+		if ev.Reference != "" {
+			r.addTask(tagNodeID(ev.Repo, ev.Reference), ev.Task.String())
+		}
+	default:
+		return
 	}
-	for _, a := range r.arcs {
-		printf("\t%s-->%s\n", a.n1, a.n2)
+	r.addTask(id, ev.Task.String())
+}
+
+func (r *loggingRegistry) addTask(id, task string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, t := range r.tasks[id] {
+		if t == task {
+			return
+		}
 	}
+	r.tasks[id] = append(r.tasks[id], task)
 }
 
 func (r *loggingRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
-	log.Printf("--- push")
-	r.addRefs(repoName, desc, content)
-	return nil
+	_, err := r.store(repoName, desc, content)
+	return err
 }
 
 func (r *loggingRegistry) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
-	log.Printf("--- pushManifest")
-	r.addRefs(repoName, desc, content)
+	data, err := r.store(repoName, desc, content)
+	if err != nil {
+		return err
+	}
+	var m struct {
+		ArtifactType string              `json:"artifactType"`
+		Config       ocispec.Descriptor  `json:"config"`
+		Subject      *ocispec.Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil || m.Subject == nil {
+		return nil
+	}
+	if desc.ArtifactType = m.ArtifactType; desc.ArtifactType == "" {
+		desc.ArtifactType = m.Config.MediaType
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subject := repoDigest{repoName, m.Subject.Digest}
+	r.referrers[subject] = append(r.referrers[subject], desc)
 	return nil
 }
 
-func (r *loggingRegistry) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
-	log.Printf("--- tag")
-	r.addArc("tag:%s", r.repoDigest(repoName, desc.Digest))
-	return nil
+func (r *loggingRegistry) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var descs []ocispec.Descriptor
+	for _, referrer := range r.referrers[repoDigest{repoName, desc.Digest}] {
+		if artifactType == "" || referrer.ArtifactType == artifactType {
+			descs = append(descs, referrer)
+		}
+	}
+	return descs, nil
 }
 
-func (r *loggingRegistry) addRefs(repoName string, desc ocispec.Descriptor, content io.Reader) {
+func (r *loggingRegistry) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
-	if !isJSON(desc.MediaType) && !isText(desc.MediaType) {
-		return
-	}
-	data, err := ioutil.ReadAll(content)
-	if err != nil {
-		panic(fmt.Errorf("cannot read content: %v", err))
-	}
-	r.addDesc(repoName, desc)
-	from := r.repoDigest(repoName, desc.Digest)
-	for _, ref := range r.scanText(repoName, string(data)) {
-		r.addArc(from, ref)
+	if r.tags[repoName] == nil {
+		r.tags[repoName] = make(map[string]ocispec.Descriptor)
 	}
+	r.tags[repoName][reference] = desc
+	return nil
 }
 
-func (r *loggingRegistry) Dump(ctx context.Context, stuff json.RawMessage) {
+func (r *loggingRegistry) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deletes = append(r.deletes, fmt.Sprintf("delete tag %s:%s", repoName, tag))
+	delete(r.tags[repoName], tag)
+	return nil
 }
 
-func (r *loggingRegistry) addDesc(repoName string, desc ocispec.Descriptor) {
-	// TODO check for media type clashes
-	r.pushed[r.repoDigest(repoName, desc.Digest)] = repoName + " " + desc.MediaType
+func (r *loggingRegistry) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deletes = append(r.deletes, fmt.Sprintf("delete manifest %s@%s", repoName, desc.Digest))
+	delete(r.contents, repoDigest{repoName, desc.Digest})
+	for tag, tagDesc := range r.tags[repoName] {
+		if tagDesc.Digest == desc.Digest {
+			delete(r.tags[repoName], tag)
+		}
+	}
+	return nil
 }
 
-func (r *loggingRegistry) scanText(repoName, text string) []string {
-	possibleRefs := refPat.FindAllString(text, -1)
-	log.Printf("XXX matches: %q", possibleRefs)
-	refs := make([]string, 0, len(possibleRefs))
-	for _, ref := range possibleRefs {
-		idRef := r.repoDigest(repoName, digest.Digest(ref))
-		if _, ok := r.pushed[idRef]; ok {
-			refs = append(refs, idRef)
-		} else {
-			log.Printf("XXXX no match for %v", idRef)
+// printDeletes writes the deletions that the dry run would have
+// made to w, one per line in lexical order, noting when they
+// need -allow-delete.
+func (r *loggingRegistry) printDeletes(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	deletes := append([]string(nil), r.deletes...)
+	sort.Strings(deletes)
+	for _, d := range deletes {
+		if !*allowDeleteFlag {
+			d += " (requires -allow-delete)"
 		}
+		fmt.Fprintln(w, d)
 	}
-	log.Printf("--- scanned %s -> %v", text, refs)
-	return refs
 }
 
-func (r *loggingRegistry) repoDigest(repoName string, digest digest.Digest) string {
-	return fmt.Sprintf("r%d:%s", r.repoID(repoName), digest)
+// The read operations below only see content that has been
+// pushed earlier in the same run, as nothing is ever
+// actually read from a registry in dry-run mode.
+
+func (r *loggingRegistry) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if desc, ok := r.tags[repoName][reference]; ok {
+		return desc, nil
+	}
+	if c, ok := r.contents[repoDigest{repoName, digest.Digest(reference)}]; ok {
+		return c.desc, nil
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("%s:%s not found (dry run)", repoName, reference)
 }
 
-func (r *loggingRegistry) repoID(repoName string) int {
-	if r.repositories == nil {
-		r.repositories = make(map[string]int)
+func (r *loggingRegistry) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.contents[repoDigest{repoName, desc.Digest}]
+	if !ok {
+		return nil, fmt.Errorf("%s@%s not found (dry run)", repoName, desc.Digest)
 	}
-	if id := r.repositories[repoName]; id > 0 {
-		return id
+	return io.NopCloser(bytes.NewReader(c.data)), nil
+}
+
+func (r *loggingRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.contents[repoDigest{repoName, desc.Digest}]
+	return ok, nil
+}
+
+func (r *loggingRegistry) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	return r.Fetch(ctx, repoName, desc)
+}
+
+func (r *loggingRegistry) Tags(ctx context.Context, repoName string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tags := make([]string, 0, len(r.tags[repoName]))
+	for tag := range r.tags[repoName] {
+		tags = append(tags, tag)
 	}
-	r.repositories[repoName] = len(r.repositories) + 1
-	return len(r.repositories)
+	return tags, nil
 }
 
-func (r *loggingRegistry) addArc(n1, n2 string) {
-	r.arcs = append(r.arcs, arc{n1, n2})
+func (r *loggingRegistry) store(repoName string, desc ocispec.Descriptor, content io.Reader) ([]byte, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read content: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contents[repoDigest{repoName, desc.Digest}] = storedContent{
+		desc: desc,
+		data: data,
+	}
+	return data, nil
 }
 
 func isJSON(mediaType string) bool {
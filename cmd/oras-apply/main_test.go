@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/build"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+
+	"github.com/cue-exp/oras/orasflow"
+)
+
+var deleteGuardTests = []struct {
+	testName    string
+	dryRun      bool
+	plan        bool
+	allowDelete bool
+	wantErr     string
+	want        string
+}{{
+	testName: "Refused",
+	wantErr:  "deleteManifest task found but the registry does not allow deletion",
+}, {
+	testName: "DryRun",
+	dryRun:   true,
+	want: "delete manifest delete-test@sha256:ace5b4a5cd93d880c9ce4d0db7b0d6a2e909e8f920d04cae421a5d7d1a646798 (requires -allow-delete)\n" +
+		"delete tag delete-test:v0.1.0 (requires -allow-delete)\n",
+}, {
+	testName:    "DryRunAllowed",
+	dryRun:      true,
+	allowDelete: true,
+	want: "delete manifest delete-test@sha256:ace5b4a5cd93d880c9ce4d0db7b0d6a2e909e8f920d04cae421a5d7d1a646798\n" +
+		"delete tag delete-test:v0.1.0\n",
+}, {
+	testName: "Plan",
+	plan:     true,
+}, {
+	testName:    "Allowed",
+	allowDelete: true,
+}}
+
+func TestDeleteGuard(t *testing.T) {
+	ctx := context.Background()
+	inst, v := loadTestdata(t, "delete")
+	defer func(n, plan, allowDelete bool) {
+		*nflag, *planFlag, *allowDeleteFlag = n, plan, allowDelete
+	}(*nflag, *planFlag, *allowDeleteFlag)
+	for _, test := range deleteGuardTests {
+		t.Run(test.testName, func(t *testing.T) {
+			*nflag, *planFlag, *allowDeleteFlag = test.dryRun, test.plan, test.allowDelete
+			// The logging registry stands in for a real one
+			// when it's not a dry run.
+			r := newLoggingRegistry()
+			err := runFlow(ctx, v, r, &orasflow.Options{Dir: inst.Dir})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.dryRun {
+				return
+			}
+			var buf bytes.Buffer
+			r.printDeletes(&buf)
+			if got := buf.String(); got != test.want {
+				t.Errorf("got deletes:\n%s\nwant:\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+// loadTestdata loads and builds the CUE package in the
+// named directory under testdata.
+func loadTestdata(t *testing.T, name string) (*build.Instance, cue.Value) {
+	t.Helper()
+	inst := load.Instances([]string{"."}, &load.Config{Dir: filepath.Join("testdata", name)})[0]
+	if err := inst.Err; err != nil {
+		t.Fatalf("cannot load instance: %v", errors.Details(err, nil))
+	}
+	v := cuecontext.New().BuildInstance(inst)
+	if err := v.Err(); err != nil {
+		t.Fatalf("cannot build instance: %v", errors.Details(err, nil))
+	}
+	return inst, v
+}
@@ -1,87 +1,270 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"strings"
-	"sync/atomic"
+	"sync"
+	"unicode"
 	"unicode/utf8"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
+// scriptRegistry implements orasflow.Registry by writing a shell
+// script that performs the same operations when run.
+//
+// The script defines a shell function for each kind of operation
+// (see scriptDialects), so that the body of the script is independent
+// of the tool that is used to talk to the registry. The registry host
+// is held in the $registry variable, which defaults to $OCI_REGISTRY.
+// Content is only pushed when it's not already present, so the
+// script can be run more than once.
 type scriptRegistry struct {
-	host   string
-	fileID int32
+	w       io.Writer
+	dialect *scriptDialect
+
+	mu     sync.Mutex
+	fileID int
+}
+
+// scriptDialect holds the shell functions used by a script
+// to talk to the registry. The functions are:
+//
+//	push_blob repo digest mediatype file
+//	push_manifest repo digest mediatype file
+//	tag repo digest tag mediatype
+//	delete_tag repo tag
+//	delete_manifest repo digest
+type scriptDialect struct {
+	// funcs holds the function definitions.
+	funcs string
+
+	// canDeleteTags reports whether delete_tag is implemented.
+	canDeleteTags bool
+}
+
+var scriptDialects = map[string]*scriptDialect{
+	"oras": {
+		funcs: `oras_flags=${ORAS_FLAGS:-}
+
+push_blob() {
+	if oras blob fetch $oras_flags --descriptor "$registry/$1@$2" >/dev/null 2>&1; then
+		return
+	fi
+	oras blob push $oras_flags --media-type "$3" "$registry/$1@$2" "$4"
+}
+
+push_manifest() {
+	if oras manifest fetch $oras_flags --descriptor "$registry/$1@$2" >/dev/null 2>&1; then
+		return
+	fi
+	oras manifest push $oras_flags --media-type "$3" "$registry/$1@$2" "$4"
+}
+
+tag() {
+	oras tag $oras_flags "$registry/$1@$2" "$3"
+}
+
+delete_manifest() {
+	oras manifest delete $oras_flags --force "$registry/$1@$2"
+}
+`,
+	},
+	"curl": {
+		funcs: `scheme=${OCI_SCHEME:-https}
+curl_flags=${CURL_FLAGS:-}
+
+curl_() {
+	curl -fsS $curl_flags "$@"
+}
+
+push_blob() {
+	if curl_ -o /dev/null -I "$scheme://$registry/v2/$1/blobs/$2" 2>/dev/null; then
+		return
+	fi
+	location=$(curl_ -o /dev/null -D - -X POST "$scheme://$registry/v2/$1/blobs/uploads/" |
+		tr -d '\r' | sed -n 's/^[Ll]ocation: *//p')
+	case $location in
+	/*) location=$scheme://$registry$location ;;
+	esac
+	case $location in
+	*\?*) location="$location&" ;;
+	*) location="$location?" ;;
+	esac
+	curl_ -o /dev/null -X PUT -H 'Content-Type: application/octet-stream' \
+		--data-binary "@$4" "${location}digest=$(echo "$2" | sed 's/:/%3A/')"
+}
+
+push_manifest() {
+	if curl_ -o /dev/null -I -H "Accept: $3" "$scheme://$registry/v2/$1/manifests/$2" 2>/dev/null; then
+		return
+	fi
+	curl_ -o /dev/null -X PUT -H "Content-Type: $3" --data-binary "@$4" "$scheme://$registry/v2/$1/manifests/$2"
+}
+
+tag() {
+	curl_ -o "$tmpRoot/tag" -H "Accept: $4" "$scheme://$registry/v2/$1/manifests/$2"
+	curl_ -o /dev/null -X PUT -H "Content-Type: $4" --data-binary "@$tmpRoot/tag" "$scheme://$registry/v2/$1/manifests/$3"
+}
+
+delete_tag() {
+	curl_ -o /dev/null -X DELETE "$scheme://$registry/v2/$1/manifests/$2"
+}
+
+delete_manifest() {
+	curl_ -o /dev/null -X DELETE "$scheme://$registry/v2/$1/manifests/$2"
+}
+`,
+		canDeleteTags: true,
+	},
 }
 
-func newScriptRegistry(host string) *scriptRegistry {
-	fmt.Printf("#!/bin/sh\n")
-	fmt.Printf("set -ex\n")
-	fmt.Printf("tmpRoot=$(mktemp -d)\n")
+func newScriptRegistry(w io.Writer, host string, dialect *scriptDialect) *scriptRegistry {
+	fmt.Fprintf(w, "#!/bin/sh\n")
+	fmt.Fprintf(w, "# Generated by oras-apply.\n")
+	fmt.Fprintf(w, "set -eu\n\n")
+	fmt.Fprintf(w, "registry=${OCI_REGISTRY:-%s}\n", shQuote(host))
+	fmt.Fprintf(w, "tmpRoot=$(mktemp -d)\n")
+	fmt.Fprintf(w, "trap 'rm -rf \"$tmpRoot\"' EXIT\n\n")
+	fmt.Fprintf(w, "%s\n", dialect.funcs)
 	return &scriptRegistry{
-		host: host,
+		w:       w,
+		dialect: dialect,
 	}
 }
 
 func (r *scriptRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
-	f, err := r.writeFile(content, desc.MediaType)
-	if err != nil {
-		return err
-	}
-	fmt.Printf("oras blob push --media-type %s %s/%s@%s %s\n", desc.MediaType, r.host, repoName, desc.Digest, f)
-	return nil
+	return r.push("push_blob", repoName, desc, content)
 }
 
 func (r *scriptRegistry) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
-	f, err := r.writeFile(content, desc.MediaType)
+	return r.push("push_manifest", repoName, desc, content)
+}
+
+func (r *scriptRegistry) push(cmd string, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	data, err := io.ReadAll(content)
 	if err != nil {
 		return err
 	}
-	fmt.Printf("oras manifest push --media-type %s %s/%s@%s %s\n", desc.MediaType, r.host, repoName, desc.Digest, f)
-	return nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var buf bytes.Buffer
+	f := r.writeFile(&buf, data)
+	fmt.Fprintf(&buf, "%s %s %s %s %s\n", cmd, shQuote(repoName), shQuote(string(desc.Digest)), shQuote(desc.MediaType), f)
+	return r.write(buf.Bytes())
 }
 
-func (r *scriptRegistry) Dump(ctx context.Context, stuff json.RawMessage) {
-	var p struct {
-		Script string `json:"script"`
-	}
-	if err := json.Unmarshal(stuff, &p); err != nil {
-		fmt.Printf("# bad script %q\n", stuff)
-		return
+// comment writes data to the script as a comment
+// with the given title.
+func (r *scriptRegistry) comment(title string, data []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# %s:\n", title)
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		fmt.Fprintf(&buf, "#\t%s\n", line)
 	}
-	fmt.Printf("%s\n", p.Script)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write(buf.Bytes())
 }
 
+// shQuote quotes s so that it is interpreted
+// literally by the shell.
 func shQuote(s string) string {
 	return `'` + strings.Replace(s, `'`, `'"'"'`, -1) + `'`
 }
 
 func (r *scriptRegistry) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
-	fmt.Printf("oras tag %s/%s@%s %s\n", r.host, repoName, desc.Digest, reference)
-	return nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write([]byte(fmt.Sprintf("tag %s %s %s %s\n", shQuote(repoName), shQuote(string(desc.Digest)), shQuote(reference), shQuote(desc.MediaType))))
 }
 
-func (r *scriptRegistry) writeFile(content io.Reader, mediaType string) (string, error) {
-	data, err := ioutil.ReadAll(content)
-	if err != nil {
-		return "", err
+func (r *scriptRegistry) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	return nil, fmt.Errorf("cannot query referrers when generating a script")
+}
+
+func (r *scriptRegistry) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	return ocispec.Descriptor{}, fmt.Errorf("cannot resolve references when generating a script")
+}
+
+func (r *scriptRegistry) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cannot fetch blobs when generating a script")
+}
+
+func (r *scriptRegistry) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("cannot fetch manifests when generating a script")
+}
+
+func (r *scriptRegistry) Tags(ctx context.Context, repoName string) ([]string, error) {
+	return nil, fmt.Errorf("cannot list tags when generating a script")
+}
+
+// Exists always reports that content does not exist,
+// so that the generated script contains every push.
+// The script itself checks whether content exists
+// before pushing it.
+func (r *scriptRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	return false, nil
+}
+
+func (r *scriptRegistry) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	if !r.dialect.canDeleteTags {
+		return fmt.Errorf("cannot delete tags in this script dialect (oras has no command to remove only a tag)")
 	}
-	f := r.tmpFile()
-	if utf8.Valid(data) {
-		fmt.Printf("> %s echo -n %s\n", f, shQuote(string(data)))
-		return f, nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write([]byte(fmt.Sprintf("delete_tag %s %s\n", shQuote(repoName), shQuote(tag))))
+}
+
+func (r *scriptRegistry) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.write([]byte(fmt.Sprintf("delete_manifest %s %s\n", shQuote(repoName), shQuote(string(desc.Digest)))))
+}
+
+// writeFile writes to w the commands needed to create a temporary file
+// holding data, and returns the (quoted) file name.
+// Called with r.mu held.
+func (r *scriptRegistry) writeFile(w io.Writer, data []byte) string {
+	r.fileID++
+	f := fmt.Sprintf(`"$tmpRoot/%d"`, r.fileID)
+	if isPrintable(data) {
+		fmt.Fprintf(w, "printf '%%s' %s > %s\n", shQuote(string(data)), f)
+		return f
 	}
+	fmt.Fprintf(w, "base64 -d > %s <<'EOF'\n", f)
 	s := base64.StdEncoding.EncodeToString(data)
-	fmt.Printf("echo %s | base64 -d > %s\n", s, f)
-	return f, nil
+	for len(s) > 76 {
+		fmt.Fprintf(w, "%s\n", s[:76])
+		s = s[76:]
+	}
+	fmt.Fprintf(w, "%s\nEOF\n", s)
+	return f
+}
+
+// isPrintable reports whether data is UTF-8 text made only of
+// printable characters, tabs and newlines, so that it can be held
+// literally in a quoted shell string. In particular, a shell string
+// can't hold NUL, which tar archives are full of.
+func isPrintable(data []byte) bool {
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if !unicode.IsPrint(r) && r != '\n' && r != '\t' {
+			return false
+		}
+	}
+	return true
 }
 
-func (r *scriptRegistry) tmpFile() string {
-	id := atomic.AddInt32(&r.fileID, 1)
-	return fmt.Sprintf("$tmpRoot/%d", id)
+// write writes a block of commands to the script.
+// Called with r.mu held.
+func (r *scriptRegistry) write(data []byte) error {
+	_, err := r.w.Write(data)
+	return err
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var writeFileTests = []struct {
+	testName string
+	data     string
+	literal  bool
+}{{
+	testName: "Empty",
+	data:     "",
+	literal:  true,
+}, {
+	testName: "Text",
+	data:     "hello, world\n\tindented\n",
+	literal:  true,
+}, {
+	testName: "ShellSyntax",
+	data:     `it's "$HOME" and $(date) and \n` + "`id`",
+	literal:  true,
+}, {
+	testName: "NonASCII",
+	data:     "héllo, 世界",
+	literal:  true,
+}, {
+	testName: "NUL",
+	data:     "a\x00b",
+}, {
+	testName: "CarriageReturn",
+	data:     "a\r\nb",
+}, {
+	testName: "Escape",
+	data:     "\x1b[31mred",
+}, {
+	testName: "InvalidUTF8",
+	data:     "\xff\xfe",
+}, {
+	testName: "TarHeader",
+	data:     "bin/tool" + strings.Repeat("\x00", 92) + "0000755\x00" + strings.Repeat("\x00", 300),
+}}
+
+func TestWriteFile(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh available")
+	}
+	for _, test := range writeFileTests {
+		t.Run(test.testName, func(t *testing.T) {
+			r := &scriptRegistry{}
+			var buf bytes.Buffer
+			f := r.writeFile(&buf, []byte(test.data))
+			script := buf.String()
+			if strings.Contains(script, "\x00") {
+				t.Fatalf("script contains NUL:\n%q", script)
+			}
+			if got := strings.HasPrefix(script, "printf "); got != test.literal {
+				t.Errorf("literal content: got %v want %v; script:\n%s", got, test.literal, script)
+			}
+			tmpRoot := t.TempDir()
+			cmd := exec.Command(sh, "-c", "set -eu\n"+script)
+			cmd.Env = append(os.Environ(), "tmpRoot="+tmpRoot)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				t.Fatalf("script failed: %v\n%s\nscript:\n%s", err, out, script)
+			}
+			if f != `"$tmpRoot/1"` {
+				t.Fatalf("unexpected file name %s", f)
+			}
+			got, err := os.ReadFile(filepath.Join(tmpRoot, "1"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.data {
+				t.Errorf("file holds %q; want %q", got, test.data)
+			}
+		})
+	}
+}
+
+func TestScriptPush(t *testing.T) {
+	var buf bytes.Buffer
+	r := newScriptRegistry(&buf, "localhost:5000", scriptDialects["oras"])
+	data := []byte("it's here")
+	desc := ocispec.Descriptor{
+		MediaType: "text/plain",
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := r.Push(context.Background(), "foo/bar", desc, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Tag(context.Background(), "foo/bar", desc, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	script := buf.String()
+	for _, want := range []string{
+		"registry=${OCI_REGISTRY:-'localhost:5000'}\n",
+		`printf '%s' 'it'"'"'s here' > "$tmpRoot/1"` + "\n",
+		`push_blob 'foo/bar' '` + string(desc.Digest) + `' 'text/plain' "$tmpRoot/1"` + "\n",
+		`tag 'foo/bar' '` + string(desc.Digest) + `' 'v1' 'text/plain'` + "\n",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("script does not contain %q; got:\n%s", want, script)
+		}
+	}
+	if err := r.DeleteTag(context.Background(), "foo/bar", "v1"); err == nil {
+		t.Errorf("expected error deleting a tag in the oras dialect")
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// layoutOutput is the registry used when the -output flag is given.
+// It writes everything to an OCI image layout, which is
+// archived to tarFile when finished if that's non-empty.
+type layoutOutput struct {
+	*orasflow.LayoutRegistry
+	dir     string
+	tarFile string
+}
+
+// newLayoutOutput returns the registry for an -output flag value
+// of the form layout:dir or tar:file.
+func newLayoutOutput(output string) (*layoutOutput, error) {
+	kind, file, ok := strings.Cut(output, ":")
+	if !ok || file == "" {
+		return nil, fmt.Errorf("invalid -output value %q (want layout:dir or tar:file)", output)
+	}
+	out := &layoutOutput{}
+	switch kind {
+	case "layout":
+		out.dir = file
+	case "tar":
+		dir, err := os.MkdirTemp("", "oras-apply-")
+		if err != nil {
+			return nil, err
+		}
+		out.dir = dir
+		out.tarFile = file
+	default:
+		return nil, fmt.Errorf("unknown -output kind %q (want layout or tar)", kind)
+	}
+	r, err := orasflow.OpenLayout(out.dir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open image layout: %v", err)
+	}
+	out.LayoutRegistry = r
+	return out, nil
+}
+
+// finish writes the layout's index and the tar archive,
+// if there is one. If the flow failed with the non-nil
+// error err, any temporary directory is removed and
+// err is returned.
+func (out *layoutOutput) finish(err error) error {
+	if err != nil {
+		if out.tarFile != "" {
+			os.RemoveAll(out.dir)
+		}
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("cannot write image layout index: %v", err)
+	}
+	if out.tarFile == "" {
+		return nil
+	}
+	defer os.RemoveAll(out.dir)
+	f, err := os.Create(out.tarFile)
+	if err != nil {
+		return err
+	}
+	if err := out.WriteTar(f); err != nil {
+		f.Close()
+		return fmt.Errorf("cannot write %s: %v", out.tarFile, err)
+	}
+	return f.Close()
+}
+
+// runImport pushes the contents of the image layout in file,
+// which may be a directory or a tar archive written by -output,
+// to the registry.
+func runImport(file string) error {
+	ctx := context.Background()
+	registry, err := getRegistry(ctx)
+	if err != nil {
+		return err
+	}
+	diff, ok := registry.(*diffRegistry)
+	if !ok {
+		return fmt.Errorf("import is only supported when talking to a registry")
+	}
+	if err := importLayout(ctx, file, diff); err != nil {
+		return err
+	}
+	diff.printChanges(os.Stdout)
+	return nil
+}
+
+// importLayout copies the contents of the image layout
+// in file, a directory or a tar archive, to registry.
+func importLayout(ctx context.Context, file string, registry orasflow.Registry) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	dir := file
+	if !info.IsDir() {
+		dir, err = os.MkdirTemp("", "oras-apply-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = orasflow.ExtractLayoutTar(f, dir)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("cannot extract %s: %v", file, err)
+		}
+	}
+	layout, err := orasflow.OpenLayout(dir)
+	if err != nil {
+		return fmt.Errorf("cannot open image layout: %v", err)
+	}
+	return layout.CopyTo(ctx, registry)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"cuelang.org/go/cue/errors"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// TestImport checks that importing the output of -output
+// into a registry leaves it just as applying the configuration
+// to the registry would.
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	for _, dir := range []string{"index", "referrers", "simple"} {
+		for _, kind := range []string{"layout", "tar"} {
+			t.Run(dir+"/"+kind, func(t *testing.T) {
+				inst, v := loadTestdata(t, dir)
+				file := filepath.Join(t.TempDir(), "out")
+				out, err := newLayoutOutput(kind + ":" + file)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := runFlow(ctx, v, out, &orasflow.Options{Dir: inst.Dir}); err != nil {
+					t.Fatalf("cannot write output: %v", errors.Details(err, nil))
+				}
+				mr := ociregistry.NewMemRegistry()
+				if err := importLayout(ctx, file, orasflow.RegistryFromInterface(mr)); err != nil {
+					t.Fatalf("cannot import: %v", err)
+				}
+				diff := newDiffRegistry(orasflow.RegistryFromInterface(mr), false)
+				if err := runFlow(ctx, v, diff, &orasflow.Options{Dir: inst.Dir}); err != nil {
+					t.Fatal(err)
+				}
+				for _, c := range diff.sortedChanges() {
+					if c.op != opUnchanged {
+						t.Errorf("import did not push %s %s:%s", c.kind, c.repo, c.name)
+					}
+				}
+			})
+		}
+	}
+}
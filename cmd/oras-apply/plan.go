@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/errdef"
+	"oras.land/oras-go/v2/registry/remote/errcode"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// diffRegistry wraps a registry, comparing everything that's
+// pushed against what's already there. When apply is false,
+// the underlying registry is never changed; otherwise only
+// content that's missing or different is pushed.
+type diffRegistry struct {
+	r     orasflow.Registry
+	apply bool
+
+	mu      sync.Mutex
+	changes []change
+	// contents holds content that would have been pushed in plan mode,
+	// so that later tasks can read it back.
+	contents map[repoDigest]storedContent
+	// tags holds all the tags declared by the configuration,
+	// keyed by repository.
+	tags map[string]map[string]ocispec.Descriptor
+	// referrers holds the referrers of manifests pushed in plan mode,
+	// keyed by subject.
+	referrers map[repoDigest][]ocispec.Descriptor
+}
+
+type repoDigest struct {
+	repo   string
+	digest digest.Digest
+}
+
+// change describes a single difference between the configuration
+// and the registry.
+type change struct {
+	op   changeOp
+	kind string // blob, manifest or tag
+	repo string
+	// name holds the tag name for tag changes
+	// and the digest for other kinds.
+	name string
+	desc ocispec.Descriptor
+	old  ocispec.Descriptor // previous descriptor for tag changes.
+	from string             // repository that a blob was mounted from.
+}
+
+type changeOp byte
+
+const (
+	opUnchanged changeOp = '='
+	opAdd       changeOp = '+'
+	opChange    changeOp = '~'
+	opDelete    changeOp = '-'
+)
+
+func newDiffRegistry(r orasflow.Registry, apply bool) *diffRegistry {
+	return &diffRegistry{
+		r:         r,
+		apply:     apply,
+		contents:  make(map[repoDigest]storedContent),
+		tags:      make(map[string]map[string]ocispec.Descriptor),
+		referrers: make(map[repoDigest][]ocispec.Descriptor),
+	}
+}
+
+func (r *diffRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	return r.push(ctx, "blob", repoName, desc, content, r.r.Push)
+}
+
+func (r *diffRegistry) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	return r.push(ctx, "manifest", repoName, desc, content, r.r.PushManifest)
+}
+
+// push records a push of content that orasflow
+// has already found to be missing from the registry.
+func (r *diffRegistry) push(
+	ctx context.Context,
+	kind string,
+	repoName string,
+	desc ocispec.Descriptor,
+	content io.Reader,
+	push func(context.Context, string, ocispec.Descriptor, io.Reader) error,
+) error {
+	r.addChange(change{
+		op:   opAdd,
+		kind: kind,
+		repo: repoName,
+		name: string(desc.Digest),
+		desc: desc,
+	})
+	if r.apply {
+		return push(ctx, repoName, desc, content)
+	}
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("cannot read content: %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contents[repoDigest{repoName, desc.Digest}] = storedContent{
+		desc: desc,
+		data: data,
+	}
+	if kind != "manifest" {
+		return nil
+	}
+	var m struct {
+		ArtifactType string              `json:"artifactType"`
+		Config       ocispec.Descriptor  `json:"config"`
+		Subject      *ocispec.Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil || m.Subject == nil {
+		return nil
+	}
+	if desc.ArtifactType = m.ArtifactType; desc.ArtifactType == "" {
+		desc.ArtifactType = m.Config.MediaType
+	}
+	subject := repoDigest{repoName, m.Subject.Digest}
+	r.referrers[subject] = append(r.referrers[subject], desc)
+	return nil
+}
+
+func (r *diffRegistry) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
+	c := change{
+		op:   opAdd,
+		kind: "tag",
+		repo: repoName,
+		name: reference,
+		desc: desc,
+	}
+	old, err := r.r.Resolve(ctx, repoName, reference)
+	switch {
+	case err == nil && old.Digest == desc.Digest:
+		c.op = opUnchanged
+	case err == nil:
+		c.op = opChange
+		c.old = old
+	case !isNotFound(err):
+		return fmt.Errorf("cannot resolve existing tag: %v", err)
+	}
+	r.addChange(c)
+	r.mu.Lock()
+	if r.tags[repoName] == nil {
+		r.tags[repoName] = make(map[string]ocispec.Descriptor)
+	}
+	r.tags[repoName][reference] = desc
+	r.mu.Unlock()
+	if c.op == opUnchanged || !r.apply {
+		return nil
+	}
+	return r.r.Tag(ctx, repoName, desc, reference)
+}
+
+func (r *diffRegistry) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	old, err := r.r.Resolve(ctx, repoName, tag)
+	if err != nil {
+		if isNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("cannot resolve existing tag: %v", err)
+	}
+	r.addChange(change{
+		op:   opDelete,
+		kind: "tag",
+		repo: repoName,
+		name: tag,
+		old:  old,
+	})
+	if !r.apply {
+		return nil
+	}
+	return r.deleter().DeleteTag(ctx, repoName, tag)
+}
+
+func (r *diffRegistry) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	r.addChange(change{
+		op:   opDelete,
+		kind: "manifest",
+		repo: repoName,
+		name: string(desc.Digest),
+		old:  desc,
+	})
+	if !r.apply {
+		return nil
+	}
+	return r.deleter().DeleteManifest(ctx, repoName, desc)
+}
+
+func (r *diffRegistry) deleter() orasflow.Deleter {
+	if d, ok := r.r.(orasflow.Deleter); ok {
+		return d
+	}
+	return noDeleter{}
+}
+
+type noDeleter struct{}
+
+func (noDeleter) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	return fmt.Errorf("registry does not support deletion")
+}
+
+func (noDeleter) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	return fmt.Errorf("registry does not support deletion")
+}
+
+// The read operations below also see content that has been
+// pushed in plan mode, as if it had actually been pushed.
+
+func (r *diffRegistry) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	r.mu.Lock()
+	desc, ok := r.tags[repoName][reference]
+	if !ok {
+		var c storedContent
+		c, ok = r.contents[repoDigest{repoName, digest.Digest(reference)}]
+		desc = c.desc
+	}
+	r.mu.Unlock()
+	if ok {
+		return desc, nil
+	}
+	return r.r.Resolve(ctx, repoName, reference)
+}
+
+func (r *diffRegistry) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if data, ok := r.pending(repoName, desc); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return r.r.Fetch(ctx, repoName, desc)
+}
+
+func (r *diffRegistry) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if data, ok := r.pending(repoName, desc); ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return r.r.FetchManifest(ctx, repoName, desc)
+}
+
+// Exists implements [orasflow.Exister].
+// orasflow checks for existing content before every push,
+// so content that's already in the registry is recorded as unchanged.
+func (r *diffRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	if _, ok := r.pending(repoName, desc); ok {
+		return true, nil
+	}
+	exister, ok := r.r.(orasflow.Exister)
+	if !ok {
+		return false, nil
+	}
+	exists, err := exister.Exists(ctx, repoName, desc)
+	if err != nil || !exists {
+		return exists, err
+	}
+	kind := "blob"
+	if ociregistry.IsManifest(desc.MediaType) {
+		kind = "manifest"
+	}
+	r.addChange(change{
+		op:   opUnchanged,
+		kind: kind,
+		repo: repoName,
+		name: string(desc.Digest),
+		desc: desc,
+	})
+	return true, nil
+}
+
+// Mount implements [orasflow.Mounter]. In plan mode it always
+// fails, so that the blob is pushed (and recorded) instead.
+func (r *diffRegistry) Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error {
+	mounter, ok := r.r.(orasflow.Mounter)
+	if !r.apply || !ok {
+		return fmt.Errorf("cannot mount")
+	}
+	if err := mounter.Mount(ctx, repoName, fromRepo, desc); err != nil {
+		return err
+	}
+	r.addChange(change{
+		op:   opAdd,
+		kind: "blob",
+		repo: repoName,
+		name: string(desc.Digest),
+		desc: desc,
+		from: fromRepo,
+	})
+	return nil
+}
+
+func (r *diffRegistry) Tags(ctx context.Context, repoName string) ([]string, error) {
+	tags, err := r.existingTags(ctx, repoName)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for tag := range r.tags[repoName] {
+		if !contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+func (r *diffRegistry) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	descs, err := r.r.Referrers(ctx, repoName, desc, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, referrer := range r.referrers[repoDigest{repoName, desc.Digest}] {
+		if artifactType == "" || referrer.ArtifactType == artifactType {
+			descs = append(descs, referrer)
+		}
+	}
+	return descs, nil
+}
+
+func (r *diffRegistry) pending(repoName string, desc ocispec.Descriptor) ([]byte, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.contents[repoDigest{repoName, desc.Digest}]
+	return c.data, ok
+}
+
+// existingTags returns the tags that exist in the underlying registry,
+// treating a repository that doesn't exist as empty.
+func (r *diffRegistry) existingTags(ctx context.Context, repoName string) ([]string, error) {
+	tags, err := r.r.Tags(ctx, repoName)
+	if err != nil && !isNotFound(err) {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// isNotFound reports whether err indicates that a tag
+// or repository does not exist.
+func isNotFound(err error) bool {
+	if errors.Is(err, errdef.ErrNotFound) || ociregistry.IsNotFound(err) {
+		return true
+	}
+	var errResp *errcode.ErrorResponse
+	return errors.As(err, &errResp) && errResp.StatusCode == http.StatusNotFound
+}
+
+// referrersTagPat matches tags created by the referrers tag schema.
+// These are managed by the registry client rather than the
+// configuration, so they're never pruned.
+var referrersTagPat = regexp.MustCompile(`^sha256-[a-f0-9]{64}$`)
+
+// prune deletes all tags in the repositories managed by the
+// configuration that are not declared by it.
+// A repository is managed if the configuration pushes anything to it.
+func (r *diffRegistry) prune(ctx context.Context) error {
+	for _, repoName := range r.managedRepos() {
+		tags, err := r.existingTags(ctx, repoName)
+		if err != nil {
+			return fmt.Errorf("cannot list tags in %q: %v", repoName, err)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			r.mu.Lock()
+			_, declared := r.tags[repoName][tag]
+			r.mu.Unlock()
+			if declared || referrersTagPat.MatchString(tag) {
+				continue
+			}
+			if err := r.DeleteTag(ctx, repoName, tag); err != nil {
+				return fmt.Errorf("cannot prune tag %s:%s: %v", repoName, tag, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *diffRegistry) managedRepos() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var repos []string
+	for _, c := range r.changes {
+		if c.op != opDelete && !contains(repos, c.repo) {
+			repos = append(repos, c.repo)
+		}
+	}
+	sort.Strings(repos)
+	return repos
+}
+
+func (r *diffRegistry) addChange(c change) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, c1 := range r.changes {
+		if c1.kind == c.kind && c1.repo == c.repo && c1.name == c.name && c.op != opDelete {
+			// The same content can be pushed more than once,
+			// but we only want to see it once.
+			return
+		}
+	}
+	r.changes = append(r.changes, c)
+}
+
+// printChanges prints all the changes in a deterministic order.
+func (r *diffRegistry) printChanges(w io.Writer) {
+	changes := r.sortedChanges()
+	counts := make(map[changeOp]int)
+	for _, c := range changes {
+		counts[c.op]++
+		switch {
+		case c.from != "":
+			fmt.Fprintf(w, "%c %-8s %s@%s (%s, %d bytes, mounted from %s)\n", c.op, c.kind, c.repo, c.name, c.desc.MediaType, c.desc.Size, c.from)
+		case c.kind != "tag":
+			fmt.Fprintf(w, "%c %-8s %s@%s (%s, %d bytes)\n", c.op, c.kind, c.repo, c.name, c.desc.MediaType, c.desc.Size)
+		case c.op == opChange:
+			fmt.Fprintf(w, "%c %-8s %s:%s %s -> %s\n", c.op, c.kind, c.repo, c.name, c.old.Digest, c.desc.Digest)
+		case c.op == opDelete:
+			fmt.Fprintf(w, "%c %-8s %s:%s (was %s)\n", c.op, c.kind, c.repo, c.name, c.old.Digest)
+		default:
+			fmt.Fprintf(w, "%c %-8s %s:%s -> %s\n", c.op, c.kind, c.repo, c.name, c.desc.Digest)
+		}
+	}
+	fmt.Fprintf(w, "%d new, %d changed, %d deleted, %d unchanged\n", counts[opAdd], counts[opChange], counts[opDelete], counts[opUnchanged])
+}
+
+// sortedChanges returns all the recorded changes
+// sorted by repository, kind and name.
+func (r *diffRegistry) sortedChanges() []change {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changes := append([]change(nil), r.changes...)
+	sort.SliceStable(changes, func(i, j int) bool {
+		c0, c1 := changes[i], changes[j]
+		if c0.repo != c1.repo {
+			return c0.repo < c1.repo
+		}
+		if c0.kind != c1.kind {
+			return kindOrder[c0.kind] < kindOrder[c1.kind]
+		}
+		return c0.name < c1.name
+	})
+	return changes
+}
+
+var kindOrder = map[string]int{
+	"blob":     0,
+	"manifest": 1,
+	"tag":      2,
+}
+
+func contains(xs []string, x string) bool {
+	for _, y := range xs {
+		if y == x {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+func TestPrune(t *testing.T) {
+	for _, apply := range []bool{false, true} {
+		ctx := context.Background()
+		mr := ociregistry.NewMemRegistry()
+		base := orasflow.RegistryFromInterface(mr)
+		m1 := pushTestManifest(t, base, "repo", "one")
+		m2 := pushTestManifest(t, base, "repo", "two")
+		other := pushTestManifest(t, base, "other", "other")
+		referrersTag := "sha256-" + m1.Digest.Encoded()
+		for _, tag := range []struct {
+			repo string
+			desc ocispec.Descriptor
+			name string
+		}{
+			{"repo", m1, "v1"},
+			{"repo", m1, "old"},
+			{"repo", m2, "moved"},
+			{"repo", m1, referrersTag},
+			{"other", other, "unmanaged"},
+		} {
+			if err := base.Tag(ctx, tag.repo, tag.desc, tag.name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The configuration declares v1 and moved,
+		// and a new tag, all in repo.
+		r := newDiffRegistry(base, apply)
+		for _, tag := range []struct {
+			desc ocispec.Descriptor
+			name string
+		}{
+			{m1, "v1"},
+			{m1, "moved"},
+			{m2, "new"},
+		} {
+			if err := r.Tag(ctx, "repo", tag.desc, tag.name); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := r.prune(ctx); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		r.printChanges(&buf)
+		want := strings.Join([]string{
+			"~ tag      repo:moved " + string(m2.Digest) + " -> " + string(m1.Digest),
+			"+ tag      repo:new -> " + string(m2.Digest),
+			"- tag      repo:old (was " + string(m1.Digest) + ")",
+			"= tag      repo:v1 -> " + string(m1.Digest),
+			"1 new, 1 changed, 1 deleted, 1 unchanged",
+			"",
+		}, "\n")
+		if got := buf.String(); got != want {
+			t.Errorf("apply %v: got changes:\n%s\nwant:\n%s", apply, got, want)
+		}
+
+		tags, err := ociregistry.All(mr.Tags(ctx, "repo", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantTags := []string{"moved", "old", "v1", referrersTag}
+		if apply {
+			wantTags = []string{"moved", "new", "v1", referrersTag}
+		}
+		sort.Strings(wantTags)
+		if strings.Join(tags, " ") != strings.Join(wantTags, " ") {
+			t.Errorf("apply %v: got tags %q; want %q", apply, tags, wantTags)
+		}
+		if tags, _ := ociregistry.All(mr.Tags(ctx, "other", nil)); len(tags) != 1 {
+			t.Errorf("apply %v: tags in unmanaged repository changed to %q", apply, tags)
+		}
+	}
+}
+
+// pushTestManifest pushes an empty image manifest with
+// the given annotation value to repo in r.
+func pushTestManifest(t *testing.T, r orasflow.Registry, repo, name string) ocispec.Descriptor {
+	ctx := context.Background()
+	config := []byte("{}")
+	configDesc := ocispec.Descriptor{
+		MediaType: "application/vnd.oci.empty.v1+json",
+		Digest:    digest.FromBytes(config),
+		Size:      int64(len(config)),
+	}
+	if err := r.Push(ctx, repo, configDesc, bytes.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"config":        configDesc,
+		"layers":        []ocispec.Descriptor{},
+		"annotations":   map[string]string{"name": name},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := r.PushManifest(ctx, repo, desc, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// outputWriter implements orasflow.Options.Output by writing
+// outputs to standard output or files, and recording results
+// so they can be written with the -results flag.
+type outputWriter struct {
+	// stdout receives outputs written to standard output.
+	stdout io.Writer
+
+	// script, if non-nil, receives outputs written to standard
+	// output as comments instead, so that the script stays valid.
+	script *scriptRegistry
+
+	// json, if non-nil, receives outputs written to standard
+	// output as JSON objects instead.
+	json *jsonWriter
+
+	// writeFiles holds whether file outputs are written.
+	// They're not when nothing is being changed.
+	writeFiles bool
+
+	// results holds the value of each results output, by name.
+	results map[string]json.RawMessage
+}
+
+func newOutputWriter() *outputWriter {
+	return &outputWriter{
+		stdout:  os.Stdout,
+		results: make(map[string]json.RawMessage),
+	}
+}
+
+// output implements orasflow.Options.Output.
+func (w *outputWriter) output(o orasflow.Output) error {
+	switch o.To {
+	case "file":
+		if !w.writeFiles {
+			return nil
+		}
+		return os.WriteFile(o.File, o.Data, 0o666)
+	case "results":
+		if old, ok := w.results[o.Name]; ok && !bytes.Equal(old, o.Value) {
+			return fmt.Errorf("conflicting values for result %q", o.Name)
+		}
+		w.results[o.Name] = o.Value
+		return nil
+	}
+	switch {
+	case w.json != nil:
+		w.json.output(o)
+		return nil
+	case w.script != nil:
+		return w.script.comment(fmt.Sprintf("output %v", o.Task), o.Data)
+	}
+	_, err := w.stdout.Write(o.Data)
+	return err
+}
+
+// writeResults writes the results document,
+// a JSON object holding all the results by name, to file.
+func (w *outputWriter) writeResults(file string) error {
+	data, err := json.MarshalIndent(w.results, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0o666)
+}
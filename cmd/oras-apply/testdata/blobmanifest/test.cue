@@ -36,7 +36,7 @@ entities: {
 		manifest:  oras.#manifest & {
 			mediaType: _
 			artifactType: "ok-manifest"
-			config: oras.scratchConfig
+			config: scratchConfig.desc
 			layers: [
 				blobManifest.desc
 			]
@@ -46,11 +46,14 @@ entities: {
 		name: "test"
 		desc: actualManifest.desc
 	}
-	dump: oras.#repoDump & {
-		repo: _
-		script: """
-		oras blob delete -f \(host)/\(repo)@\(unreferencedBlob.desc.digest)
-		oras copy -r \(host)/\(repo):\(tag.name) \(host)/\(repo)-copy:\(tag.name)
+}
+
+// script prints the commands needed to delete the unreferenced
+// blob and copy the tagged manifest once everything is pushed.
+script: oras.#output & {
+	_repo: entities.tag.repo
+	value: """
+		oras blob delete -f \(host)/\(_repo)@\(entities.unreferencedBlob.desc.digest)
+		oras copy -r \(host)/\(_repo):\(entities.tag.name) \(host)/\(_repo)-copy:\(entities.tag.name)
 		"""
-	}
 }
@@ -0,0 +1,58 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+staging: [_]: repo: "staging/app"
+staging: {
+	scratch: oras.#repoBlob & {
+		desc:   oras.scratchConfig
+		source: {}
+	}
+	content: oras.#repoBlob & {
+		desc: mediaType: "text/plain"
+		source: "release candidate"
+	}
+	app: oras.#repoManifest & {
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-app"
+			config:       scratch.desc
+			layers: [content.desc]
+		}
+	}
+	notes: oras.#repoBlob & {
+		desc: mediaType: "text/markdown"
+		source: "# Release notes"
+	}
+	docs: oras.#repoManifest & {
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-release-notes"
+			config:       scratch.desc
+			layers: [notes.desc]
+			subject: app.desc
+		}
+	}
+	tag: oras.#repoTag & {
+		name: "v1.2.0"
+		desc: app.desc
+	}
+}
+
+// promote copies the staged release, along with
+// its release notes, to the production repository.
+promote: oras.#copy & {
+	from: {
+		repo:      staging.tag.repo
+		reference: staging.tag.name
+	}
+	to: {
+		repo: "prod/app"
+		tag:  "v1.2.0"
+	}
+	includeReferrers: true
+
+	// Make sure that the release notes have been
+	// pushed before copying.
+	_after: staging.docs.desc
+}
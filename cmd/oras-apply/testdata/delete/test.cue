@@ -0,0 +1,43 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+_repo: "delete-test"
+
+scratch: oras.#repoBlob & {
+	repo:   _repo
+	desc:   oras.scratchConfig
+	source: {}
+}
+
+release: [version=_]: {
+	manifest: oras.#repoManifest & {
+		repo: _repo
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-release"
+			config:       scratch.desc
+			layers: []
+			annotations: "org.opencontainers.image.version": version
+		}
+	}
+	tag: oras.#repoTag & {
+		repo: _repo
+		name: version
+		desc: manifest.desc
+	}
+}
+release: "v0.1.0": _
+release: "v0.2.0": _
+
+// Retire v0.1.0: remove its tag and then the manifest itself.
+retireTag: oras.#deleteTag & {
+	repo: release["v0.1.0"].tag.repo
+	name: release["v0.1.0"].tag.name
+}
+
+retireManifest: oras.#deleteManifest & {
+	repo: _repo
+	desc: release["v0.1.0"].manifest.desc
+	_after: retireTag.name
+}
@@ -0,0 +1,50 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+_repo: "index-test"
+
+scratch: oras.#repoBlob & {
+	repo:   _repo
+	desc:   oras.scratchConfig
+	source: {}
+}
+
+// variants holds one artifact for each CUE version
+// that we're building for.
+variants: [cueVersion=_]: {
+	content: oras.#repoBlob & {
+		repo: _repo
+		desc: mediaType: "text/plain"
+		source: "built with CUE \(cueVersion)"
+	}
+	manifest: oras.#repoManifest & {
+		repo: _repo
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-variant"
+			config:       scratch.desc
+			layers: [content.desc]
+		}
+	}
+}
+variants: "v0.5.0": _
+variants: "v0.6.0": _
+
+index: oras.#repoIndex & {
+	repo: _repo
+	manifests: [
+		for cueVersion, v in variants {
+			v.manifest.desc & {
+				annotations: "org.cuelang.version": cueVersion
+			}
+		},
+	]
+	annotations: "org.opencontainers.image.title": "multi-variant example"
+}
+
+tag: oras.#repoTag & {
+	repo: _repo
+	name: "latest"
+	desc: index.desc
+}
@@ -0,0 +1,46 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+_repo: "output-test"
+
+settings: oras.#repoBlob & {
+	repo: _repo
+	desc: mediaType: "application/x-config+json"
+	source: version: "1.0.0"
+}
+
+manifest: oras.#repoManifest & {
+	repo: _repo
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-output-test"
+		config:       settings.desc
+		layers: []
+	}
+}
+
+tag: oras.#repoTag & {
+	repo: _repo
+	name: "v1.0.0"
+	desc: manifest.desc
+}
+
+// The tasks below report what was published
+// once the tag has been pushed.
+
+published: oras.#output & {
+	value: "\(tag.repo):\(tag.name)@\(tag.desc.digest)"
+}
+
+digestFile: oras.#output & {
+	to:    "file"
+	file:  "digest.txt"
+	value: tag.desc.digest
+}
+
+descriptor: oras.#output & {
+	to:    "results"
+	name:  "manifest"
+	value: tag.desc
+}
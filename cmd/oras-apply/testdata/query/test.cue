@@ -0,0 +1,61 @@
+package test
+
+import (
+	"list"
+
+	"github.com/cue-exp/oras"
+)
+
+_repo: "query-test"
+
+settings: oras.#repoBlob & {
+	repo: _repo
+	desc: mediaType: "application/x-config+json"
+	source: greeting: "hello"
+}
+
+manifest: oras.#repoManifest & {
+	repo: _repo
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-query-test"
+		config:       settings.desc
+		layers: []
+	}
+}
+
+tag: oras.#repoTag & {
+	repo: _repo
+	name: "v1.0.0"
+	desc: manifest.desc
+}
+
+// The tasks below read back what was pushed above.
+
+tags: oras.#listTags & {
+	// Refer to the tag so that we list tags after it has been pushed.
+	repo: tag.repo
+}
+
+latest: oras.#fetchManifest & {
+	repo:      _repo
+	reference: list.Sort(tags.tags, list.Descending)[0]
+}
+
+latestConfig: oras.#fetchBlob & {
+	repo: _repo
+	desc: latest.manifest.config
+}
+
+// copyOfLatest makes a new manifest that includes
+// the config of the latest tagged manifest.
+copyOfLatest: oras.#repoManifest & {
+	repo: _repo
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-query-test-copy"
+		config:       latest.manifest.config
+		layers: []
+		annotations: greeting: latestConfig.content.greeting
+	}
+}
@@ -0,0 +1,48 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+entities: [_]: repo: "referrers-test"
+entities: {
+	scratch: oras.#repoBlob & {
+		desc:   oras.scratchConfig
+		source: {}
+	}
+	content: oras.#repoBlob & {
+		desc: mediaType: "text/plain"
+		source: "some content"
+	}
+	artifact: oras.#repoManifest & {
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-artifact"
+			config:       scratch.desc
+			layers: [content.desc]
+		}
+	}
+	sbomContent: oras.#repoBlob & {
+		desc: mediaType: "application/spdx+json"
+		source: {
+			spdxVersion: "SPDX-2.3"
+			name:        "artifact"
+		}
+	}
+	// sbom is attached to artifact as a referrer.
+	sbom: oras.#repoManifest & {
+		manifest: {
+			mediaType:    _
+			artifactType: "application/spdx+json"
+			config:       scratch.desc
+			layers: [sbomContent.desc]
+			subject: artifact.desc
+		}
+	}
+	tag: oras.#repoTag & {
+		name: "v1"
+		desc: artifact.desc
+	}
+	sboms: oras.#repoReferrers & {
+		subject:      sbom.manifest.subject
+		artifactType: "application/spdx+json"
+	}
+}
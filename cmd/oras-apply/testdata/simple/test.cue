@@ -6,6 +6,7 @@ blobs: [_]: oras.#repoBlob
 
 manifests: [_]: oras.#repoManifest & {
 	manifest: schemaVersion: _
+	manifest: mediaType:     _
 }
 
 tags: [name=_]: oras.#repoTag & {
@@ -39,7 +40,8 @@ manifests: {
 	bar: {
 		repo: _module
 		manifest: {
-			config: blobs.foo.desc
+			config:       blobs.foo.desc
+			artifactType: "application/x-simple"
 			layers: [
 				blobs.someContent.desc,
 			]
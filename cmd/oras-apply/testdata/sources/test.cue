@@ -0,0 +1,76 @@
+package test
+
+import "github.com/cue-exp/oras"
+
+_repo: "sources-test"
+
+blobs: [_]: oras.#repoBlob & {
+	repo: _repo
+}
+blobs: {
+	binary: {
+		desc: mediaType: "application/octet-stream"
+		source: base64: "AAECAwT/"
+	}
+	file: {
+		desc: mediaType: "text/plain"
+		source: file: "hello.txt"
+	}
+	layer: {
+		desc: mediaType: "application/vnd.oci.image.layer.v1.tar"
+		source: tar: {
+			"etc/motd": "welcome\n"
+			"bin/tool": {
+				mode:   0o755
+				base64: "f0VMRg=="
+			}
+		}
+	}
+	gzipLayer: {
+		desc: mediaType: "application/vnd.oci.image.layer.v1.tar+gzip"
+		source: tarGzip: {
+			"etc/motd":  "welcome\n"
+			"hello.txt": file: "hello.txt"
+		}
+	}
+	module: {
+		desc: mediaType: "application/zip"
+		source: zip: {
+			"cue.mod/module.cue": lf: "module: \"example.com/m\"\r\n"
+			"m.cue":              file: "hello.txt"
+		}
+	}
+	zstdLayer: {
+		desc: mediaType: "application/vnd.oci.image.layer.v1.tar+zstd"
+		source: zstd: tar: "etc/motd": "welcome\n"
+	}
+}
+
+manifest: oras.#repoManifest & {
+	repo: _repo
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-sources-test"
+		config:       scratch.desc
+		layers: [
+			blobs.layer.desc,
+			blobs.gzipLayer.desc,
+			blobs.zstdLayer.desc,
+			blobs.binary.desc,
+			blobs.file.desc,
+			blobs.module.desc,
+		]
+	}
+}
+
+scratch: oras.#repoBlob & {
+	repo: _repo
+	desc: oras.scratchConfig
+	source: {}
+}
+
+tag: oras.#repoTag & {
+	repo: _repo
+	name: "v1"
+	desc: manifest.desc
+}
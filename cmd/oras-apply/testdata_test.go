@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+// TestTestdata applies the configuration in each directory in
+// testdata to an in-memory registry, and then checks that
+// applying it again finds nothing to change.
+func TestTestdata(t *testing.T) {
+	dirs, err := filepath.Glob(filepath.Join("testdata", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no testdata directories found")
+	}
+	for _, dir := range dirs {
+		dir := dir
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			ctx := context.Background()
+			inst := load.Instances([]string{"."}, &load.Config{Dir: dir})[0]
+			if err := inst.Err; err != nil {
+				t.Fatalf("cannot load instance: %v", errors.Details(err, nil))
+			}
+			v := cuecontext.New().BuildInstance(inst)
+			if err := v.Err(); err != nil {
+				t.Fatalf("cannot build instance: %v", errors.Details(err, nil))
+			}
+			mr := ociregistry.NewMemRegistry()
+			apply := func(apply bool) *diffRegistry {
+				r := newDiffRegistry(orasflow.RegistryFromInterface(mr), apply)
+				err := orasflow.Apply(ctx, v, r, &orasflow.Options{
+					Dir: inst.Dir,
+					// Don't write output files into testdata.
+					Output: func(orasflow.Output) error {
+						return nil
+					},
+				})
+				if err != nil {
+					t.Fatalf("cannot apply: %v", errors.Details(err, nil))
+				}
+				return r
+			}
+			first := apply(true)
+			var buf bytes.Buffer
+			first.printChanges(&buf)
+			t.Logf("first apply:\n%s", &buf)
+			if strings.HasPrefix(buf.String(), "0 new") {
+				t.Errorf("nothing pushed")
+			}
+			for _, c := range first.changes {
+				if c.op == opDelete {
+					// The configuration deletes content that it
+					// also pushes, so it's never up to date.
+					return
+				}
+			}
+			second := apply(false)
+			buf.Reset()
+			second.printChanges(&buf)
+			for _, c := range second.changes {
+				if c.op != opUnchanged {
+					t.Errorf("second apply is not a no-op; changes:\n%s", &buf)
+					break
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+// The oras-gc command deletes unreachable content from an OCI image
+// layout, such as one written by oras-apply -output, or from the
+// directory of an [ociregistry.FileRegistry]. Directories that hold
+// an oci-layout file are taken to be image layouts. Manifests and
+// blobs are kept if they can be reached from a tag in their repository,
+// directly or as a referrer, or if they were pushed recently.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cue-exp/oras/ociregistry"
+	"github.com/cue-exp/oras/orasflow"
+)
+
+var (
+	nflag     = flag.Bool("n", false, "dry run: report what would be deleted without deleting anything")
+	graceFlag = flag.Duration("grace", time.Hour, "keep content pushed within this long, so that uploads in progress aren't affected")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: oras-gc [flags] dir\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	if err := runGC(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "oras-gc: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func runGC(dir string) error {
+	if _, err := os.Stat(dir); err != nil {
+		return err
+	}
+	var (
+		r interface {
+			GC(ctx context.Context, opts *ociregistry.GCOptions) (*ociregistry.GCResult, error)
+		}
+		// closeRegistry finishes the deletion, if needed.
+		closeRegistry = func() error { return nil }
+	)
+	if _, err := os.Stat(filepath.Join(dir, "oci-layout")); err == nil {
+		layout, err := orasflow.OpenLayout(dir)
+		if err != nil {
+			return fmt.Errorf("cannot open image layout: %v", err)
+		}
+		r = layout
+		closeRegistry = func() error {
+			if err := layout.Close(); err != nil {
+				return fmt.Errorf("cannot write image layout index: %v", err)
+			}
+			return nil
+		}
+	} else {
+		fr, err := ociregistry.NewFileRegistry(ociregistry.FileRegistryParams{
+			Dir: dir,
+		})
+		if err != nil {
+			return fmt.Errorf("cannot open file registry: %v", err)
+		}
+		r = fr
+	}
+	result, err := r.GC(context.Background(), &ociregistry.GCOptions{
+		DryRun:      *nflag,
+		GracePeriod: *graceFlag,
+	})
+	if err != nil {
+		return err
+	}
+	for _, item := range result.Deleted {
+		switch {
+		case item.Repo == "":
+			fmt.Printf("- %-8s %s (%d bytes)\n", "file", item.Desc.Digest, item.Desc.Size)
+		case ociregistry.IsManifest(item.Desc.MediaType):
+			fmt.Printf("- %-8s %s@%s (%s, %d bytes)\n", "manifest", item.Repo, item.Desc.Digest, item.Desc.MediaType, item.Desc.Size)
+		default:
+			fmt.Printf("- %-8s %s@%s (%s, %d bytes)\n", "blob", item.Repo, item.Desc.Digest, item.Desc.MediaType, item.Desc.Size)
+		}
+	}
+	if *nflag {
+		fmt.Printf("%d deleted, %d bytes reclaimable (dry run)\n", len(result.Deleted), result.Bytes)
+		return nil
+	}
+	if err := closeRegistry(); err != nil {
+		return err
+	}
+	fmt.Printf("%d deleted, %d bytes reclaimed\n", len(result.Deleted), result.Bytes)
+	return nil
+}
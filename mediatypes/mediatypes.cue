@@ -1,5 +1,13 @@
+// Package mediatypes holds schemas for the content stored in OCI
+// registries, keyed by media type. The Go package of the same name
+// embeds this file, so the Go and CUE validators share these schemas.
+//
+// This file must not import any packages, so that it
+// can be compiled on its own.
 package mediatypes
 
+// type holds the schemas for content, keyed by media type.
+// Content with other media types is not checked.
 type: "application/vnd.oci.image.manifest.v1+json": {
 	// schemaVersion specifies the image manifest schema version.
 	// For this version of the specification, this MUST be 2
@@ -56,3 +64,217 @@ type: "application/vnd.oci.image.manifest.v1+json": {
 	// [Pre-Defined Annotation Keys]: https://github.com/opencontainers/image-spec/blob/v1.1.0-rc2/annotations.md#pre-defined-annotation-keys
 	annotations?: [string]: string
 }
+
+type: "application/vnd.oci.image.index.v1+json": {
+	// schemaVersion specifies the image index schema version.
+	// For this version of the specification, this MUST be 2
+	// to ensure backward compatibility with older versions of Docker.
+	schemaVersion!: 2
+
+	// mediaType is reserved for use to maintain compatibility.
+	// When used, this field contains the media type of this document,
+	// which differs from the descriptor use of mediaType.
+	mediaType?: "application/vnd.oci.image.index.v1+json"
+
+	// artifactType contains the type of an artifact
+	// when the index is used for an artifact.
+	artifactType?: string
+
+	// manifests holds a list of descriptors for specific manifests.
+	// Each element should describe a manifest and should include
+	// platform information when the manifest is platform-specific.
+	manifests!: [... #descriptor]
+
+	// subject specifies a descriptor of another manifest.
+	subject?: #descriptor
+
+	// annotations holds arbitrary metadata for the image index.
+	annotations?: [string]: string
+}
+
+// The image config describes a container image's root filesystem
+// and the execution parameters to use when running it.
+// See https://github.com/opencontainers/image-spec/blob/main/config.md
+type: "application/vnd.oci.image.config.v1+json": {
+	// created holds the time the image was created, in RFC 3339 format.
+	created?: string
+
+	// author gives the name and/or email address of the
+	// person or entity which created the image.
+	author?: string
+
+	// architecture holds the CPU architecture that the
+	// binaries in this image are built to run on.
+	architecture!: string
+
+	// os holds the name of the operating system
+	// which the image is built to run on.
+	os!: string
+
+	"os.version"?: string
+	"os.features"?: [... string]
+	variant?: string
+
+	// config holds the execution parameters which should
+	// be used as a base when running a container using the image.
+	config?: {
+		User?: string
+		ExposedPorts?: [string]: {}
+		Env?: [... string]
+		Entrypoint?: [... string]
+		Cmd?: [... string]
+		Volumes?: [string]: {}
+		WorkingDir?: string
+		Labels?: [string]: string
+		StopSignal?: string
+		ArgsEscaped?: bool
+	}
+
+	// rootfs references the layer content addresses used by the image.
+	rootfs!: {
+		type!: "layers"
+		diff_ids!: [... #digest]
+	}
+
+	// history describes the history of each layer,
+	// in order from first to last.
+	history?: [... {
+		created?:     string
+		author?:      string
+		created_by?:  string
+		comment?:     string
+		empty_layer?: bool
+	}]
+}
+
+type: "application/vnd.oci.descriptor.v1+json": #descriptor
+
+// The layout header is held in the oci-layout file of an image layout.
+// See https://github.com/opencontainers/image-spec/blob/main/image-layout.md
+type: "application/vnd.oci.layout.header.v1+json": {
+	imageLayoutVersion!: "1.0.0"
+}
+
+// The empty descriptor refers to the content {}, which is used as
+// the config of artifacts that have no config, and as a placeholder layer.
+type: "application/vnd.oci.empty.v1+json": close({})
+
+// The scratch media type is the name that release candidates
+// of version 1.1 of the image spec used for the empty media type.
+type: "application/vnd.oci.scratch.v1+json": type["application/vnd.oci.empty.v1+json"]
+
+// The Docker image manifest, version 2, schema 2.
+// See https://distribution.github.io/distribution/spec/manifest-v2-2/
+type: "application/vnd.docker.distribution.manifest.v2+json": {
+	schemaVersion!: 2
+	mediaType!:     "application/vnd.docker.distribution.manifest.v2+json"
+	config!:        #descriptor
+	layers!: [... #descriptor]
+}
+
+// The Docker manifest list, which is the precursor of the image index.
+type: "application/vnd.docker.distribution.manifest.list.v2+json": {
+	schemaVersion!: 2
+	mediaType!:     "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// manifests holds the manifests for specific platforms.
+	// Unlike in an image index, the platform is required.
+	manifests!: [... #descriptor & {
+		platform!: _
+	}]
+}
+
+// A CUE module is stored as a manifest with this artifact type.
+// It's also the media type of the manifest's config, which
+// is always the empty JSON object.
+type: "application/vnd.cue.module.v1+json": type["application/vnd.oci.empty.v1+json"]
+
+// The module file layer of a CUE module holds the module's
+// cue.mod/module.cue file. This schema only checks what's needed
+// to find the module and its dependencies in a registry;
+// modpush/modfile.cue holds the full schema.
+type: "application/vnd.cue.modulefile.v1": {
+	// module holds the module's path, which
+	// must include its major version.
+	module!: =~#"^[^@]+@v(0|[1-9]\d*)$"#
+
+	// deps holds the module's dependencies, keyed by module
+	// path, each of which must include its major version.
+	deps?: [=~#"^[^@]+@v(0|[1-9]\d*)$"#]: {
+		v!: string
+	}
+}
+
+// layout holds the schemas for the files in an image layout,
+// keyed by file name.
+layout: {
+	"oci-layout": type["application/vnd.oci.layout.header.v1+json"]
+	"index.json": type["application/vnd.oci.image.index.v1+json"]
+}
+
+// artifactType holds additional schemas for manifests,
+// keyed by artifact type. A manifest with one of these
+// artifact types must satisfy both the schema for its
+// media type and the schema for its artifact type.
+artifactType: "application/vnd.cue.module.v1+json": {
+	mediaType?: "application/vnd.oci.image.manifest.v1+json"
+
+	// config is the empty config, with its media type
+	// set to the module artifact type.
+	config!: mediaType!: "application/vnd.cue.module.v1+json"
+
+	// layers holds the module's zip file, followed by its
+	// module.cue file and then the zip files of the module's
+	// dependencies, each annotated with its module path and version.
+	layers!: [
+		{mediaType!: "application/zip"},
+		{mediaType!: "application/vnd.cue.modulefile.v1"},
+		...{
+			mediaType!: "application/zip"
+			annotations!: "works.cue.module": =~"^[^@]+@v[^@]+$"
+		},
+	]
+}
+
+// #descriptor describes the disposition of targeted content.
+// See https://github.com/opencontainers/image-spec/blob/main/descriptor.md
+#descriptor: {
+	// mediaType contains the IANA media type of the referenced content.
+	mediaType!: string
+
+	// artifactType contains the type of an artifact
+	// when the descriptor points to an artifact.
+	artifactType?: string
+
+	// digest is the digest of the targeted content.
+	digest!: #digest
+
+	// size specifies the size in bytes of the blob.
+	size!: int & >=0
+
+	// urls specifies a list of URIs from which this object may be downloaded.
+	urls?: [... string]
+
+	// annotations contains arbitrary metadata for this descriptor.
+	annotations?: [string]: string
+
+	// platform describes the minimum runtime requirements of the image.
+	platform?: #platform
+
+	// data contains an embedded representation of the referenced content,
+	// base64-encoded in JSON.
+	data?: bytes | string
+}
+
+// #digest constrains a content digest.
+#digest: =~"^[a-z0-9]+(?:[+._-][a-z0-9]+)*:[a-zA-Z0-9=_-]+$"
+
+// #platform describes the platform that an image runs on.
+#platform: {
+	architecture!: string
+	os!:           string
+	"os.version"?: string
+	"os.features"?: [... string]
+	variant?: string
+	features?: [... string]
+}
@@ -0,0 +1,112 @@
+// Package mediatypes provides the CUE schemas in mediatypes.cue,
+// which describe the content stored in OCI registries, keyed by
+// media type. CUE configurations can use the schemas by importing
+// the CUE package of the same name.
+package mediatypes
+
+import (
+	_ "embed"
+	"fmt"
+	"sort"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+)
+
+// Schema holds the CUE source of the schemas. See mediatypes.cue
+// for their structure. The source has no imports, so it can be
+// compiled in any cue.Context.
+//
+//go:embed mediatypes.cue
+var Schema []byte
+
+// Schemas holds the schemas compiled in a particular cue.Context.
+// Values returned by its methods can be unified with other
+// values from the same context.
+type Schemas struct {
+	v cue.Value
+}
+
+// Compile compiles the schemas in ctx.
+func Compile(ctx *cue.Context) (*Schemas, error) {
+	v := ctx.CompileBytes(Schema, cue.Filename("mediatypes.cue"))
+	if err := v.Err(); err != nil {
+		return nil, fmt.Errorf("cannot compile media type schemas: %v", errors.Details(err, nil))
+	}
+	return &Schemas{v: v}, nil
+}
+
+// Lookup returns the schema for content with the given
+// media type, or false if there isn't one.
+func (s *Schemas) Lookup(mediaType string) (cue.Value, bool) {
+	return s.lookup("type", mediaType)
+}
+
+// LookupArtifact returns the additional schema for manifests
+// with the given artifact type, or false if there isn't one.
+func (s *Schemas) LookupArtifact(artifactType string) (cue.Value, bool) {
+	return s.lookup("artifactType", artifactType)
+}
+
+// LookupLayout returns the schema for the file with the given
+// name in an image layout, such as "index.json", or false
+// if there isn't one.
+func (s *Schemas) LookupLayout(name string) (cue.Value, bool) {
+	return s.lookup("layout", name)
+}
+
+func (s *Schemas) lookup(kind, name string) (cue.Value, bool) {
+	v := s.v.LookupPath(cue.MakePath(cue.Str(kind), cue.Str(name)))
+	return v, v.Exists()
+}
+
+// MediaTypes returns all the media types
+// that have schemas, in lexical order.
+func (s *Schemas) MediaTypes() []string {
+	var types []string
+	iter, _ := s.v.LookupPath(cue.MakePath(cue.Str("type"))).Fields()
+	for iter.Next() {
+		types = append(types, iter.Selector().Unquoted())
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Unify returns v, content with the given media type, unified with
+// the schema for that media type and, if v is a manifest, with the
+// schema for its artifact type. The artifact type defaults to the
+// media type of the manifest's config, as the image spec requires.
+// It returns false if there's no schema for the media type.
+func (s *Schemas) Unify(mediaType string, v cue.Value) (cue.Value, bool) {
+	schema, ok := s.Lookup(mediaType)
+	if !ok {
+		return v, false
+	}
+	v = v.Unify(schema)
+	atype, err := v.LookupPath(cue.MakePath(cue.Str("artifactType"))).String()
+	if err != nil {
+		atype, _ = v.LookupPath(cue.MakePath(cue.Str("config"), cue.Str("mediaType"))).String()
+	}
+	if atype != "" {
+		if schema, ok := s.LookupArtifact(atype); ok {
+			v = v.Unify(schema)
+		}
+	}
+	return v, true
+}
+
+// Validate checks that data, content with the given media type,
+// satisfies the schemas for it, as described in [Schemas.Unify].
+// The data is compiled as CUE, so it may be JSON or, for module
+// files, CUE. Content with no schema is always valid.
+func (s *Schemas) Validate(mediaType string, data []byte) error {
+	if _, ok := s.Lookup(mediaType); !ok {
+		return nil
+	}
+	v := s.v.Context().CompileBytes(data, cue.Filename(mediaType))
+	if err := v.Err(); err != nil {
+		return err
+	}
+	v, _ = s.Unify(mediaType, v)
+	return v.Validate(cue.Concrete(true))
+}
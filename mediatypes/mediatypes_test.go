@@ -0,0 +1,255 @@
+package mediatypes
+
+import (
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+)
+
+const (
+	testDigest = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	testDesc   = `{"mediaType": "text/plain", "digest": "` + testDigest + `", "size": 0}`
+	emptyDesc  = `{"mediaType": "application/vnd.oci.empty.v1+json", "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", "size": 2}`
+	moduleDesc = `{"mediaType": "application/vnd.cue.module.v1+json", "digest": "sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a", "size": 2}`
+	zipDesc    = `{"mediaType": "application/zip", "digest": "` + testDigest + `", "size": 0}`
+	modDesc    = `{"mediaType": "application/vnd.cue.modulefile.v1", "digest": "` + testDigest + `", "size": 0}`
+)
+
+var validateTests = []struct {
+	testName  string
+	mediaType string
+	data      string
+	wantErr   string
+}{{
+	testName:  "Manifest",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json", "artifactType": "application/x-test", "config": ` + emptyDesc + `, "layers": [` + testDesc + `]}`,
+}, {
+	testName:  "ManifestWithMediaTypeWithoutArtifactType",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json", "config": ` + testDesc + `, "layers": []}`,
+	wantErr:   "artifactType",
+}, {
+	testName:  "ManifestBadDigest",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "config": ` + testDesc + `, "layers": [{"mediaType": "text/plain", "digest": "nope", "size": 0}]}`,
+	wantErr:   "layers.0.digest",
+}, {
+	testName:  "Index",
+	mediaType: "application/vnd.oci.image.index.v1+json",
+	data:      `{"schemaVersion": 2, "manifests": [` + testDesc + `]}`,
+}, {
+	testName:  "IndexWithoutManifests",
+	mediaType: "application/vnd.oci.image.index.v1+json",
+	data:      `{"schemaVersion": 2}`,
+	wantErr:   "manifests",
+}, {
+	testName:  "IndexWrongSchemaVersion",
+	mediaType: "application/vnd.oci.image.index.v1+json",
+	data:      `{"schemaVersion": 1, "manifests": []}`,
+	wantErr:   "schemaVersion",
+}, {
+	testName:  "Config",
+	mediaType: "application/vnd.oci.image.config.v1+json",
+	data:      `{"architecture": "amd64", "os": "linux", "rootfs": {"type": "layers", "diff_ids": ["` + testDigest + `"]}}`,
+}, {
+	testName:  "ConfigWithoutOS",
+	mediaType: "application/vnd.oci.image.config.v1+json",
+	data:      `{"architecture": "amd64", "rootfs": {"type": "layers", "diff_ids": []}}`,
+	wantErr:   "os",
+}, {
+	testName:  "LayoutHeader",
+	mediaType: "application/vnd.oci.layout.header.v1+json",
+	data:      `{"imageLayoutVersion": "1.0.0"}`,
+}, {
+	testName:  "LayoutHeaderWrongVersion",
+	mediaType: "application/vnd.oci.layout.header.v1+json",
+	data:      `{"imageLayoutVersion": "2.0.0"}`,
+	wantErr:   "imageLayoutVersion",
+}, {
+	testName:  "Empty",
+	mediaType: "application/vnd.oci.empty.v1+json",
+	data:      `{}`,
+}, {
+	testName:  "NotEmpty",
+	mediaType: "application/vnd.oci.scratch.v1+json",
+	data:      `{"x": 1}`,
+	wantErr:   "field not allowed",
+}, {
+	testName:  "DockerManifest",
+	mediaType: "application/vnd.docker.distribution.manifest.v2+json",
+	data:      `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json", "config": ` + testDesc + `, "layers": []}`,
+}, {
+	testName:  "DockerManifestWithoutMediaType",
+	mediaType: "application/vnd.docker.distribution.manifest.v2+json",
+	data:      `{"schemaVersion": 2, "config": ` + testDesc + `, "layers": []}`,
+	wantErr:   "mediaType",
+}, {
+	testName:  "DockerManifestList",
+	mediaType: "application/vnd.docker.distribution.manifest.list.v2+json",
+	data:      `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": [{"mediaType": "text/plain", "digest": "` + testDigest + `", "size": 0, "platform": {"architecture": "amd64", "os": "linux"}}]}`,
+}, {
+	testName:  "DockerManifestListWithoutPlatform",
+	mediaType: "application/vnd.docker.distribution.manifest.list.v2+json",
+	data:      `{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.list.v2+json", "manifests": [` + testDesc + `]}`,
+	wantErr:   "platform",
+}, {
+	testName:  "ModuleFile",
+	mediaType: "application/vnd.cue.modulefile.v1",
+	data:      `module: "example.com/foo@v0", deps: "example.com/bar@v1": v: "v1.2.3"`,
+}, {
+	testName:  "ModuleFileWithoutMajorVersion",
+	mediaType: "application/vnd.cue.modulefile.v1",
+	data:      `module: "example.com/foo"`,
+	wantErr:   "module",
+}, {
+	testName:  "ModuleManifest",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "config": ` + moduleDesc + `, "layers": [` + zipDesc + `, ` + modDesc + `]}`,
+}, {
+	testName:  "ModuleManifestWithoutModuleFile",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "config": ` + moduleDesc + `, "layers": [` + zipDesc + `, ` + zipDesc + `]}`,
+	wantErr:   "layers.1.mediaType",
+}, {
+	testName:  "ModuleArtifactType",
+	mediaType: "application/vnd.oci.image.manifest.v1+json",
+	data:      `{"schemaVersion": 2, "artifactType": "application/vnd.cue.module.v1+json", "config": ` + emptyDesc + `, "layers": []}`,
+	wantErr:   "config.mediaType",
+}, {
+	testName:  "NoSchema",
+	mediaType: "text/plain",
+	data:      `not even JSON`,
+}, {
+	testName:  "InvalidJSON",
+	mediaType: "application/vnd.oci.image.index.v1+json",
+	data:      `{`,
+	wantErr:   "expected",
+}}
+
+func TestValidate(t *testing.T) {
+	s := compile(t)
+	for _, test := range validateTests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := s.Validate(test.mediaType, []byte(test.data))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+var lookupTests = []struct {
+	testName string
+	lookup   func(s *Schemas, name string) (cue.Value, bool)
+	name     string
+	want     bool
+}{{
+	testName: "MediaType",
+	lookup:   (*Schemas).Lookup,
+	name:     "application/vnd.oci.image.index.v1+json",
+	want:     true,
+}, {
+	testName: "UnknownMediaType",
+	lookup:   (*Schemas).Lookup,
+	name:     "text/plain",
+}, {
+	testName: "ArtifactType",
+	lookup:   (*Schemas).LookupArtifact,
+	name:     "application/vnd.cue.module.v1+json",
+	want:     true,
+}, {
+	testName: "UnknownArtifactType",
+	lookup:   (*Schemas).LookupArtifact,
+	name:     "application/vnd.oci.image.manifest.v1+json",
+}, {
+	testName: "LayoutIndex",
+	lookup:   (*Schemas).LookupLayout,
+	name:     "index.json",
+	want:     true,
+}, {
+	testName: "LayoutHeader",
+	lookup:   (*Schemas).LookupLayout,
+	name:     "oci-layout",
+	want:     true,
+}, {
+	testName: "LayoutBlob",
+	lookup:   (*Schemas).LookupLayout,
+	name:     "blobs",
+}}
+
+func TestLookup(t *testing.T) {
+	s := compile(t)
+	for _, test := range lookupTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v, ok := test.lookup(s, test.name)
+			if ok != test.want {
+				t.Fatalf("got %v; want %v", ok, test.want)
+			}
+			if ok && v.Err() != nil {
+				t.Errorf("schema has error: %v", v.Err())
+			}
+		})
+	}
+}
+
+func TestLookupLayoutValidates(t *testing.T) {
+	s := compile(t)
+	ctx := s.v.Context()
+	schema, _ := s.LookupLayout("index.json")
+	v := ctx.CompileString(`{"schemaVersion": 2, "manifests": [` + testDesc + `]}`).Unify(schema)
+	if err := v.Validate(cue.Concrete(true)); err != nil {
+		t.Errorf("valid index.json rejected: %v", err)
+	}
+	v = ctx.CompileString(`{"schemaVersion": 2}`).Unify(schema)
+	if err := v.Validate(cue.Concrete(true)); err == nil {
+		t.Errorf("index.json without manifests accepted")
+	}
+}
+
+func TestMediaTypes(t *testing.T) {
+	s := compile(t)
+	types := s.MediaTypes()
+	for i, mediaType := range types {
+		if i > 0 && types[i-1] >= mediaType {
+			t.Errorf("media types not sorted: %q before %q", types[i-1], mediaType)
+		}
+		if _, ok := s.Lookup(mediaType); !ok {
+			t.Errorf("no schema for %q", mediaType)
+		}
+	}
+	for _, mediaType := range []string{
+		"application/vnd.oci.image.manifest.v1+json",
+		"application/vnd.oci.image.index.v1+json",
+		"application/vnd.oci.image.config.v1+json",
+		"application/vnd.oci.layout.header.v1+json",
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json",
+		"application/vnd.cue.modulefile.v1",
+	} {
+		found := false
+		for _, t := range types {
+			found = found || t == mediaType
+		}
+		if !found {
+			t.Errorf("%q missing from media types %q", mediaType, types)
+		}
+	}
+}
+
+func compile(t *testing.T) *Schemas {
+	t.Helper()
+	s, err := Compile(cuecontext.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
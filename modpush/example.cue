@@ -8,10 +8,10 @@ modules: "example.com@v0.0.1": {
 		modules["bar.com@v0.5.0"],
 	]
 	moduleFile: {
-		module: "example.com"
+		module: "example.com@v0"
 		deps: {
-			"foo.com/bar/hello": v: "v0.2.3"
-			"bar.com": v:           "v0.5.0"
+			"foo.com/bar/hello@v0": v: "v0.2.3"
+			"bar.com@v0": v:           "v0.5.0"
 		}
 	}
 	files: "top.cue": """
@@ -29,10 +29,10 @@ modules: "foo.com/bar/hello@v0.2.3": {
 		modules["baz.org@v0.10.1"],
 	]
 	moduleFile: {
-		module: "foo.com/bar/hello"
+		module: "foo.com/bar/hello@v0"
 		deps: {
-			"bar.com": v: "v0.0.2"
-			"baz.org": v: "v0.10.1"
+			"bar.com@v0": v: "v0.0.2"
+			"baz.org@v0": v: "v0.10.1"
 		}
 	}
 	files: "x.cue": """
@@ -51,8 +51,8 @@ modules: "bar.com@v0.0.2": {
 		modules["baz.org@v0.0.2"],
 	]
 	moduleFile: {
-		module: "bar.com"
-		deps: "baz.org": v: "v0.0.2"
+		module: "bar.com@v0"
+		deps: "baz.org@v0": v: "v0.0.2"
 	}
 	files: "x.cue": """
 		package bar
@@ -66,8 +66,8 @@ modules: "bar.com@v0.5.0": {
 		modules["baz.org@v0.5.0"],
 	]
 	moduleFile: {
-		module: "bar.com"
-		deps: "baz.org": v: "v0.5.0"
+		module: "bar.com@v0"
+		deps: "baz.org@v0": v: "v0.5.0"
 	}
 	files: "x.cue": """
 		package bar
@@ -78,7 +78,7 @@ modules: "bar.com@v0.5.0": {
 }
 modules: "baz.org@v0.0.2": {
 	deps: []
-	moduleFile: module: "baz.org"
+	moduleFile: module: "baz.org@v0"
 	files: "baz.cue": """
 		package baz
 		"baz.org": "v0.0.2"
@@ -86,7 +86,7 @@ modules: "baz.org@v0.0.2": {
 }
 modules: "baz.org@v0.1.2": {
 	deps: []
-	moduleFile: module: "baz.org"
+	moduleFile: module: "baz.org@v0"
 	files: "x.cue": """
 		package baz
 		"baz.org": "v0.1.2"
@@ -94,7 +94,7 @@ modules: "baz.org@v0.1.2": {
 }
 modules: "baz.org@v0.5.0": {
 	deps: []
-	moduleFile: module: "baz.org"
+	moduleFile: module: "baz.org@v0"
 	files: "baz.cue": """
 		package baz
 		"baz.org": "v0.5.0"
@@ -102,7 +102,7 @@ modules: "baz.org@v0.5.0": {
 }
 modules: "baz.org@v0.10.1": {
 	deps: []
-	moduleFile: module: "baz.org"
+	moduleFile: module: "baz.org@v0"
 	files: "baz.cue": """
 		package baz
 		"baz.org": "v0.10.1"
@@ -4,7 +4,8 @@ package modpush
 // outside of the main module. For the module.cue file in a main module, the schema
 // is less restrictive, because wherever #Semver is used, a less specific version may be
 // used instead, which will be rewritten to the canonical form by the cue command tooling.
-// To check against that form,
+// To check against the strict form required by the registry,
+// unify this with _#Strict and _#Reserved.
 
 _#ModuleFile: {
 	// module indicates the module's path.
@@ -110,8 +111,7 @@ _#ModuleFile
 
 // _#Strict can be unified with the top level schema to enforce the strict version
 // of the schema required by the registry.
-// TODO _#Strict: {
-{
+_#Strict: {
 	#Semver: =~#"^v(?P<major>0|[1-9]\d*)\.(?P<minor>0|[1-9]\d*)\.(?P<patch>0|[1-9]\d*)(?:-(?P<prerelease>(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+(?P<buildmetadata>[0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$"#
 
 	// WIP: (([\-_~a-zA-Z0-9][.\-_~a-zA-Z0-9]*[\-_~a-zA-Z0-9])|([\-_~a-zA-Z0-9]))(/([\-_~a-zA-Z0-9][.\-_~a-zA-Z0-9]*[\-_~a-zA-Z0-9])|([\-_~a-zA-Z0-9]))*
@@ -124,17 +124,20 @@ _#ModuleFile
 	module!: #Module
 
 	// No null versions, because no replacements yet.
-	#Dep: v!: #Semver
+	#Dep: {
+		v!: #Semver
+		...
+	}
 
 	// TODO require the CUE version?
 	// cue!: _
+	...
 }
 
 // _#Reserved can be unified with the top level schema to reserve features that
 // aren't yet implemented, so we can potentially change their definition
 // later.
-// TODO _#Reserved:
-{
+_#Reserved: {
 	// Note: we're using 1&2 rather than _|_ because
 	// use of _|_ causes the source location of the errors
 	// to be lost. See https://github.com/cue-lang/cue/issues/2319.
@@ -150,3 +153,8 @@ _#ModuleFile
 	}
 	...
 }
+
+// #RegistryModuleFile constrains the module.cue files of modules
+// pushed to a registry: they must satisfy the strict schema and
+// must not use any reserved features.
+#RegistryModuleFile: _#ModuleFile & _#Strict & _#Reserved
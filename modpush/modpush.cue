@@ -114,6 +114,10 @@ modules: [modNameVer=_]: {
 				desc:   depContent.desc
 				source: depContent.source
 
+				// The content is already in the dependency's
+				// own repository, so avoid uploading it again.
+				mountFrom: [depContent.repo]
+
 				// Add an annotation so that the client can know which layer
 				// corresponds to which actual module version.
 				desc: annotations: "works.cue.module": dep.pathVer
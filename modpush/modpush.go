@@ -0,0 +1,229 @@
+// Package modpush provides Go support for the CUE package of the
+// same name, which describes how CUE modules are stored in a
+// registry. In particular, it lets registries check that
+// pushed modules satisfy the schema in modfile.cue.
+package modpush
+
+import (
+	"context"
+	_ "embed"
+	stdjson "encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/ast"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/parser"
+	"cuelang.org/go/cue/token"
+	"cuelang.org/go/encoding/json"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// MaxModuleFile holds the maximum size of a module file.
+const MaxModuleFile = 16 << 20
+
+const (
+	moduleArtifactType  = "application/vnd.cue.module.v1+json"
+	moduleFileMediaType = "application/vnd.cue.modulefile.v1"
+)
+
+//go:embed modfile.cue
+var moduleFileSchema []byte
+
+var _ ociregistry.ManifestChecker = CheckModule
+
+// CheckModule checks that a manifest pushed to a registry holds a
+// valid CUE module. Manifests that aren't modules are always valid.
+// A module manifest must have a module file layer, the contents
+// of which must satisfy #RegistryModuleFile in modfile.cue: the
+// module must be declared with its major version, versions must be
+// canonical semantic versions, there must be no local replacements,
+// and reserved fields such as retract and publish must not be used.
+//
+// CheckModule is an [ociregistry.ManifestChecker], so it can be used
+// with [ociregistry.NewCheckingRegistry] to enforce these rules
+// on everything pushed to a registry.
+func CheckModule(ctx context.Context, r ociregistry.Reader, repo string, desc ociregistry.Descriptor, data []byte) error {
+	var m struct {
+		ocispec.Manifest
+		ArtifactType string `json:"artifactType"`
+	}
+	if err := stdjson.Unmarshal(data, &m); err != nil {
+		// Checking the manifest format is up to the registry.
+		return nil
+	}
+	if m.ArtifactType != moduleArtifactType && m.Config.MediaType != moduleArtifactType {
+		return nil
+	}
+	var layer *ocispec.Descriptor
+	for i := range m.Layers {
+		if m.Layers[i].MediaType == moduleFileMediaType {
+			layer = &m.Layers[i]
+			break
+		}
+	}
+	if layer == nil {
+		return fmt.Errorf("module has no module file layer")
+	}
+	if layer.Size > MaxModuleFile {
+		return fmt.Errorf("module file is %d bytes; maximum is %d", layer.Size, MaxModuleFile)
+	}
+	b, err := r.GetBlob(ctx, repo, layer.Digest)
+	if err != nil {
+		return fmt.Errorf("cannot get module file: %v", err)
+	}
+	rc := b.Open()
+	defer rc.Close()
+	modFile, err := io.ReadAll(io.LimitReader(rc, MaxModuleFile))
+	if err != nil {
+		return fmt.Errorf("cannot read module file: %v", err)
+	}
+	return CheckModuleFile(modFile)
+}
+
+// CheckModuleFile checks that data, the contents of a module file
+// layer, satisfies #RegistryModuleFile in modfile.cue, the schema
+// for the module files of modules in a registry. The data must
+// be JSON, as decoded by [DecodeModuleFile].
+func CheckModuleFile(data []byte) error {
+	v, err := DecodeModuleFile(cuecontext.New(), data)
+	if err != nil {
+		return fmt.Errorf("invalid module file: %v", err)
+	}
+	return CheckModuleFileValue(v)
+}
+
+// CheckModuleFileValue checks that v, a module file as returned by
+// [DecodeModuleFile] or [ParseModuleFile], satisfies #RegistryModuleFile
+// in modfile.cue.
+func CheckModuleFileValue(v cue.Value) error {
+	schema := v.Context().CompileBytes(moduleFileSchema, cue.Filename("modfile.cue"))
+	if err := schema.Err(); err != nil {
+		return fmt.Errorf("cannot compile module file schema: %v", errors.Details(err, nil))
+	}
+	schema = schema.LookupPath(cue.MakePath(cue.Def("#RegistryModuleFile")))
+	v = v.Unify(schema)
+	// Validate reports conflicts with the schema; MarshalJSON
+	// reports required fields that are missing. Validating with
+	// cue.Concrete would also report the required fields
+	// of the schema's own definitions.
+	if err := v.Validate(); err != nil {
+		return fmt.Errorf("invalid module file: %v", errors.Details(err, nil))
+	}
+	if _, err := v.MarshalJSON(); err != nil {
+		return fmt.Errorf("invalid module file: %v", errors.Details(err, nil))
+	}
+	return nil
+}
+
+// DecodeModuleFile decodes data, the contents of a module file layer,
+// which must be JSON. Module files come from registries, so they're
+// never evaluated as CUE: a CUE expression could take
+// arbitrarily long to evaluate.
+func DecodeModuleFile(ctx *cue.Context, data []byte) (cue.Value, error) {
+	if len(data) > MaxModuleFile {
+		return cue.Value{}, fmt.Errorf("module file is %d bytes; maximum is %d", len(data), MaxModuleFile)
+	}
+	expr, err := json.Extract("module file", data)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	v := ctx.BuildExpr(expr)
+	if err := v.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return v, nil
+}
+
+// ParseModuleFile parses data, the contents of a cue.mod/module.cue
+// file, using filename in error messages. Like [DecodeModuleFile],
+// it doesn't evaluate arbitrary CUE: the file must hold only data,
+// that is regular fields with literal, list and struct values,
+// of which JSON is a special case. Imports, references, definitions,
+// comprehensions and any other expressions are errors.
+func ParseModuleFile(ctx *cue.Context, filename string, data []byte) (cue.Value, error) {
+	if len(data) > MaxModuleFile {
+		return cue.Value{}, fmt.Errorf("%s is %d bytes; maximum is %d", filename, len(data), MaxModuleFile)
+	}
+	f, err := parser.ParseFile(filename, data)
+	if err != nil {
+		return cue.Value{}, err
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.Package, *ast.CommentGroup:
+		case *ast.EmbedDecl:
+			// A JSON object.
+			if _, ok := decl.Expr.(*ast.StructLit); !ok {
+				return cue.Value{}, dataOnlyError(decl.Expr)
+			}
+			if err := checkData(decl.Expr); err != nil {
+				return cue.Value{}, err
+			}
+		default:
+			if err := checkData(decl); err != nil {
+				return cue.Value{}, err
+			}
+		}
+	}
+	v := ctx.BuildFile(f)
+	if err := v.Err(); err != nil {
+		return cue.Value{}, err
+	}
+	return v, nil
+}
+
+// checkData checks that n, a node in a module.cue file,
+// holds only data.
+func checkData(n ast.Node) error {
+	switch n := n.(type) {
+	case *ast.BasicLit, *ast.CommentGroup:
+		return nil
+	case *ast.UnaryExpr:
+		// A negative number.
+		if lit, ok := n.X.(*ast.BasicLit); ok && n.Op == token.SUB && (lit.Kind == token.INT || lit.Kind == token.FLOAT) {
+			return nil
+		}
+	case *ast.Field:
+		if n.Constraint != token.ILLEGAL || n.Optional.IsValid() {
+			break
+		}
+		switch label := n.Label.(type) {
+		case *ast.Ident:
+			if strings.HasPrefix(label.Name, "#") || strings.HasPrefix(label.Name, "_") {
+				return dataOnlyError(n)
+			}
+		case *ast.BasicLit:
+			if label.Kind != token.STRING {
+				return dataOnlyError(n)
+			}
+		default:
+			return dataOnlyError(n)
+		}
+		return checkData(n.Value)
+	case *ast.StructLit:
+		for _, elt := range n.Elts {
+			if err := checkData(elt); err != nil {
+				return err
+			}
+		}
+		return nil
+	case *ast.ListLit:
+		for _, elt := range n.Elts {
+			if err := checkData(elt); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return dataOnlyError(n)
+}
+
+func dataOnlyError(n ast.Node) error {
+	return errors.Newf(n.Pos(), "module file must hold only data")
+}
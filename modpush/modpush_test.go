@@ -0,0 +1,368 @@
+package modpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+var checkModuleFileTests = []struct {
+	testName string
+	data     string
+	wantErr  string
+}{{
+	testName: "Minimal",
+	data:     `{"module": "example.com@v0"}`,
+}, {
+	testName: "Deps",
+	data: `{
+		"module": "example.com/foo@v1",
+		"cue": "v0.6.0",
+		"description": "an example",
+		"deps": {
+			"bar.com@v0": {"v": "v0.5.0"},
+			"baz.org@v2": {"v": "v2.0.1-pre+build", "default": true}
+		}
+	}`,
+}, {
+	testName: "MissingModule",
+	data:     `{"deps": {"bar.com@v0": {"v": "v0.5.0"}}}`,
+	wantErr:  "module: field is required but not present",
+}, {
+	testName: "EmptyModule",
+	data:     `{"module": ""}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "NoMajorVersion",
+	data:     `{"module": "example.com"}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "DepNoMajorVersion",
+	data:     `{"module": "example.com@v0", "deps": {"bar.com": {"v": "v0.5.0"}}}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "NonCanonicalVersion",
+	data:     `{"module": "example.com@v0", "deps": {"bar.com@v0": {"v": "v0.5"}}}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "NullVersion",
+	data:     `{"module": "example.com@v0", "deps": {"bar.com@v0": {"v": null}}}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "LocalReplace",
+	data:     `{"module": "example.com@v0", "deps": {"bar.com@v0": {"v": "v0.5.0", "replaceAll": "../bar"}}}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "Retract",
+	data:     `{"module": "example.com@v0", "retract": ["v0.1.0"]}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "Publish",
+	data:     `{"module": "example.com@v0", "publish": {"allow": "public", "default": "public"}}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "CUESyntax",
+	data:     `module: "example.com@v0"`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "CUEExpression",
+	data:     `{"module": "example.com@v0"} & {x: [for i in list.Range(0, 1e9, 1) {i}]}`,
+	wantErr:  "invalid module file",
+}, {
+	testName: "NotAnObject",
+	data:     `"example.com@v0"`,
+	wantErr:  "invalid module file",
+}}
+
+func TestCheckModuleFile(t *testing.T) {
+	for _, test := range checkModuleFileTests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := CheckModuleFile([]byte(test.data))
+			checkErr(t, err, test.wantErr)
+		})
+	}
+}
+
+var parseModuleFileTests = []struct {
+	testName string
+	data     string
+	want     string
+	wantErr  string
+}{{
+	testName: "JSON",
+	data:     `{"module": "example.com@v0", "deps": {"bar.com@v0": {"v": "v0.5.0"}}}`,
+	want:     `{"module":"example.com@v0","deps":{"bar.com@v0":{"v":"v0.5.0"}}}`,
+}, {
+	testName: "CUE",
+	data: `
+// A comment.
+module: "example.com@v0"
+deps: "bar.com@v0": v: "v0.5.0"
+deps: "baz.org@v0": {
+	v: "v0.1.0"
+	default: true
+}
+x: [1, -2.5, null, """
+	multi-line
+	"""]
+`,
+	want: `{"module":"example.com@v0","deps":{"bar.com@v0":{"v":"v0.5.0"},"baz.org@v0":{"v":"v0.1.0","default":true}},"x":[1,-2.5,null,"multi-line"]}`,
+}, {
+	testName: "Package",
+	data:     "package foo\nmodule: \"example.com@v0\"\n",
+	want:     `{"module":"example.com@v0"}`,
+}, {
+	testName: "Reference",
+	data:     "a: \"example.com@v0\"\nmodule: a\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Import",
+	data:     "import \"list\"\nmodule: \"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Comprehension",
+	data:     "module: \"example.com@v0\"\nfor i in [1] {x: i}\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Interpolation",
+	data:     "module: \"example.com@v\\(0)\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Unification",
+	data:     "module: \"example.com@v0\" & string\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Definition",
+	data:     "#module: \"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Hidden",
+	data:     "_module: \"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Optional",
+	data:     "module?: \"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Pattern",
+	data:     "[string]: \"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Embedding",
+	data:     "\"example.com@v0\"\n",
+	wantErr:  "module file must hold only data",
+}, {
+	testName: "Conflict",
+	data:     "module: \"a@v0\"\nmodule: \"b@v0\"\n",
+	wantErr:  "conflicting values",
+}}
+
+func TestParseModuleFile(t *testing.T) {
+	for _, test := range parseModuleFileTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v, err := ParseModuleFile(cuecontext.New(), "module.cue", []byte(test.data))
+			if test.wantErr != "" {
+				checkErr(t, err, test.wantErr)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := v.MarshalJSON()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s; want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestExampleModuleFiles(t *testing.T) {
+	// All the modules that the modpush package pushes
+	// must be acceptable to registries that check them.
+	insts := load.Instances([]string{"."}, nil)
+	if err := insts[0].Err; err != nil {
+		t.Fatal(errors.Details(err, nil))
+	}
+	v := cuecontext.New().BuildInstance(insts[0])
+	if err := v.Err(); err != nil {
+		t.Fatal(errors.Details(err, nil))
+	}
+	iter, err := v.LookupPath(cue.ParsePath("modules")).Fields()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for iter.Next() {
+		n++
+		data, err := iter.Value().LookupPath(cue.ParsePath("moduleFile")).MarshalJSON()
+		if err != nil {
+			t.Fatalf("%s: %v", iter.Selector(), err)
+		}
+		if err := CheckModuleFile(data); err != nil {
+			t.Errorf("%s: %v", iter.Selector(), err)
+		}
+	}
+	if n == 0 {
+		t.Fatal("no modules found")
+	}
+}
+
+var checkModuleTests = []struct {
+	testName     string
+	artifactType string
+	layers       map[string]string
+	wantErr      string
+}{{
+	testName:     "Valid",
+	artifactType: moduleArtifactType,
+	layers: map[string]string{
+		"application/zip":   "not checked",
+		moduleFileMediaType: `{"module": "example.com@v0"}`,
+	},
+}, {
+	testName:     "NotAModule",
+	artifactType: "application/vnd.example",
+	layers: map[string]string{
+		moduleFileMediaType: `{"module": "example.com"}`,
+	},
+}, {
+	testName:     "NoModuleFile",
+	artifactType: moduleArtifactType,
+	layers: map[string]string{
+		"application/zip": "not checked",
+	},
+	wantErr: "module has no module file layer",
+}, {
+	testName:     "InvalidModuleFile",
+	artifactType: moduleArtifactType,
+	layers: map[string]string{
+		moduleFileMediaType: `{"module": "example.com"}`,
+	},
+	wantErr: "invalid module file",
+}}
+
+func TestCheckModule(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range checkModuleTests {
+		t.Run(test.testName, func(t *testing.T) {
+			r := ociregistry.NewMemRegistry()
+			desc, data := pushModuleContent(t, r, test.artifactType, test.layers)
+			err := CheckModule(ctx, r, "repo", desc, data)
+			checkErr(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestServeCheckModule(t *testing.T) {
+	// Check modules as they're pushed to a registry over HTTP.
+	for _, test := range checkModuleTests {
+		t.Run(test.testName, func(t *testing.T) {
+			r := ociregistry.NewMemRegistry()
+			srv := httptest.NewServer(ociregistry.Serve(ociregistry.NewCheckingRegistry(r, CheckModule)))
+			defer srv.Close()
+			desc, data := pushModuleContent(t, r, test.artifactType, test.layers)
+			req, err := http.NewRequest("PUT", srv.URL+"/v2/repo/manifests/v0.0.1", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", desc.MediaType)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if test.wantErr == "" {
+				if resp.StatusCode != http.StatusCreated {
+					t.Fatalf("got status %d; want %d", resp.StatusCode, http.StatusCreated)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Fatalf("got status %d; want %d", resp.StatusCode, http.StatusBadRequest)
+			}
+			var e struct {
+				Errors []struct {
+					Code    string `json:"code"`
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || len(e.Errors) != 1 {
+				t.Fatalf("invalid error response: %v", err)
+			}
+			if e.Errors[0].Code != "MANIFEST_INVALID" {
+				t.Errorf("got error code %q; want MANIFEST_INVALID", e.Errors[0].Code)
+			}
+			if !strings.Contains(e.Errors[0].Message, test.wantErr) {
+				t.Errorf("got error message %q; want message containing %q", e.Errors[0].Message, test.wantErr)
+			}
+		})
+	}
+}
+
+// pushModuleContent pushes a config blob and the given layers to r,
+// and returns the descriptor and content of a manifest that refers
+// to them, with the given artifact type. The manifest isn't pushed.
+func pushModuleContent(t *testing.T, r *ociregistry.MemRegistry, artifactType string, layers map[string]string) (ociregistry.Descriptor, []byte) {
+	var m struct {
+		ociregistry.Manifest
+		ArtifactType string `json:"artifactType"`
+	}
+	m.SchemaVersion = 2
+	m.MediaType = "application/vnd.oci.image.manifest.v1+json"
+	m.ArtifactType = artifactType
+	m.Config = pushBlob(t, r, "application/vnd.oci.empty.v1+json", "{}")
+	for mediaType, data := range layers {
+		m.Layers = append(m.Layers, pushBlob(t, r, mediaType, data))
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := ociregistry.Descriptor{
+		MediaType: m.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	return desc, data
+}
+
+func pushBlob(t *testing.T, r *ociregistry.MemRegistry, mediaType, data string) ociregistry.Descriptor {
+	desc := ociregistry.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromString(data),
+		Size:      int64(len(data)),
+	}
+	desc, err := r.PushBlob(context.Background(), "repo", ociregistry.BytesBlob([]byte(data), mediaType), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func checkErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("got error %v; want error containing %q", err, wantErr)
+	}
+}
@@ -42,7 +42,9 @@ import "time"
 	// when consumed via untrusted sources.
 	//
 	// NOTE: this is required on the wire and is optional only
-	// so we can unmarshal it for the flow task.
+	// so that it can be left out of configurations. For blobs,
+	// manifests and indexes pushed by orasflow, it's computed
+	// from their content before any tasks run.
 	//
 	// [Digests]: https://github.com/opencontainers/image-spec/blob/v1.0.1/descriptor.md#digests
 	digest?: string
@@ -55,7 +57,7 @@ import "time"
 	// the content should not be trusted.
 	//
 	// NOTE: this is required on the wire and is optional only
-	// so we can unmarshal it for the flow task.
+	// so that it can be left out of configurations, like digest.
 	size?: int64
 
 	// urls specifies a list of URIs from which this object may be downloaded.
@@ -161,6 +163,43 @@ import "time"
 	}
 }
 
+// #imageIndex defines the [application/vnd.oci.image.index.v1+json] media type.
+// An image index is a higher-level manifest which points to specific image manifests,
+// ideal for one or more platforms.
+//
+// [application/vnd.oci.image.index.v1+json]: https://github.com/opencontainers/image-spec/blob/main/image-index.md
+#imageIndex: {
+	// schemaVersion specifies the image index schema version.
+	// For this version of the specification, this MUST be 2
+	// to ensure backward compatibility with older versions of Docker.
+	schemaVersion!: 2
+
+	// mediaType is reserved for use to maintain compatibility.
+	// When used, this field contains the media type of this document,
+	// which differs from the descriptor use of mediaType.
+	mediaType!: "application/vnd.oci.image.index.v1+json"
+
+	// artifactType contains the type of an artifact
+	// when the index is used for an artifact.
+	artifactType?: string
+
+	// manifests holds a list of descriptors for specific manifests.
+	// Each element should describe a manifest and should include
+	// platform information when the manifest is platform-specific.
+	manifests!: [... #descriptor]
+
+	// subject specifies a descriptor of another manifest.
+	// This value, used by the referrers API,
+	// indicates a relationship to the specified manifest.
+	subject?: #descriptor
+
+	// annotations holds arbitrary metadata for the image index.
+	// It must use the [annotation rules].
+	//
+	// [annotation rules]: https://github.com/opencontainers/image-spec/blob/v1.1.0-rc2/annotations.md#rules
+	annotations?: [string]: string
+}
+
 #platform: {
 	// architecture specifies the CPU architecture.
 	// Image indexes should use, and implementations should understand,
@@ -0,0 +1,51 @@
+package ociregistry
+
+import (
+	"context"
+	"fmt"
+)
+
+// ManifestChecker checks the manifest with the given descriptor and
+// content before it's pushed to repo. The blobs that the manifest
+// refers to have usually been pushed already, and can be read from r.
+type ManifestChecker func(ctx context.Context, r Reader, repo string, desc Descriptor, data []byte) error
+
+// NewCheckingRegistry returns an implementation of [Interface] that
+// passes all operations through to r, except that every manifest is
+// first checked with check. If the check fails, the manifest isn't
+// pushed and PushManifest returns an error that wraps both
+// [ErrManifestInvalid] and the check's error, so that [Serve] can
+// report it as a MANIFEST_INVALID error.
+//
+// If r implements [Lister], so does the returned registry.
+func NewCheckingRegistry(r Interface, check ManifestChecker) Interface {
+	cr := &checkingRegistry{
+		Interface: r,
+		check:     check,
+	}
+	if l, ok := r.(Lister); ok {
+		return checkingLister{cr, l}
+	}
+	return cr
+}
+
+type checkingRegistry struct {
+	Interface
+	check ManifestChecker
+}
+
+type checkingLister struct {
+	*checkingRegistry
+	Lister
+}
+
+func (r *checkingRegistry) PushManifest(ctx context.Context, repo string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	data, err := readAll(c.Open())
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := r.check(ctx, r.Interface, repo, desc, data); err != nil {
+		return Descriptor{}, fmt.Errorf("%w: %w", ErrManifestInvalid, err)
+	}
+	return r.Interface.PushManifest(ctx, repo, BytesBlob(data, desc.MediaType), desc)
+}
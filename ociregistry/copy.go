@@ -0,0 +1,151 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Copy copies the manifest with the given digest in srcRepo
+// to dstRepo, along with all the content it refers to, and
+// returns the descriptor of the copied manifest.
+//
+// Content is copied depth first, so that a manifest is only
+// pushed after everything it refers to.
+//
+// If includeReferrers is true, all referrers of each copied manifest
+// are copied too, and src must implement [Lister].
+func Copy(ctx context.Context, dst Writer, dstRepo string, src Reader, srcRepo string, digest Digest, includeReferrers bool) (Descriptor, error) {
+	c := &copier{
+		dst:              dst,
+		dstRepo:          dstRepo,
+		src:              src,
+		srcRepo:          srcRepo,
+		includeReferrers: includeReferrers,
+		done:             make(map[Digest]Descriptor),
+	}
+	if includeReferrers {
+		lister, ok := src.(Lister)
+		if !ok {
+			return Descriptor{}, fmt.Errorf("cannot copy referrers: source registry cannot list referrers")
+		}
+		c.lister = lister
+	}
+	return c.copyManifest(ctx, digest)
+}
+
+type copier struct {
+	dst              Writer
+	dstRepo          string
+	src              Reader
+	srcRepo          string
+	lister           Lister
+	includeReferrers bool
+	done             map[Digest]Descriptor // copied content
+}
+
+func (c *copier) copyManifest(ctx context.Context, digest Digest) (Descriptor, error) {
+	if desc, ok := c.done[digest]; ok {
+		return desc, nil
+	}
+	r, err := c.src.GetManifest(ctx, c.srcRepo, digest)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("cannot get manifest %s: %v", digest, err)
+	}
+	desc := r.Descriptor()
+	data, err := readAll(r.Open())
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("cannot read manifest %s: %v", digest, err)
+	}
+	children, err := manifestChildren(data)
+	if err != nil {
+		return Descriptor{}, fmt.Errorf("invalid manifest %s: %v", digest, err)
+	}
+	for _, child := range children {
+		if IsManifest(child.MediaType) {
+			_, err = c.copyManifest(ctx, child.Digest)
+		} else {
+			err = c.copyBlob(ctx, child)
+		}
+		if err != nil {
+			return Descriptor{}, err
+		}
+	}
+	if _, err := c.dst.PushManifest(ctx, c.dstRepo, BytesBlob(data, desc.MediaType), desc); err != nil {
+		return Descriptor{}, fmt.Errorf("cannot push manifest %s: %v", digest, err)
+	}
+	c.done[digest] = desc
+	if c.includeReferrers {
+		referrers, err := All(c.lister.Referrers(ctx, c.srcRepo, digest, nil))
+		if err != nil {
+			return Descriptor{}, fmt.Errorf("cannot list referrers of %s: %v", digest, err)
+		}
+		for _, referrer := range referrers {
+			if _, err := c.copyManifest(ctx, referrer.Digest); err != nil {
+				return Descriptor{}, err
+			}
+		}
+	}
+	return desc, nil
+}
+
+func (c *copier) copyBlob(ctx context.Context, desc Descriptor) error {
+	if _, ok := c.done[desc.Digest]; ok {
+		return nil
+	}
+	r, err := c.src.GetBlob(ctx, c.srcRepo, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("cannot get blob %s: %v", desc.Digest, err)
+	}
+	if _, err := c.dst.PushBlob(ctx, c.dstRepo, r, desc); err != nil {
+		return fmt.Errorf("cannot push blob %s: %v", desc.Digest, err)
+	}
+	c.done[desc.Digest] = desc
+	return nil
+}
+
+// manifestChildren returns the descriptors of all the content
+// directly referred to by the manifest with the given data.
+// The subject of a manifest is not included because it is
+// a reference to the manifest's parent rather than its content.
+func manifestChildren(data []byte) ([]Descriptor, error) {
+	var m struct {
+		Config    *Descriptor  `json:"config"`
+		Layers    []Descriptor `json:"layers"`
+		Blobs     []Descriptor `json:"blobs"`
+		Manifests []Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	var children []Descriptor
+	if m.Config != nil {
+		children = append(children, *m.Config)
+	}
+	children = append(children, m.Layers...)
+	children = append(children, m.Blobs...)
+	children = append(children, m.Manifests...)
+	return children, nil
+}
+
+// IsManifest reports whether the given media type
+// is known to be a manifest type rather than a blob type.
+func IsManifest(mediaType string) bool {
+	switch mediaType {
+	case ocispec.MediaTypeImageManifest,
+		ocispec.MediaTypeImageIndex,
+		ocispec.MediaTypeArtifactManifest,
+		"application/vnd.docker.distribution.manifest.v2+json",
+		"application/vnd.docker.distribution.manifest.list.v2+json":
+		return true
+	}
+	return false
+}
+
+func readAll(r io.ReadCloser) ([]byte, error) {
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,216 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestCopy(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRegistry()
+	config := src.pushBlob("application/vnd.oci.empty.v1+json", "{}")
+	layer1 := src.pushBlob("text/plain", "layer1")
+	layer2 := src.pushBlob("text/plain", "layer2")
+	m1 := pushTestManifest(t, src, config, layer1)
+	m2 := pushTestManifest(t, src, config, layer2)
+	index := pushJSON(t, src, "src", ocispec.MediaTypeImageIndex, ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []Descriptor{m1, m2},
+	})
+	sigLayer := src.pushBlob("text/plain", "signature")
+	sig := pushJSON(t, src, "src", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []Descriptor{sigLayer},
+		Subject:   &index,
+	})
+	// Unrelated content that must not be copied.
+	pushTestManifest(t, src, config, src.pushBlob("text/plain", "other"))
+
+	for _, includeReferrers := range []bool{false, true} {
+		dst := newTestRegistry()
+		desc, err := Copy(ctx, dst, "dst", src, "src", index.Digest, includeReferrers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if desc.Digest != index.Digest || desc.MediaType != index.MediaType || desc.Size != index.Size {
+			t.Errorf("Copy returned %v; want %v", desc, index)
+		}
+		want := []Digest{
+			config.Digest,
+			layer1.Digest,
+			m1.Digest,
+			layer2.Digest,
+			m2.Digest,
+			index.Digest,
+		}
+		if includeReferrers {
+			want = append(want, sigLayer.Digest, sig.Digest)
+		}
+		if !equalDigests(dst.pushed, want) {
+			t.Errorf("includeReferrers %v: pushed %v; want %v", includeReferrers, dst.pushed, want)
+		}
+	}
+}
+
+func TestCopyReferrersNeedsLister(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRegistry()
+	m := pushTestManifest(t, src, src.pushBlob("application/vnd.oci.empty.v1+json", "{}"))
+	// Hide the Lister methods of the source.
+	_, err := Copy(ctx, newTestRegistry(), "dst", struct{ Reader }{src}, "src", m.Digest, true)
+	if want := "cannot copy referrers: source registry cannot list referrers"; err == nil || err.Error() != want {
+		t.Errorf("got error %v; want %q", err, want)
+	}
+}
+
+func TestCopyMissing(t *testing.T) {
+	ctx := context.Background()
+	src := newTestRegistry()
+	config := src.pushBlob("application/vnd.oci.empty.v1+json", "{}")
+	missing := Descriptor{
+		MediaType: "text/plain",
+		Digest:    digest.FromString("missing"),
+		Size:      7,
+	}
+	m := pushTestManifest(t, src, config, missing)
+	dst := newTestRegistry()
+	_, err := Copy(ctx, dst, "dst", src, "src", m.Digest, false)
+	if want := "cannot get blob " + string(missing.Digest); err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v; want error containing %q", err, want)
+	}
+	// The manifest must not be pushed without its content.
+	if _, err := dst.GetManifest(ctx, "dst", m.Digest); err == nil {
+		t.Errorf("manifest was pushed despite missing blob")
+	}
+	_, err = Copy(ctx, dst, "dst", src, "src", digest.FromString("nothing"), false)
+	if want := "cannot get manifest"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v; want error containing %q", err, want)
+	}
+}
+
+// testRegistry holds content in memory, ignoring repository names,
+// and records the digests of everything pushed to it, in order.
+// Unlike a real registry, it accepts manifests that refer to
+// content it doesn't hold, so that missing content can be tested.
+type testRegistry struct {
+	// Interface and Lister provide the methods that Copy
+	// doesn't use; calling them panics.
+	Interface
+	Lister
+	content   map[Digest]BlobReader
+	referrers map[Digest][]Descriptor
+	pushed    []Digest
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		content:   make(map[Digest]BlobReader),
+		referrers: make(map[Digest][]Descriptor),
+	}
+}
+
+func (r *testRegistry) GetBlob(ctx context.Context, repo string, dig Digest) (BlobReader, error) {
+	return r.get(dig)
+}
+
+func (r *testRegistry) GetManifest(ctx context.Context, repo string, dig Digest) (BlobReader, error) {
+	return r.get(dig)
+}
+
+func (r *testRegistry) get(dig Digest) (BlobReader, error) {
+	if b, ok := r.content[dig]; ok {
+		return b, nil
+	}
+	return nil, fmt.Errorf("%s not found", dig)
+}
+
+func (r *testRegistry) PushBlob(ctx context.Context, repo string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	data, err := readAll(c.Open())
+	if err != nil {
+		return Descriptor{}, err
+	}
+	r.content[desc.Digest] = BytesBlob(data, desc.MediaType)
+	r.pushed = append(r.pushed, desc.Digest)
+	return desc, nil
+}
+
+func (r *testRegistry) PushManifest(ctx context.Context, repo string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	desc, err := r.PushBlob(ctx, repo, c, desc)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	var m struct {
+		Subject *Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(r.content[desc.Digest].(bytesBlob).data, &m); err == nil && m.Subject != nil {
+		r.referrers[m.Subject.Digest] = append(r.referrers[m.Subject.Digest], desc)
+	}
+	return desc, nil
+}
+
+func (r *testRegistry) Referrers(ctx context.Context, repo string, dig Digest, opts *ReferrersOptions) Iter[Descriptor] {
+	return SliceIter(r.referrers[dig])
+}
+
+// pushBlob adds a blob holding the given content to r.
+func (r *testRegistry) pushBlob(mediaType, content string) Descriptor {
+	b := BytesBlob([]byte(content), mediaType)
+	r.content[b.Descriptor().Digest] = b
+	return b.Descriptor()
+}
+
+// pushTestManifest pushes an image manifest with the given
+// config and layers to r.
+func pushTestManifest(t *testing.T, r *testRegistry, config Descriptor, layers ...Descriptor) Descriptor {
+	if layers == nil {
+		layers = []Descriptor{}
+	}
+	return pushJSON(t, r, "src", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	})
+}
+
+// pushJSON pushes the JSON encoding of x to repo
+// as a manifest with the given media type.
+func pushJSON(t *testing.T, r Writer, repo, mediaType string, x any) Descriptor {
+	t.Helper()
+	data, err := json.Marshal(x)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	desc, err = r.PushManifest(context.Background(), repo, BytesBlob(data, mediaType), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func equalDigests(a, b []Digest) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,104 @@
+package ociregistry
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Errors that implementations of [Interface] return
+// when content cannot be found. They correspond to the
+// error codes of the same names in the distribution spec.
+var (
+	ErrBlobUnknown     = errors.New("blob unknown to registry")
+	ErrManifestUnknown = errors.New("manifest unknown to registry")
+	ErrNameUnknown     = errors.New("repository name not known to registry")
+)
+
+// ErrManifestInvalid is returned when a pushed manifest is rejected
+// because its content is invalid. It corresponds to the
+// MANIFEST_INVALID error code in the distribution spec.
+var ErrManifestInvalid = errors.New("manifest invalid")
+
+// ErrManifestBlobUnknown is returned when a pushed manifest is
+// rejected because it refers to a blob or manifest that isn't in
+// the repository. It corresponds to the MANIFEST_BLOB_UNKNOWN
+// error code in the distribution spec.
+var ErrManifestBlobUnknown = errors.New("manifest references a manifest or blob unknown to registry")
+
+// ErrDenied is returned when an operation is refused by the
+// registry's policy. It corresponds to the DENIED error
+// code in the distribution spec.
+var ErrDenied = errors.New("requested access to the resource is denied")
+
+// Errors that [Serve] reports for invalid requests. They correspond
+// to the error codes of the same names in the distribution spec.
+var (
+	ErrDigestInvalid     = errors.New("provided digest did not match uploaded content")
+	ErrNameInvalid       = errors.New("invalid repository name")
+	ErrBlobUploadUnknown = errors.New("blob upload unknown to registry")
+	ErrUnsupported       = errors.New("the operation is unsupported")
+)
+
+// IsNotFound reports whether err indicates that
+// a blob, manifest, tag or repository was not found.
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrBlobUnknown) ||
+		errors.Is(err, ErrManifestUnknown) ||
+		errors.Is(err, ErrNameUnknown)
+}
+
+// errorCodes holds the distribution spec error codes
+// and HTTP status codes of the errors above.
+var errorCodes = []struct {
+	err    error
+	code   string
+	status int
+}{
+	{ErrBlobUnknown, "BLOB_UNKNOWN", http.StatusNotFound},
+	{ErrManifestUnknown, "MANIFEST_UNKNOWN", http.StatusNotFound},
+	{ErrNameUnknown, "NAME_UNKNOWN", http.StatusNotFound},
+	{ErrManifestInvalid, "MANIFEST_INVALID", http.StatusBadRequest},
+	{ErrManifestBlobUnknown, "MANIFEST_BLOB_UNKNOWN", http.StatusNotFound},
+	{ErrDenied, "DENIED", http.StatusForbidden},
+	{ErrDigestInvalid, "DIGEST_INVALID", http.StatusBadRequest},
+	{ErrNameInvalid, "NAME_INVALID", http.StatusBadRequest},
+	{ErrBlobUploadUnknown, "BLOB_UPLOAD_UNKNOWN", http.StatusNotFound},
+	{ErrUnsupported, "UNSUPPORTED", http.StatusMethodNotAllowed},
+}
+
+// ErrorCode returns the distribution spec error code for err,
+// such as "MANIFEST_INVALID", and the HTTP status code that
+// goes with it. Errors without a code are reported as
+// "UNKNOWN", with an internal server error status.
+func ErrorCode(err error) (code string, status int) {
+	for _, c := range errorCodes {
+		if errors.Is(err, c.err) {
+			return c.code, c.status
+		}
+	}
+	return "UNKNOWN", http.StatusInternalServerError
+}
+
+// WriteError writes err to w as an error response in the
+// format defined by the distribution spec. The message is
+// err's message in full, so that clients can see why
+// a request failed.
+func WriteError(w http.ResponseWriter, err error) {
+	code, status := ErrorCode(err)
+	type wireError struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	}
+	data, _ := json.Marshal(struct {
+		Errors []wireError `json:"errors"`
+	}{
+		Errors: []wireError{{
+			Code:    code,
+			Message: err.Error(),
+		}},
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
@@ -1,15 +1,695 @@
 package ociregistry
 
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// FileRegistryParams holds the parameters for [NewFileRegistry].
 type FileRegistryParams struct {
-	// TODO
+	// Dir holds the directory that the registry's content is
+	// stored in. It's created if it doesn't exist.
+	Dir string
 }
 
 // NewFileRegistry returns an implementation of Interface that
 // operates on the local filesystem.
-func NewFileRegistry(p FileRegistryParams) *FileRegistry {
-	panic("TODO")
+//
+// Content is stored once, in the blobs directory, however many
+// repositories it's in. Each repository has its own directory
+// under repos, holding a file for each blob, manifest and tag in the
+// repository. Files are written atomically, so several processes
+// can share a registry; only [FileRegistry.GC] needs them to
+// agree on a grace period.
+func NewFileRegistry(p FileRegistryParams) (*FileRegistry, error) {
+	if p.Dir == "" {
+		return nil, fmt.Errorf("no directory for file registry")
+	}
+	for _, dir := range []string{fileBlobsDir, fileReposDir} {
+		if err := os.MkdirAll(filepath.Join(p.Dir, dir), 0o777); err != nil {
+			return nil, err
+		}
+	}
+	return &FileRegistry{
+		dir: p.Dir,
+	}, nil
 }
 
+// FileRegistry is an implementation of [Interface] and [Lister]
+// that stores its content on the local filesystem.
+// See [NewFileRegistry].
 type FileRegistry struct {
-	// TODO
+	dir string
+
+	// mu is held for writing by GC, so that nothing
+	// is pushed while it's deciding what to delete.
+	mu sync.RWMutex
+}
+
+var (
+	_ Interface = (*FileRegistry)(nil)
+	_ Lister    = (*FileRegistry)(nil)
+)
+
+// The names of the directories in a file registry.
+const (
+	fileBlobsDir = "blobs"
+	fileReposDir = "repos"
+)
+
+// The names of the directories in each repository of a
+// file registry. The blobs and manifests directories hold
+// the descriptors of the content in the repository.
+const (
+	repoBlobsDir     = "blobs"
+	repoManifestsDir = "manifests"
+	repoTagsDir      = "tags"
+)
+
+// tempPrefix starts the names of temporary files. Tags
+// can't start with a dot, so they can't be mistaken for tags.
+const tempPrefix = ".tmp-"
+
+// contentPath returns the path of the file
+// holding the content with the given digest.
+func (r *FileRegistry) contentPath(dig Digest) string {
+	return filepath.Join(r.dir, fileBlobsDir, string(dig.Algorithm()), dig.Encoded())
+}
+
+// repoPath returns the path of the directory of the named repository.
+// Repository names contain slashes, so they're escaped.
+func (r *FileRegistry) repoPath(repoName string) string {
+	return filepath.Join(r.dir, fileReposDir, url.PathEscape(repoName))
+}
+
+// entryPath returns the path of the file that records that the
+// content with the given digest is in the given directory of
+// the named repository.
+func (r *FileRegistry) entryPath(repoName, kind string, dig Digest) string {
+	return filepath.Join(r.repoPath(repoName), kind, string(dig.Algorithm()), dig.Encoded())
+}
+
+// checkRepo checks that the named repository exists.
+func (r *FileRegistry) checkRepo(repoName string) error {
+	if !repoNamePattern.MatchString(repoName) {
+		return fmt.Errorf("repository %q: %w", repoName, ErrNameInvalid)
+	}
+	if _, err := os.Stat(r.repoPath(repoName)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("repository %q: %w", repoName, ErrNameUnknown)
+		}
+		return err
+	}
+	return nil
+}
+
+// readEntry returns the descriptor recorded in the
+// given directory of the named repository, returning
+// notFound if the content isn't in the repository.
+func (r *FileRegistry) readEntry(repoName, kind string, dig Digest, notFound error) (Descriptor, error) {
+	if err := r.checkRepo(repoName); err != nil {
+		return Descriptor{}, err
+	}
+	if err := dig.Validate(); err != nil {
+		return Descriptor{}, fmt.Errorf("invalid digest %q: %w", dig, ErrDigestInvalid)
+	}
+	data, err := os.ReadFile(r.entryPath(repoName, kind, dig))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Descriptor{}, fmt.Errorf("%s in %q: %w", dig, repoName, notFound)
+		}
+		return Descriptor{}, err
+	}
+	var desc Descriptor
+	if err := json.Unmarshal(data, &desc); err != nil {
+		return Descriptor{}, fmt.Errorf("invalid entry for %s in %q: %v", dig, repoName, err)
+	}
+	return desc, nil
+}
+
+// writeEntry records that the content with the given
+// descriptor is in the given directory of the named repository.
+// Writing the entry again restarts GC's grace period.
+func (r *FileRegistry) writeEntry(repoName, kind string, desc Descriptor) error {
+	data, err := json.Marshal(Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(r.entryPath(repoName, kind, desc.Digest), data)
+}
+
+func (r *FileRegistry) GetBlob(ctx context.Context, repoName string, dig Digest) (BlobReader, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	desc, err := r.readEntry(repoName, repoBlobsDir, dig, ErrBlobUnknown)
+	if err != nil {
+		return nil, err
+	}
+	return fileBlob{desc, r.contentPath(dig)}, nil
+}
+
+func (r *FileRegistry) GetManifest(ctx context.Context, repoName string, dig Digest) (BlobReader, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	desc, err := r.readEntry(repoName, repoManifestsDir, dig, ErrManifestUnknown)
+	if err != nil {
+		return nil, err
+	}
+	return fileBlob{desc, r.contentPath(dig)}, nil
+}
+
+func (r *FileRegistry) GetTag(ctx context.Context, repoName string, tagName string) (BlobReader, error) {
+	dig, err := r.readTag(repoName, tagName)
+	if err != nil {
+		return nil, err
+	}
+	return r.GetManifest(ctx, repoName, dig)
+}
+
+// readTag returns the digest of the manifest that the given tag refers to.
+func (r *FileRegistry) readTag(repoName, tagName string) (Digest, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.checkRepo(repoName); err != nil {
+		return "", err
+	}
+	if !tagPattern.MatchString(tagName) {
+		return "", fmt.Errorf("tag %q in %q: %w", tagName, repoName, ErrManifestUnknown)
+	}
+	data, err := os.ReadFile(filepath.Join(r.repoPath(repoName), repoTagsDir, tagName))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("tag %q in %q: %w", tagName, repoName, ErrManifestUnknown)
+		}
+		return "", err
+	}
+	return Digest(data), nil
+}
+
+func (r *FileRegistry) PushBlob(ctx context.Context, repoName string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	if !repoNamePattern.MatchString(repoName) {
+		return Descriptor{}, fmt.Errorf("repository %q: %w", repoName, ErrNameInvalid)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rc := c.Open()
+	defer rc.Close()
+	if err := r.writeContent(desc, rc); err != nil {
+		return Descriptor{}, err
+	}
+	if err := r.writeEntry(repoName, repoBlobsDir, desc); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (r *FileRegistry) PushManifest(ctx context.Context, repoName string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	if !repoNamePattern.MatchString(repoName) {
+		return Descriptor{}, fmt.Errorf("repository %q: %w", repoName, ErrNameInvalid)
+	}
+	data, err := readAll(c.Open())
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkContent(desc, data); err != nil {
+		return Descriptor{}, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	has := func(kind string) func(Digest) bool {
+		return func(dig Digest) bool {
+			_, err := os.Stat(r.entryPath(repoName, kind, dig))
+			return err == nil
+		}
+	}
+	if err := checkRefs(data, has(repoBlobsDir), has(repoManifestsDir)); err != nil {
+		return Descriptor{}, fmt.Errorf("manifest %s in %q: %w", desc.Digest, repoName, err)
+	}
+	if err := r.writeContent(desc, bytes.NewReader(data)); err != nil {
+		return Descriptor{}, err
+	}
+	if err := r.writeEntry(repoName, repoManifestsDir, desc); err != nil {
+		return Descriptor{}, err
+	}
+	return desc, nil
+}
+
+// writeContent writes the given content to its file,
+// checking that it matches desc.
+func (r *FileRegistry) writeContent(desc Descriptor, content io.Reader) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %v", desc.Digest, err)
+	}
+	file := r.contentPath(desc.Digest)
+	if _, err := os.Stat(file); err == nil {
+		// The content is pushed again, so GC's grace
+		// period starts again. It's not an error if the
+		// time can't be changed.
+		now := time.Now()
+		os.Chtimes(file, now, now)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(f, verifier), content)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("content does not match descriptor (digest %s, size %d)", desc.Digest, desc.Size)
+	}
+	return os.Rename(f.Name(), file)
+}
+
+func (r *FileRegistry) Mount(ctx context.Context, repoName string, fromRepo string, dig Digest) error {
+	if !repoNamePattern.MatchString(repoName) {
+		return fmt.Errorf("repository %q: %w", repoName, ErrNameInvalid)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	desc, err := r.readEntry(fromRepo, repoBlobsDir, dig, ErrBlobUnknown)
+	if err != nil {
+		return err
+	}
+	if err := touch(r.contentPath(dig)); err != nil {
+		return err
+	}
+	return r.writeEntry(repoName, repoBlobsDir, desc)
+}
+
+func (r *FileRegistry) Tag(ctx context.Context, repoName string, dig Digest, tag string) error {
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.readEntry(repoName, repoManifestsDir, dig, ErrManifestUnknown); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(r.repoPath(repoName), repoTagsDir, tag), []byte(dig))
+}
+
+func (r *FileRegistry) DeleteBlob(ctx context.Context, repoName string, dig Digest) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.readEntry(repoName, repoBlobsDir, dig, ErrBlobUnknown); err != nil {
+		return err
+	}
+	return os.Remove(r.entryPath(repoName, repoBlobsDir, dig))
+}
+
+// DeleteManifest deletes the given manifest
+// along with any tags that refer to it.
+func (r *FileRegistry) DeleteManifest(ctx context.Context, repoName string, dig Digest) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if _, err := r.readEntry(repoName, repoManifestsDir, dig, ErrManifestUnknown); err != nil {
+		return err
+	}
+	tags, err := r.tags(repoName)
+	if err != nil {
+		return err
+	}
+	for tag, tagDigest := range tags {
+		if tagDigest == dig {
+			if err := os.Remove(filepath.Join(r.repoPath(repoName), repoTagsDir, tag)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Remove(r.entryPath(repoName, repoManifestsDir, dig))
+}
+
+func (r *FileRegistry) DeleteTag(ctx context.Context, repoName string, name string) error {
+	if _, err := r.readTag(repoName, name); err != nil {
+		return err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return os.Remove(filepath.Join(r.repoPath(repoName), repoTagsDir, name))
+}
+
+// Repositories returns the names of the repositories
+// in the registry in lexical order, as selected by opts.
+func (r *FileRegistry) Repositories(ctx context.Context, opts *ListOptions) Iter[string] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names, err := r.repos()
+	if err != nil {
+		return ErrorIter[string](err)
+	}
+	return SliceIter(SelectNames(names, opts))
+}
+
+// repos returns the names of all the repositories in lexical order.
+func (r *FileRegistry) repos() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(r.dir, fileReposDir))
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		name, err := url.PathUnescape(e.Name())
+		if err != nil || !e.IsDir() {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Tags returns the tags in the given repository
+// in lexical order, as selected by opts.
+func (r *FileRegistry) Tags(ctx context.Context, repoName string, opts *ListOptions) Iter[string] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.checkRepo(repoName); err != nil {
+		return ErrorIter[string](err)
+	}
+	tags, err := r.tags(repoName)
+	if err != nil {
+		return ErrorIter[string](err)
+	}
+	names := make([]string, 0, len(tags))
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	return SliceIter(SelectNames(names, opts))
+}
+
+// tags returns the digests of the manifests
+// that the named repository's tags refer to.
+func (r *FileRegistry) tags(repoName string) (map[string]Digest, error) {
+	dir := filepath.Join(r.repoPath(repoName), repoTagsDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	tags := make(map[string]Digest)
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), tempPrefix) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		tags[e.Name()] = Digest(data)
+	}
+	return tags, nil
+}
+
+// entries returns the descriptors recorded in the
+// given directory of the named repository, along with
+// when each was last written.
+func (r *FileRegistry) entries(repoName, kind string) (map[Digest]Descriptor, map[Digest]time.Time, error) {
+	descs := make(map[Digest]Descriptor)
+	times := make(map[Digest]time.Time)
+	err := filepath.WalkDir(filepath.Join(r.repoPath(repoName), kind), func(file string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var desc Descriptor
+		if err := json.Unmarshal(data, &desc); err != nil {
+			return fmt.Errorf("invalid entry %s: %v", file, err)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		descs[desc.Digest] = desc
+		times[desc.Digest] = info.ModTime()
+		return nil
+	})
+	return descs, times, err
+}
+
+// Referrers returns the descriptors of all the manifests in the
+// repository that have the given digest as their subject,
+// ordered by digest. It always applies the artifactType filter
+// when opts asks for it.
+func (r *FileRegistry) Referrers(ctx context.Context, repoName string, dig Digest, opts *ReferrersOptions) Iter[Descriptor] {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if err := r.checkRepo(repoName); err != nil {
+		return ErrorIter[Descriptor](err)
+	}
+	var artifactType string
+	if opts != nil {
+		artifactType = opts.ArtifactType
+	}
+	manifests, _, err := r.entries(repoName, repoManifestsDir)
+	if err != nil {
+		return ErrorIter[Descriptor](err)
+	}
+	var referrers []Descriptor
+	for _, desc := range manifests {
+		data, err := os.ReadFile(r.contentPath(desc.Digest))
+		if err != nil {
+			return ErrorIter[Descriptor](err)
+		}
+		if desc, ok := referrer(desc, data, dig, artifactType); ok {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrersIter(referrers, artifactType)
+}
+
+// fileBlob implements BlobReader for content held in a file.
+type fileBlob struct {
+	desc Descriptor
+	file string
+}
+
+func (b fileBlob) Descriptor() Descriptor {
+	return b.desc
+}
+
+func (b fileBlob) Open() io.ReadCloser {
+	f, err := os.Open(b.file)
+	if err != nil {
+		return errorReader{err}
+	}
+	return f
+}
+
+func (b fileBlob) OpenRange(p0, p1 int64) io.ReadCloser {
+	f, err := os.Open(b.file)
+	if err != nil {
+		return errorReader{err}
+	}
+	p0 = min(max(p0, 0), b.desc.Size)
+	p1 = min(max(p1, p0), b.desc.Size)
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.NewSectionReader(f, p0, p1-p0), f}
+}
+
+// errorReader is an io.ReadCloser that
+// returns an error from every read.
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+func (r errorReader) Close() error {
+	return nil
+}
+
+// writeFileAtomic writes data to file, creating its directory if
+// needed, so that readers never see a partly written file.
+func writeFileAtomic(file string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), tempPrefix)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// touch sets the modification time of file to now,
+// so that GC's grace period starts again.
+func touch(file string) error {
+	now := time.Now()
+	return os.Chtimes(file, now, now)
+}
+
+// GC deletes all the manifests and blobs in the registry that
+// aren't reachable from a tag in their repository (see [Reachable])
+// or from a manifest pushed within the grace period, and that weren't
+// pushed within the grace period themselves. Then the files of
+// content that's no longer in any repository are deleted, along
+// with any files left by interrupted uploads, and repositories
+// left empty are removed. The reported bytes are those of
+// the deleted files. If opts is nil, default options are used.
+//
+// Content is pushed again when it's pushed to another repository
+// or mounted, so other processes that push to the registry while
+// GC runs don't lose content as long as they push each manifest
+// within the grace period of pushing the content it refers to.
+func (r *FileRegistry) GC(ctx context.Context, opts *GCOptions) (*GCResult, error) {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	repos, err := r.repos()
+	if err != nil {
+		return nil, err
+	}
+	result := &GCResult{}
+	// recorded holds the content that was in a repository
+	// before GC; keep holds the content that still is.
+	recorded := make(map[Digest]bool)
+	keep := make(map[Digest]bool)
+	for _, name := range repos {
+		tags, err := r.tags(name)
+		if err != nil {
+			return nil, err
+		}
+		roots := make([]Digest, 0, len(tags))
+		for _, dig := range tags {
+			roots = append(roots, dig)
+		}
+		blobs, blobTimes, err := r.entries(name, repoBlobsDir)
+		if err != nil {
+			return nil, err
+		}
+		manifests, manifestTimes, err := r.entries(name, repoManifestsDir)
+		if err != nil {
+			return nil, err
+		}
+		manifestData := make(map[Digest][]byte)
+		for dig := range manifests {
+			// Manifests whose content is missing
+			// don't refer to anything.
+			manifestData[dig], _ = os.ReadFile(r.contentPath(dig))
+			if manifestTimes[dig].After(cutoff) {
+				roots = append(roots, dig)
+			}
+		}
+		live := Reachable(roots, manifestData)
+		deleted := 0
+		for _, content := range []struct {
+			kind  string
+			descs map[Digest]Descriptor
+			times map[Digest]time.Time
+		}{
+			{repoBlobsDir, blobs, blobTimes},
+			{repoManifestsDir, manifests, manifestTimes},
+		} {
+			for dig, desc := range content.descs {
+				recorded[dig] = true
+				if live[dig] || content.times[dig].After(cutoff) {
+					keep[dig] = true
+					continue
+				}
+				result.Deleted = append(result.Deleted, GCItem{
+					Repo: name,
+					Desc: desc,
+				})
+				deleted++
+				if opts.DryRun {
+					continue
+				}
+				if err := os.Remove(r.entryPath(name, content.kind, dig)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if !opts.DryRun && len(tags) == 0 && deleted == len(blobs)+len(manifests) {
+			if err := os.RemoveAll(r.repoPath(name)); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// Delete the files that nothing refers to any more.
+	err = filepath.WalkDir(filepath.Join(r.dir, fileBlobsDir), func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(cutoff) {
+			return nil
+		}
+		isTemp := strings.HasPrefix(d.Name(), tempPrefix)
+		dig := digest.NewDigestFromEncoded(digest.Algorithm(filepath.Base(filepath.Dir(file))), d.Name())
+		if !isTemp && keep[dig] {
+			return nil
+		}
+		result.Bytes += info.Size()
+		if !isTemp && !recorded[dig] {
+			result.Deleted = append(result.Deleted, GCItem{
+				Desc: Descriptor{
+					Digest: dig,
+					Size:   info.Size(),
+				},
+			})
+		}
+		if opts.DryRun {
+			return nil
+		}
+		return os.Remove(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortGCItems(result.Deleted)
+	return result, nil
 }
@@ -0,0 +1,174 @@
+package ociregistry
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestFileRegistry(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	r, err := NewFileRegistry(FileRegistryParams{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := pushBlob(t, r, "a/b", "application/vnd.oci.empty.v1+json", "{}")
+	layer := pushBlob(t, r, "a/b", "text/plain", "hello")
+	m := pushManifest(t, r, "a/b", config, layer)
+	if err := r.Tag(ctx, "a/b", m.Digest, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	sig := pushJSON(t, r, "a/b", ocispec.MediaTypeImageManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"artifactType":  "application/vnd.example.sig",
+		"config":        config,
+		"layers":        []Descriptor{},
+		"subject":       m,
+	})
+	if err := r.Mount(ctx, "c", "a/b", layer.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	// The content is still there when the registry is opened again.
+	r, err = NewFileRegistry(FileRegistryParams{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := r.GetTag(ctx, "a/b", "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Descriptor(); got.Digest != m.Digest || got.MediaType != m.MediaType || got.Size != m.Size {
+		t.Errorf("got tag descriptor %v; want %v", got, m)
+	}
+	b, err = r.GetBlob(ctx, "c", layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := readAll(b.Open()); err != nil || string(data) != "hello" {
+		t.Errorf("got blob content %q, %v; want %q", data, err, "hello")
+	}
+	if data, err := readAll(b.OpenRange(1, 3)); err != nil || string(data) != "el" {
+		t.Errorf("got blob range %q, %v; want %q", data, err, "el")
+	}
+	if repos, err := All(r.Repositories(ctx, nil)); err != nil || !equalStrings(repos, []string{"a/b", "c"}) {
+		t.Errorf("got repositories %q, %v; want [a/b c]", repos, err)
+	}
+	if tags, err := All(r.Tags(ctx, "a/b", &ListOptions{N: 1})); err != nil || !equalStrings(tags, []string{"v1"}) {
+		t.Errorf("got tags %q, %v; want [v1]", tags, err)
+	}
+	it := r.Referrers(ctx, "a/b", m.Digest, &ReferrersOptions{ArtifactType: "application/vnd.example.sig"})
+	referrers, err := All(it)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sig.Digest || referrers[0].ArtifactType != "application/vnd.example.sig" {
+		t.Errorf("got referrers %v; want %v", referrers, sig)
+	}
+	if filters := FiltersApplied(it); !equalStrings(filters, []string{"artifactType"}) {
+		t.Errorf("got filters %q; want [artifactType]", filters)
+	}
+
+	// Content must be in the repository it's asked for.
+	if _, err := r.GetBlob(ctx, "c", config.Digest); !errors.Is(err, ErrBlobUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrBlobUnknown)
+	}
+	if _, err := r.GetManifest(ctx, "other", m.Digest); !errors.Is(err, ErrNameUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrNameUnknown)
+	}
+	if _, err := r.PushManifest(ctx, "c", BytesBlob(mustReadAll(t, r, "a/b", m.Digest), m.MediaType), m); !errors.Is(err, ErrManifestBlobUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrManifestBlobUnknown)
+	}
+	if err := r.Tag(ctx, "a/b", m.Digest, "../../escape"); err == nil {
+		t.Errorf("invalid tag was accepted")
+	}
+
+	// Deleting a manifest deletes its tags.
+	if err := r.DeleteManifest(ctx, "a/b", m.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.GetTag(ctx, "a/b", "v1"); !errors.Is(err, ErrManifestUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrManifestUnknown)
+	}
+	if err := r.DeleteBlob(ctx, "c", layer.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteBlob(ctx, "c", layer.Digest); !errors.Is(err, ErrBlobUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrBlobUnknown)
+	}
+	// The layer is still in a/b.
+	if _, err := r.GetBlob(ctx, "a/b", layer.Digest); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileRegistryGC(t *testing.T) {
+	r, err := NewFileRegistry(FileRegistryParams{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testGC(t, r, func(repo string, dig Digest, pushed time.Time) {
+		// Content counts as pushed when both its
+		// file and its entry in repo were written.
+		files := []string{
+			r.contentPath(dig),
+			r.entryPath(repo, repoBlobsDir, dig),
+			r.entryPath(repo, repoManifestsDir, dig),
+		}
+		for _, file := range files {
+			if err := os.Chtimes(file, pushed, pushed); err != nil && !errors.Is(err, os.ErrNotExist) {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestFileRegistryGCOrphans(t *testing.T) {
+	ctx := context.Background()
+	r, err := NewFileRegistry(FileRegistryParams{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	// Content whose repository entries have been deleted
+	// is deleted, even though it was never in the result
+	// of GC for a repository.
+	blob := pushBlob(t, r, "repo", "text/plain", "orphan")
+	if err := r.DeleteBlob(ctx, "repo", blob.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(r.contentPath(blob.Digest), old, old); err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.GC(ctx, &GCOptions{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GCItem{{Desc: Descriptor{Digest: blob.Digest, Size: blob.Size}}}
+	if !equalGCItems(result.Deleted, want) || result.Bytes != blob.Size {
+		t.Errorf("got deleted %v (%d bytes); want %v (%d bytes)", result.Deleted, result.Bytes, want, blob.Size)
+	}
+	if _, err := os.Stat(r.contentPath(blob.Digest)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("orphaned content file was not deleted: %v", err)
+	}
+}
+
+// mustReadAll returns the content of the given manifest.
+func mustReadAll(t *testing.T, r Reader, repo string, dig Digest) []byte {
+	t.Helper()
+	b, err := r.GetManifest(context.Background(), repo, dig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readAll(b.Open())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,146 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// GCOptions holds options for garbage collection.
+type GCOptions struct {
+	// DryRun causes nothing to be deleted: the result
+	// reports what would have been deleted.
+	DryRun bool
+
+	// GracePeriod holds how long newly pushed content is kept
+	// even when nothing refers to it. Clients push blobs before
+	// the manifests that refer to them, so this stops uploads
+	// that are in progress from losing their blobs. Manifests
+	// pushed within the grace period are treated as roots, so
+	// everything they refer to is kept too, however old it is.
+	GracePeriod time.Duration
+}
+
+// GCResult describes the content deleted by garbage collection.
+type GCResult struct {
+	// Deleted holds the blobs and manifests that were deleted
+	// (or would have been, in a dry run), ordered by repository
+	// and then digest.
+	Deleted []GCItem
+
+	// Bytes holds the number of bytes of storage reclaimed
+	// (or reclaimable, in a dry run).
+	Bytes int64
+}
+
+// GCItem describes a blob or manifest deleted by garbage collection.
+type GCItem struct {
+	// Repo holds the repository that held the content. It's empty
+	// for stored content that wasn't in any repository, such as
+	// a blob in an image layout that's missing from its index.
+	Repo string
+	Desc Descriptor
+}
+
+// Reachable returns the set of content in a repository that's
+// reachable from the given roots, usually the digests of the
+// repository's tags. Content is reachable if it's a root, if a
+// reachable manifest refers to it, or if it's a manifest whose
+// subject is reachable, because referrers, such as signatures,
+// live as long as their subjects.
+//
+// The manifests map holds the content of every manifest
+// in the repository, keyed by digest.
+func Reachable(roots []Digest, manifests map[Digest][]byte) map[Digest]bool {
+	referrers := make(map[Digest][]Digest)
+	for dig, data := range manifests {
+		var m struct {
+			Subject *Descriptor `json:"subject"`
+		}
+		if err := json.Unmarshal(data, &m); err == nil && m.Subject != nil {
+			referrers[m.Subject.Digest] = append(referrers[m.Subject.Digest], dig)
+		}
+	}
+	live := make(map[Digest]bool)
+	queue := append([]Digest(nil), roots...)
+	for len(queue) > 0 {
+		dig := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		if live[dig] {
+			continue
+		}
+		live[dig] = true
+		queue = append(queue, referrers[dig]...)
+		data, ok := manifests[dig]
+		if !ok {
+			continue
+		}
+		// Manifests that can't be parsed
+		// don't refer to anything.
+		children, _ := manifestChildren(data)
+		for _, child := range children {
+			queue = append(queue, child.Digest)
+		}
+	}
+	return live
+}
+
+// sortGCItems sorts items by repository and then digest.
+func sortGCItems(items []GCItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Repo != items[j].Repo {
+			return items[i].Repo < items[j].Repo
+		}
+		return items[i].Desc.Digest < items[j].Desc.Digest
+	})
+}
+
+// GC deletes all the manifests and blobs in the registry that
+// aren't reachable from a tag in their repository (see [Reachable])
+// or from a manifest pushed within the grace period, and that weren't
+// pushed within the grace period themselves. Repositories left
+// empty are removed. If opts is nil, default options are used.
+func (r *MemRegistry) GC(ctx context.Context, opts *GCOptions) (*GCResult, error) {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	result := &GCResult{}
+	for name, repo := range r.repos {
+		roots := make([]Digest, 0, len(repo.tags))
+		for _, dig := range repo.tags {
+			roots = append(roots, dig)
+		}
+		manifests := make(map[Digest][]byte)
+		for dig, b := range repo.manifests {
+			manifests[dig] = b.data
+			if b.pushed.After(cutoff) {
+				roots = append(roots, dig)
+			}
+		}
+		live := Reachable(roots, manifests)
+		for _, content := range []map[Digest]*memBlob{repo.manifests, repo.blobs} {
+			for dig, b := range content {
+				if live[dig] || b.pushed.After(cutoff) {
+					continue
+				}
+				result.Deleted = append(result.Deleted, GCItem{
+					Repo: name,
+					Desc: b.desc,
+				})
+				result.Bytes += int64(len(b.data))
+				if !opts.DryRun {
+					delete(content, dig)
+				}
+			}
+		}
+		if !opts.DryRun && len(repo.tags) == 0 && len(repo.manifests) == 0 && len(repo.blobs) == 0 {
+			delete(r.repos, name)
+		}
+	}
+	sortGCItems(result.Deleted)
+	return result, nil
+}
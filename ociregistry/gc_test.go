@@ -0,0 +1,339 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var reachableTests = []struct {
+	testName string
+	// manifests maps names to manifests, in which names
+	// in descriptors are replaced by the digests of the
+	// named manifests or blobs.
+	manifests map[string]testManifest
+	roots     []string
+	want      []string
+}{{
+	testName: "Empty",
+}, {
+	testName: "Image",
+	manifests: map[string]testManifest{
+		"m": {config: "config", layers: []string{"l1", "l2"}},
+	},
+	roots: []string{"m"},
+	want:  []string{"config", "l1", "l2", "m"},
+}, {
+	testName: "Unreachable",
+	manifests: map[string]testManifest{
+		"m1": {config: "config", layers: []string{"l1"}},
+		"m2": {config: "config", layers: []string{"l2"}},
+	},
+	roots: []string{"m1"},
+	want:  []string{"config", "l1", "m1"},
+}, {
+	testName: "Index",
+	manifests: map[string]testManifest{
+		"index": {manifests: []string{"m1", "m2"}},
+		"m1":    {config: "config", layers: []string{"l1"}},
+		"m2":    {config: "config", layers: []string{"l2"}},
+		"m3":    {config: "config", layers: []string{"l3"}},
+	},
+	roots: []string{"index"},
+	want:  []string{"config", "index", "l1", "l2", "m1", "m2"},
+}, {
+	testName: "Referrers",
+	manifests: map[string]testManifest{
+		"m":   {config: "config", layers: []string{"l1"}},
+		"sig": {config: "sigconfig", layers: []string{"sig1"}, subject: "m"},
+		// A referrer of a referrer is kept too.
+		"sigsig": {config: "sigconfig", subject: "sig"},
+		// A referrer of unreachable content isn't.
+		"other": {config: "sigconfig", layers: []string{"sig2"}, subject: "gone"},
+	},
+	roots: []string{"m"},
+	want:  []string{"config", "l1", "m", "sig", "sig1", "sigconfig", "sigsig"},
+}, {
+	testName: "BlobRoot",
+	roots:    []string{"blob"},
+	want:     []string{"blob"},
+}, {
+	testName: "InvalidManifest",
+	manifests: map[string]testManifest{
+		"m": {invalid: true},
+	},
+	roots: []string{"m"},
+	want:  []string{"m"},
+}}
+
+func TestReachable(t *testing.T) {
+	for _, test := range reachableTests {
+		t.Run(test.testName, func(t *testing.T) {
+			names := make(map[Digest]string)
+			dig := func(name string) Digest {
+				d := digest.FromString(name)
+				names[d] = name
+				return d
+			}
+			manifests := make(map[Digest][]byte)
+			for name, m := range test.manifests {
+				manifests[dig(name)] = m.data(dig)
+			}
+			var roots []Digest
+			for _, name := range test.roots {
+				roots = append(roots, dig(name))
+			}
+			var got []string
+			for d := range Reachable(roots, manifests) {
+				got = append(got, names[d])
+			}
+			sort.Strings(got)
+			if !equalStrings(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemRegistryGC(t *testing.T) {
+	r := NewMemRegistry()
+	testGC(t, r, r.setPushed)
+}
+
+// gcRegistry is implemented by registries that can be garbage-collected.
+type gcRegistry interface {
+	Interface
+	Lister
+	GC(ctx context.Context, opts *GCOptions) (*GCResult, error)
+}
+
+// testGC tests r's GC method. The setPushed function
+// sets the time that content was pushed to a repository.
+func testGC(t *testing.T, r gcRegistry, setPushed func(repo string, dig Digest, pushed time.Time)) {
+	ctx := context.Background()
+	old := time.Now().Add(-2 * time.Hour)
+
+	// An upload that's in progress: its config and layer were
+	// pushed long ago, but the manifest that refers to them has
+	// only just been pushed and not yet tagged.
+	config := pushBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	layer := pushBlob(t, r, "repo", "text/plain", "layer")
+	setPushed("repo", config.Digest, old)
+	setPushed("repo", layer.Digest, old)
+	m := pushManifest(t, r, "repo", config, layer)
+
+	// A tagged image, all pushed long ago.
+	taggedLayer := pushBlob(t, r, "repo", "text/plain", "tagged")
+	tagged := pushManifest(t, r, "repo", config, taggedLayer)
+	if err := r.Tag(ctx, "repo", tagged.Digest, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	setPushed("repo", taggedLayer.Digest, old)
+	setPushed("repo", tagged.Digest, old)
+
+	// Garbage: an old untagged manifest, its layer, a stray
+	// old blob, and a recent blob that's kept anyway.
+	oldLayer := pushBlob(t, r, "repo", "text/plain", "old")
+	oldManifest := pushManifest(t, r, "repo", config, oldLayer)
+	stray := pushBlob(t, r, "repo", "text/plain", "stray")
+	recent := pushBlob(t, r, "repo", "text/plain", "recent")
+	setPushed("repo", oldLayer.Digest, old)
+	setPushed("repo", oldManifest.Digest, old)
+	setPushed("repo", stray.Digest, old)
+
+	// A repository left empty by GC.
+	gone := pushBlob(t, r, "gone", "text/plain", "gone")
+	setPushed("gone", gone.Digest, old)
+
+	opts := &GCOptions{
+		GracePeriod: time.Hour,
+		DryRun:      true,
+	}
+	want := []GCItem{
+		{Repo: "gone", Desc: gone},
+		{Repo: "repo", Desc: oldLayer},
+		{Repo: "repo", Desc: oldManifest},
+		{Repo: "repo", Desc: stray},
+	}
+	sortGCItems(want)
+	wantBytes := gone.Size + oldLayer.Size + oldManifest.Size + stray.Size
+	for _, dryRun := range []bool{true, false} {
+		opts.DryRun = dryRun
+		result, err := r.GC(ctx, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalGCItems(result.Deleted, want) || result.Bytes != wantBytes {
+			t.Errorf("dry run %v: got deleted %v (%d bytes); want %v (%d bytes)", dryRun, result.Deleted, result.Bytes, want, wantBytes)
+		}
+	}
+	for _, desc := range []Descriptor{config, layer, taggedLayer, recent} {
+		if _, err := r.GetBlob(ctx, "repo", desc.Digest); err != nil {
+			t.Errorf("blob %s was deleted: %v", desc.Digest, err)
+		}
+	}
+	for _, desc := range []Descriptor{m, tagged} {
+		if _, err := r.GetManifest(ctx, "repo", desc.Digest); err != nil {
+			t.Errorf("manifest %s was deleted: %v", desc.Digest, err)
+		}
+	}
+	for _, desc := range []Descriptor{oldLayer, stray} {
+		if _, err := r.GetBlob(ctx, "repo", desc.Digest); err == nil {
+			t.Errorf("blob %s was not deleted", desc.Digest)
+		}
+	}
+	if repos, err := All(r.Repositories(ctx, nil)); err != nil || !equalStrings(repos, []string{"repo"}) {
+		t.Errorf("got repositories %q, %v; want [repo]", repos, err)
+	}
+
+	// Once the grace period is over, only the tagged
+	// content is left.
+	result, err := r.GC(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []GCItem{
+		{Repo: "repo", Desc: layer},
+		{Repo: "repo", Desc: m},
+		{Repo: "repo", Desc: recent},
+	}
+	sortGCItems(want)
+	if !equalGCItems(result.Deleted, want) {
+		t.Errorf("got deleted %v; want %v", result.Deleted, want)
+	}
+}
+
+// setPushed sets the time that the content with the
+// given digest was pushed to repo.
+func (r *MemRegistry) setPushed(repo string, dig Digest, pushed time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.repos[repo].blobs[dig]; ok {
+		b.pushed = pushed
+	}
+	if b, ok := r.repos[repo].manifests[dig]; ok {
+		b.pushed = pushed
+	}
+}
+
+// testManifest describes a manifest for tests, with
+// its content referred to by name.
+type testManifest struct {
+	config    string
+	layers    []string
+	manifests []string
+	subject   string
+	invalid   bool
+}
+
+// data returns the JSON encoding of m, using dig to find
+// the digests of the named content.
+func (m testManifest) data(dig func(string) Digest) []byte {
+	if m.invalid {
+		return []byte("{")
+	}
+	desc := func(name, mediaType string) Descriptor {
+		return Descriptor{
+			MediaType: mediaType,
+			Digest:    dig(name),
+			Size:      int64(len(name)),
+		}
+	}
+	x := map[string]any{
+		"schemaVersion": 2,
+	}
+	if m.config != "" {
+		x["config"] = desc(m.config, "application/vnd.oci.empty.v1+json")
+	}
+	var layers, manifests []Descriptor
+	for _, name := range m.layers {
+		layers = append(layers, desc(name, "text/plain"))
+	}
+	for _, name := range m.manifests {
+		manifests = append(manifests, desc(name, ocispec.MediaTypeImageManifest))
+	}
+	if layers != nil {
+		x["layers"] = layers
+	}
+	if manifests != nil {
+		x["manifests"] = manifests
+	}
+	if m.subject != "" {
+		x["subject"] = desc(m.subject, ocispec.MediaTypeImageManifest)
+	}
+	data, err := json.Marshal(x)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// pushBlob pushes data to repo as a blob with the given media type.
+func pushBlob(t *testing.T, r Writer, repo, mediaType, data string) Descriptor {
+	t.Helper()
+	desc := Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromString(data),
+		Size:      int64(len(data)),
+	}
+	desc, err := r.PushBlob(context.Background(), repo, BytesBlob([]byte(data), mediaType), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+// pushManifest pushes an image manifest with the given
+// config and layers to repo.
+func pushManifest(t *testing.T, r Writer, repo string, config Descriptor, layers ...Descriptor) Descriptor {
+	t.Helper()
+	m := &Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	}
+	m.SchemaVersion = 2
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := Descriptor{
+		MediaType: m.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	desc, err = r.PushManifest(context.Background(), repo, BytesBlob(data, desc.MediaType), desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalGCItems(a, b []GCItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Repo != b[i].Repo || a[i].Desc.Digest != b[i].Desc.Digest || a[i].Desc.Size != b[i].Desc.Size {
+			return false
+		}
+	}
+	return true
+}
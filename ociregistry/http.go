@@ -2,33 +2,207 @@ package ociregistry
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // HTTPRegistry provides access to the HTTP registry API, as defined [here].
 // It implements [Interface].
 //
-// [here]:  https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+// [here]: https://github.com/opencontainers/distribution-spec/blob/main/spec.md
 type HTTPRegistry struct {
-	// unexported fields
+	url    string
+	client *http.Client
 }
 
+// HTTPRegistryParams holds the parameters for [NewHTTPRegistry].
 type HTTPRegistryParams struct {
+	// URL holds the base URL of the registry,
+	// such as "https://registry.example.com".
+	URL string
+
+	// Client is used to make requests to the registry.
+	// If it's nil, [http.DefaultClient] is used.
+	Client *http.Client
 }
 
 func NewHTTPRegistry(p HTTPRegistryParams) *HTTPRegistry {
-	panic("TODO")
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPRegistry{
+		url:    strings.TrimSuffix(p.URL, "/"),
+		client: client,
+	}
 }
 
-func (r *HTTPRegistry) Repositories(ctx context.Context) Iter[string] {
-	panic("TODO")
+// Repositories implements [Lister.Repositories] using the catalog API.
+// Last and N are passed to the registry as the last and n parameters,
+// and later pages are read by following the Link header of each
+// response. The registry can't filter by prefix, so names before
+// the prefix are skipped, and no more pages are read once a name
+// after the prefix has been seen.
+func (r *HTTPRegistry) Repositories(ctx context.Context, opts *ListOptions) Iter[string] {
+	var page struct {
+		Repositories []string `json:"repositories"`
+	}
+	return r.listNames(ctx, r.url+"/v2/_catalog", opts, &page, &page.Repositories)
 }
 
-func (r *HTTPRegistry) Tags(ctx context.Context, repo string) Iter[string] {
-	panic("TODO")
+// Tags implements [Lister.Tags] using the tags API,
+// in the same way as [HTTPRegistry.Repositories].
+func (r *HTTPRegistry) Tags(ctx context.Context, repo string, opts *ListOptions) Iter[string] {
+	var page struct {
+		Tags []string `json:"tags"`
+	}
+	return r.listNames(ctx, r.url+"/v2/"+repo+"/tags/list", opts, &page, &page.Tags)
 }
 
-func (r *HTTPRegistry) Referrers(ctx context.Context, repo string, digest Digest, artifactType string) Iter[Descriptor] {
-	panic("TODO")
+// listNames reads the names selected by opts from the list at the
+// given URL, a page at a time. Each page is decoded into page,
+// which must hold its names in *names.
+func (r *HTTPRegistry) listNames(ctx context.Context, u string, opts *ListOptions, page any, names *[]string) Iter[string] {
+	if opts == nil {
+		opts = &ListOptions{}
+	}
+	query := url.Values{}
+	if opts.Last != "" {
+		query.Set("last", opts.Last)
+	}
+	if opts.N > 0 {
+		query.Set("n", strconv.Itoa(opts.N))
+	}
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var selected []string
+	for u != "" {
+		*names = nil
+		resp, err := r.get(ctx, u, page)
+		if err != nil {
+			return ErrorIter[string](err)
+		}
+		for _, name := range *names {
+			// Names up to Last are skipped in case
+			// the registry ignores the last parameter.
+			if name <= opts.Last || !strings.HasPrefix(name, opts.Prefix) {
+				if name > opts.Prefix && name > opts.Last {
+					return SliceIter(selected)
+				}
+				continue
+			}
+			selected = append(selected, name)
+			if opts.N > 0 && len(selected) >= opts.N {
+				return SliceIter(selected)
+			}
+		}
+		u = nextLink(resp)
+	}
+	return SliceIter(selected)
+}
+
+// Referrers implements [Lister.Referrers] using the referrers API.
+// The artifactType option is passed to the registry, and the
+// returned iterator reports the filters that the registry applied,
+// as given by the OCI-Filters-Applied header.
+func (r *HTTPRegistry) Referrers(ctx context.Context, repo string, digest Digest, opts *ReferrersOptions) Iter[Descriptor] {
+	u := r.url + "/v2/" + repo + "/referrers/" + string(digest)
+	if opts != nil && opts.ArtifactType != "" {
+		u += "?" + url.Values{"artifactType": {opts.ArtifactType}}.Encode()
+	}
+	var (
+		referrers []Descriptor
+		filters   []string
+	)
+	for first := true; u != ""; first = false {
+		var index ocispec.Index
+		resp, err := r.get(ctx, u, &index)
+		if err != nil {
+			return ErrorIter[Descriptor](err)
+		}
+		if first {
+			for _, f := range strings.Split(resp.Header.Get("OCI-Filters-Applied"), ",") {
+				if f = strings.TrimSpace(f); f != "" {
+					filters = append(filters, f)
+				}
+			}
+		}
+		referrers = append(referrers, index.Manifests...)
+		u = nextLink(resp)
+	}
+	if len(filters) > 0 {
+		return FilteredIter(SliceIter(referrers), filters...)
+	}
+	return SliceIter(referrers)
+}
+
+// get makes a GET request for the given URL
+// and decodes the JSON response into x.
+func (r *HTTPRegistry) get(ctx context.Context, u string, x any) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(x); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %v", req.URL, err)
+	}
+	return resp, nil
+}
+
+// readError returns the error described by resp, an error response.
+// Errors with the codes of the errors in this package wrap them.
+func readError(resp *http.Response) error {
+	var e struct {
+		Errors []struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(data, &e); err != nil || len(e.Errors) == 0 {
+		return fmt.Errorf("registry responded with status %s", resp.Status)
+	}
+	for _, c := range errorCodes {
+		if c.code == e.Errors[0].Code {
+			return fmt.Errorf("%s: %w", e.Errors[0].Message, c.err)
+		}
+	}
+	return fmt.Errorf("registry responded with status %s: %s: %s", resp.Status, e.Errors[0].Code, e.Errors[0].Message)
+}
+
+// nextLink returns the URL of the next page of a list, as given by
+// the Link header of resp, or the empty string if there isn't one.
+func nextLink(resp *http.Response) string {
+	link := resp.Header.Get("Link")
+	target, params, ok := strings.Cut(link, ";")
+	if !ok || !strings.Contains(params, `rel="next"`) {
+		return ""
+	}
+	target = strings.TrimSpace(target)
+	if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+		return ""
+	}
+	u, err := resp.Request.URL.Parse(target[1 : len(target)-1])
+	if err != nil {
+		return ""
+	}
+	return u.String()
 }
 
 func (r *HTTPRegistry) GetManifest(ctx context.Context, repo string, digest Digest) (BlobReader, error) {
@@ -0,0 +1,152 @@
+package ociregistry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestHTTPRegistryList(t *testing.T) {
+	ctx := context.Background()
+	mr := NewMemRegistry()
+	config := pushBlob(t, mr, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	m := pushManifest(t, mr, "repo", config)
+	for _, tag := range []string{"a", "latest", "v0.1.0", "v0.2.0", "v0.3.0", "v1.0.0", "w"} {
+		if err := mr.Tag(ctx, "repo", m.Digest, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, repo := range []string{"a/x", "a/y", "b"} {
+		pushBlob(t, mr, repo, "text/plain", "x")
+	}
+	sig := pushJSON(t, mr, "repo", ocispec.MediaTypeImageManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"artifactType":  "application/vnd.example.sig",
+		"config":        config,
+		"layers":        []Descriptor{},
+		"subject":       m,
+	})
+
+	// The server returns at most two names at a time
+	// unless asked for fewer, so that the client must
+	// follow the Link headers to read a whole list.
+	var queries []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		queries = append(queries, req.URL.RawQuery)
+		if query := req.URL.Query(); query.Get("n") == "" {
+			query.Set("n", "2")
+			req.URL.RawQuery = query.Encode()
+		}
+		Serve(mr).ServeHTTP(w, req)
+	}))
+	defer srv.Close()
+	r := NewHTTPRegistry(HTTPRegistryParams{
+		URL: srv.URL + "/",
+	})
+
+	tests := []struct {
+		testName    string
+		repo        string
+		opts        *ListOptions
+		want        []string
+		wantQueries []string
+	}{{
+		testName:    "AllTags",
+		repo:        "repo",
+		want:        []string{"a", "latest", "v0.1.0", "v0.2.0", "v0.3.0", "v1.0.0", "w"},
+		wantQueries: []string{"", "last=latest&n=2", "last=v0.2.0&n=2", "last=v1.0.0&n=2"},
+	}, {
+		testName:    "LastAndN",
+		repo:        "repo",
+		opts:        &ListOptions{Last: "v0.1.0", N: 3},
+		want:        []string{"v0.2.0", "v0.3.0", "v1.0.0"},
+		wantQueries: []string{"last=v0.1.0&n=3"},
+	}, {
+		// Reading stops at the first name past the prefix.
+		testName:    "Prefix",
+		repo:        "repo",
+		opts:        &ListOptions{Prefix: "v0."},
+		want:        []string{"v0.1.0", "v0.2.0", "v0.3.0"},
+		wantQueries: []string{"", "last=latest&n=2", "last=v0.2.0&n=2"},
+	}, {
+		testName:    "PrefixAndN",
+		repo:        "repo",
+		opts:        &ListOptions{Prefix: "v", N: 2},
+		want:        []string{"v0.1.0", "v0.2.0"},
+		wantQueries: []string{"n=2", "last=latest&n=2"},
+	}, {
+		testName:    "Repositories",
+		want:        []string{"a/x", "a/y", "b", "repo"},
+		wantQueries: []string{"", "last=a%2Fy&n=2", "last=repo&n=2"},
+	}, {
+		testName:    "RepositoriesPrefix",
+		opts:        &ListOptions{Prefix: "a/"},
+		want:        []string{"a/x", "a/y"},
+		wantQueries: []string{"", "last=a%2Fy&n=2"},
+	}}
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			queries = nil
+			var it Iter[string]
+			if test.repo != "" {
+				it = r.Tags(ctx, test.repo, test.opts)
+			} else {
+				it = r.Repositories(ctx, test.opts)
+			}
+			got, err := All(it)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalStrings(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+			if !equalStrings(queries, test.wantQueries) {
+				t.Errorf("got queries %q; want %q", queries, test.wantQueries)
+			}
+		})
+	}
+
+	if _, err := All(r.Tags(ctx, "nothing", nil)); !errors.Is(err, ErrNameUnknown) {
+		t.Errorf("got error %v; want %v", err, ErrNameUnknown)
+	}
+
+	for _, test := range []struct {
+		opts        *ReferrersOptions
+		want        []Descriptor
+		wantFilters []string
+	}{{
+		want: []Descriptor{sig},
+	}, {
+		opts:        &ReferrersOptions{ArtifactType: "application/vnd.example.sig"},
+		want:        []Descriptor{sig},
+		wantFilters: []string{"artifactType"},
+	}, {
+		opts:        &ReferrersOptions{ArtifactType: "application/vnd.example.other"},
+		wantFilters: []string{"artifactType"},
+	}} {
+		it := r.Referrers(ctx, "repo", m.Digest, test.opts)
+		got, err := All(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalDigests(digests(got), digests(test.want)) {
+			t.Errorf("options %+v: got referrers %v; want %v", test.opts, digests(got), digests(test.want))
+		}
+		if filters := FiltersApplied(it); !equalStrings(filters, test.wantFilters) {
+			t.Errorf("options %+v: got filters %q; want %q", test.opts, filters, test.wantFilters)
+		}
+	}
+}
+
+func digests(descs []Descriptor) []Digest {
+	var digs []Digest
+	for _, desc := range descs {
+		digs = append(digs, desc.Digest)
+	}
+	return digs
+}
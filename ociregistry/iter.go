@@ -16,3 +16,79 @@ func All[T any](it Iter[T]) ([]T, error) {
 		xs = append(xs, x)
 	}
 }
+
+// SliceIter returns an Iter that produces all the elements of xs
+// in order.
+func SliceIter[T any](xs []T) Iter[T] {
+	return &sliceIter[T]{
+		xs: xs,
+	}
+}
+
+type sliceIter[T any] struct {
+	i  int
+	xs []T
+}
+
+func (it *sliceIter[T]) Close() {}
+
+func (it *sliceIter[T]) Next() (T, bool) {
+	if it.i >= len(it.xs) {
+		return *new(T), false
+	}
+	x := it.xs[it.i]
+	it.i++
+	return x, true
+}
+
+func (it *sliceIter[T]) Error() error {
+	return nil
+}
+
+// ErrorIter returns an Iter that produces no elements
+// and returns the given error from its Error method.
+func ErrorIter[T any](err error) Iter[T] {
+	return errorIter[T]{err}
+}
+
+type errorIter[T any] struct {
+	err error
+}
+
+func (it errorIter[T]) Close() {}
+
+func (it errorIter[T]) Next() (T, bool) {
+	return *new(T), false
+}
+
+func (it errorIter[T]) Error() error {
+	return it.err
+}
+
+// FilteredIter returns an Iter that produces the same elements as it
+// and reports that the given filters were applied to them: see
+// [FiltersApplied].
+func FilteredIter[T any](it Iter[T], filters ...string) Iter[T] {
+	return filteredIter[T]{it, filters}
+}
+
+type filteredIter[T any] struct {
+	Iter[T]
+	filters []string
+}
+
+func (it filteredIter[T]) FiltersApplied() []string {
+	return it.filters
+}
+
+// FiltersApplied returns the filters that were applied to the
+// elements produced by it, such as "artifactType" for the results of
+// [Lister.Referrers]. It corresponds to the OCI-Filters-Applied
+// header of the referrers API. Iterators made by [FilteredIter]
+// report their filters; other iterators report none.
+func FiltersApplied[T any](it Iter[T]) []string {
+	if it, ok := it.(interface{ FiltersApplied() []string }); ok {
+		return it.FiltersApplied()
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemRegistry is an in-memory implementation of [Interface] and [Lister].
+// It's useful for tests and dry runs.
+type MemRegistry struct {
+	mu    sync.Mutex
+	repos map[string]*memRepo
+}
+
+type memRepo struct {
+	blobs     map[Digest]*memBlob
+	manifests map[Digest]*memBlob
+	tags      map[string]Digest
+}
+
+type memBlob struct {
+	desc Descriptor
+	data []byte
+	// pushed holds when the content was pushed
+	// to the repository, for GC's grace period.
+	pushed time.Time
+}
+
+var (
+	_ Interface = (*MemRegistry)(nil)
+	_ Lister    = (*MemRegistry)(nil)
+)
+
+// NewMemRegistry returns a new, empty in-memory registry.
+func NewMemRegistry() *MemRegistry {
+	return &MemRegistry{
+		repos: make(map[string]*memRepo),
+	}
+}
+
+// repo returns the repository with the given name. If create is true,
+// the repository is created if it does not exist; otherwise an error
+// is returned. Called with r.mu held.
+func (r *MemRegistry) repo(name string, create bool) (*memRepo, error) {
+	if repo, ok := r.repos[name]; ok {
+		return repo, nil
+	}
+	if !create {
+		return nil, fmt.Errorf("repository %q: %w", name, ErrNameUnknown)
+	}
+	repo := &memRepo{
+		blobs:     make(map[Digest]*memBlob),
+		manifests: make(map[Digest]*memBlob),
+		tags:      make(map[string]Digest),
+	}
+	r.repos[name] = repo
+	return repo, nil
+}
+
+func (r *MemRegistry) GetBlob(ctx context.Context, repoName string, dig Digest) (BlobReader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := repo.blobs[dig]
+	if !ok {
+		return nil, fmt.Errorf("blob %s in %q: %w", dig, repoName, ErrBlobUnknown)
+	}
+	return b.reader(), nil
+}
+
+func (r *MemRegistry) GetManifest(ctx context.Context, repoName string, dig Digest) (BlobReader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := repo.manifests[dig]
+	if !ok {
+		return nil, fmt.Errorf("manifest %s in %q: %w", dig, repoName, ErrManifestUnknown)
+	}
+	return b.reader(), nil
+}
+
+func (r *MemRegistry) GetTag(ctx context.Context, repoName string, tagName string) (BlobReader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return nil, err
+	}
+	dig, ok := repo.tags[tagName]
+	if !ok {
+		return nil, fmt.Errorf("tag %q in %q: %w", tagName, repoName, ErrManifestUnknown)
+	}
+	return repo.manifests[dig].reader(), nil
+}
+
+func (r *MemRegistry) PushBlob(ctx context.Context, repoName string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	data, err := readAll(c.Open())
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkContent(desc, data); err != nil {
+		return Descriptor{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, _ := r.repo(repoName, true)
+	repo.blobs[desc.Digest] = &memBlob{
+		desc:   desc,
+		data:   data,
+		pushed: time.Now(),
+	}
+	return desc, nil
+}
+
+func (r *MemRegistry) PushManifest(ctx context.Context, repoName string, c BlobReader, desc Descriptor) (Descriptor, error) {
+	data, err := readAll(c.Open())
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := checkContent(desc, data); err != nil {
+		return Descriptor{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, _ := r.repo(repoName, true)
+	if err := repo.checkRefs(data); err != nil {
+		return Descriptor{}, fmt.Errorf("manifest %s in %q: %w", desc.Digest, repoName, err)
+	}
+	repo.manifests[desc.Digest] = &memBlob{
+		desc:   desc,
+		data:   data,
+		pushed: time.Now(),
+	}
+	return desc, nil
+}
+
+// checkRefs checks that everything the manifest with the given
+// content refers to, apart from its subject, is in the repository,
+// as registries do before they accept a manifest.
+func (repo *memRepo) checkRefs(data []byte) error {
+	return checkRefs(data, func(dig Digest) bool {
+		_, ok := repo.blobs[dig]
+		return ok
+	}, func(dig Digest) bool {
+		_, ok := repo.manifests[dig]
+		return ok
+	})
+}
+
+// checkRefs checks that the manifest with the given content only
+// refers to blobs for which hasBlob returns true and manifests for
+// which hasManifest returns true. The subject isn't checked.
+func checkRefs(data []byte, hasBlob, hasManifest func(Digest) bool) error {
+	var m struct {
+		Config    *Descriptor  `json:"config"`
+		Layers    []Descriptor `json:"layers"`
+		Blobs     []Descriptor `json:"blobs"`
+		Manifests []Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return fmt.Errorf("%w: %v", ErrManifestInvalid, err)
+	}
+	blobs := append(m.Layers, m.Blobs...)
+	if m.Config != nil {
+		blobs = append(blobs, *m.Config)
+	}
+	for _, desc := range blobs {
+		if !hasBlob(desc.Digest) {
+			return fmt.Errorf("blob %s: %w", desc.Digest, ErrManifestBlobUnknown)
+		}
+	}
+	for _, desc := range m.Manifests {
+		if !hasManifest(desc.Digest) {
+			return fmt.Errorf("manifest %s: %w", desc.Digest, ErrManifestBlobUnknown)
+		}
+	}
+	return nil
+}
+
+// checkContent checks that data matches desc.
+func checkContent(desc Descriptor, data []byte) error {
+	if int64(len(data)) != desc.Size {
+		return fmt.Errorf("content size %d does not match descriptor size %d", len(data), desc.Size)
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %v", desc.Digest, err)
+	}
+	if dig := desc.Digest.Algorithm().FromBytes(data); dig != desc.Digest {
+		return fmt.Errorf("content digest %s does not match descriptor digest %s", dig, desc.Digest)
+	}
+	return nil
+}
+
+func (r *MemRegistry) Mount(ctx context.Context, repoName string, fromRepo string, dig Digest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	from, err := r.repo(fromRepo, false)
+	if err != nil {
+		return err
+	}
+	b, ok := from.blobs[dig]
+	if !ok {
+		return fmt.Errorf("blob %s in %q: %w", dig, fromRepo, ErrBlobUnknown)
+	}
+	repo, _ := r.repo(repoName, true)
+	repo.blobs[dig] = &memBlob{
+		desc:   b.desc,
+		data:   b.data,
+		pushed: time.Now(),
+	}
+	return nil
+}
+
+func (r *MemRegistry) Tag(ctx context.Context, repoName string, dig Digest, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := repo.manifests[dig]; !ok {
+		return fmt.Errorf("manifest %s in %q: %w", dig, repoName, ErrManifestUnknown)
+	}
+	repo.tags[tag] = dig
+	return nil
+}
+
+func (r *MemRegistry) DeleteBlob(ctx context.Context, repoName string, dig Digest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := repo.blobs[dig]; !ok {
+		return fmt.Errorf("blob %s in %q: %w", dig, repoName, ErrBlobUnknown)
+	}
+	delete(repo.blobs, dig)
+	return nil
+}
+
+// DeleteManifest deletes the given manifest
+// along with any tags that refer to it.
+func (r *MemRegistry) DeleteManifest(ctx context.Context, repoName string, dig Digest) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := repo.manifests[dig]; !ok {
+		return fmt.Errorf("manifest %s in %q: %w", dig, repoName, ErrManifestUnknown)
+	}
+	delete(repo.manifests, dig)
+	for tag, tagDigest := range repo.tags {
+		if tagDigest == dig {
+			delete(repo.tags, tag)
+		}
+	}
+	return nil
+}
+
+func (r *MemRegistry) DeleteTag(ctx context.Context, repoName string, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := repo.tags[name]; !ok {
+		return fmt.Errorf("tag %q in %q: %w", name, repoName, ErrManifestUnknown)
+	}
+	delete(repo.tags, name)
+	return nil
+}
+
+// Repositories returns the names of the repositories
+// in the registry in lexical order, as selected by opts.
+func (r *MemRegistry) Repositories(ctx context.Context, opts *ListOptions) Iter[string] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	names := make([]string, 0, len(r.repos))
+	for name := range r.repos {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return SliceIter(SelectNames(names, opts))
+}
+
+// Tags returns the tags in the given repository
+// in lexical order, as selected by opts.
+func (r *MemRegistry) Tags(ctx context.Context, repoName string, opts *ListOptions) Iter[string] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return ErrorIter[string](err)
+	}
+	tags := make([]string, 0, len(repo.tags))
+	for tag := range repo.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return SliceIter(SelectNames(tags, opts))
+}
+
+// Referrers returns the descriptors of all the manifests in the
+// repository that have the given digest as their subject,
+// ordered by digest. It always applies the artifactType filter
+// when opts asks for it.
+func (r *MemRegistry) Referrers(ctx context.Context, repoName string, dig Digest, opts *ReferrersOptions) Iter[Descriptor] {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	repo, err := r.repo(repoName, false)
+	if err != nil {
+		return ErrorIter[Descriptor](err)
+	}
+	var artifactType string
+	if opts != nil {
+		artifactType = opts.ArtifactType
+	}
+	var referrers []Descriptor
+	for _, b := range repo.manifests {
+		if desc, ok := referrer(b.desc, b.data, dig, artifactType); ok {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrersIter(referrers, artifactType)
+}
+
+// referrer reports whether the manifest with the given descriptor
+// and content has the given subject and, if artifactType isn't
+// empty, the given artifact type. If so, it returns the descriptor
+// with its artifact type filled in, as in the referrers API.
+func referrer(desc Descriptor, data []byte, subject Digest, artifactType string) (Descriptor, bool) {
+	var m struct {
+		ArtifactType string      `json:"artifactType"`
+		Config       Descriptor  `json:"config"`
+		Subject      *Descriptor `json:"subject"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil || m.Subject == nil || m.Subject.Digest != subject {
+		return Descriptor{}, false
+	}
+	if desc.ArtifactType = m.ArtifactType; desc.ArtifactType == "" {
+		desc.ArtifactType = m.Config.MediaType
+	}
+	if artifactType != "" && desc.ArtifactType != artifactType {
+		return Descriptor{}, false
+	}
+	return desc, true
+}
+
+// referrersIter returns an iterator over the given referrers,
+// ordered by digest, that reports the artifactType filter
+// as applied if artifactType isn't empty.
+func referrersIter(referrers []Descriptor, artifactType string) Iter[Descriptor] {
+	sort.Slice(referrers, func(i, j int) bool {
+		return referrers[i].Digest < referrers[j].Digest
+	})
+	if artifactType != "" {
+		return FilteredIter(SliceIter(referrers), "artifactType")
+	}
+	return SliceIter(referrers)
+}
+
+func (b *memBlob) reader() BlobReader {
+	return bytesBlob{
+		desc: b.desc,
+		data: b.data,
+	}
+}
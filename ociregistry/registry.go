@@ -1,10 +1,11 @@
 package ociregistry
 
 import (
+	"bytes"
 	"context"
 	"io"
-	"net/http"
 	"os"
+	"strings"
 
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
@@ -44,10 +45,75 @@ type Deleter interface {
 	DeleteTag(ctx context.Context, repo string, name string) error
 }
 
+// Lister is implemented by registries that can list their contents.
 type Lister interface {
-	Repositories(ctx context.Context) Iter[string]
-	Tags(ctx context.Context, repo string) Iter[string]
-	Referrers(ctx context.Context, repo string, digest Digest, artifactType string) Iter[Descriptor]
+	// Repositories returns the names of the repositories in the
+	// registry in lexical order, as selected by opts, which may be nil.
+	Repositories(ctx context.Context, opts *ListOptions) Iter[string]
+
+	// Tags returns the tags in the given repository in lexical
+	// order, as selected by opts, which may be nil.
+	Tags(ctx context.Context, repo string, opts *ListOptions) Iter[string]
+
+	// Referrers returns the descriptors of the manifests in the
+	// repository that have the given digest as their subject.
+	// As with the referrers API, the results are only filtered
+	// as requested by opts, which may be nil, if the returned
+	// iterator says so: see [FiltersApplied].
+	Referrers(ctx context.Context, repo string, digest Digest, opts *ReferrersOptions) Iter[Descriptor]
+}
+
+// ListOptions selects the names returned by [Lister.Repositories]
+// and [Lister.Tags]. Last and N correspond to the last and n query
+// parameters of the distribution API, so a long list can be read a
+// page at a time by passing the last name of each page as Last
+// when asking for the next.
+type ListOptions struct {
+	// Last causes only names after Last to be returned.
+	Last string
+
+	// N holds the maximum number of names to return.
+	// Zero means no limit.
+	N int
+
+	// Prefix causes only names that start with Prefix to be
+	// returned. The distribution API has no equivalent, so
+	// [HTTPRegistry] asks for names after Last and stops
+	// reading pages at the first name past the prefix.
+	Prefix string
+}
+
+// SelectNames returns the names selected by opts from names,
+// which must be in lexical order. It's useful for implementing
+// [Lister]. If opts is nil, names is returned unchanged.
+func SelectNames(names []string, opts *ListOptions) []string {
+	if opts == nil {
+		return names
+	}
+	var selected []string
+	for _, name := range names {
+		if name <= opts.Last {
+			continue
+		}
+		if !strings.HasPrefix(name, opts.Prefix) {
+			if name > opts.Prefix {
+				break
+			}
+			continue
+		}
+		if opts.N > 0 && len(selected) >= opts.N {
+			break
+		}
+		selected = append(selected, name)
+	}
+	return selected
+}
+
+// ReferrersOptions holds options for [Lister.Referrers].
+type ReferrersOptions struct {
+	// ArtifactType asks for only the referrers
+	// with the given artifact type.
+	ArtifactType string
 }
 
 // BlobReader provides the contents of a given blob or manifest.
@@ -57,8 +123,36 @@ type BlobReader interface {
 	OpenRange(p0, p1 int64) io.ReadCloser
 }
 
+// BytesBlob returns a BlobReader that reads the given data,
+// which is described as having the given media type.
 func BytesBlob(data []byte, contentType string) BlobReader {
-	panic("TODO")
+	return bytesBlob{
+		desc: Descriptor{
+			MediaType: contentType,
+			Digest:    digest.FromBytes(data),
+			Size:      int64(len(data)),
+		},
+		data: data,
+	}
+}
+
+type bytesBlob struct {
+	desc Descriptor
+	data []byte
+}
+
+func (b bytesBlob) Descriptor() Descriptor {
+	return b.desc
+}
+
+func (b bytesBlob) Open() io.ReadCloser {
+	return io.NopCloser(bytes.NewReader(b.data))
+}
+
+func (b bytesBlob) OpenRange(p0, p1 int64) io.ReadCloser {
+	p0 = min(max(p0, 0), int64(len(b.data)))
+	p1 = min(max(p1, p0), int64(len(b.data)))
+	return io.NopCloser(bytes.NewReader(b.data[p0:p1]))
 }
 
 func FileBlob(f *os.File, contentType string) BlobReader {
@@ -93,14 +187,3 @@ func (c *Client) GetBlob(ctx context.Context, ref string) (BlobReader, error) {
 func (c *Client) GetManifest(ctx context.Context, ref string) (BlobReader, error) {
 	panic("TODO")
 }
-
-// Copy copies the manifest and all its references from src to dst.
-func Copy(digest Digest, dst ReadWriter, src Reader) (Descriptor, error) {
-	panic("TODO")
-}
-
-// Serve returns an HTTP handler that provides a handler for the OCI registry API
-// using r as its backing.
-func Serve(r Interface) http.Handler {
-	panic("TODO")
-}
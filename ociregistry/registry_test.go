@@ -0,0 +1,325 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var selectNamesTests = []struct {
+	testName string
+	names    []string
+	opts     *ListOptions
+	want     []string
+}{{
+	testName: "NilOptions",
+	names:    []string{"a", "b", "c"},
+	want:     []string{"a", "b", "c"},
+}, {
+	testName: "ZeroOptions",
+	names:    []string{"a", "b", "c"},
+	opts:     &ListOptions{},
+	want:     []string{"a", "b", "c"},
+}, {
+	testName: "Last",
+	names:    []string{"a", "b", "c"},
+	opts:     &ListOptions{Last: "a"},
+	want:     []string{"b", "c"},
+}, {
+	testName: "LastNotPresent",
+	names:    []string{"a", "c", "e"},
+	opts:     &ListOptions{Last: "b"},
+	want:     []string{"c", "e"},
+}, {
+	testName: "LastAfterAll",
+	names:    []string{"a", "b", "c"},
+	opts:     &ListOptions{Last: "c"},
+}, {
+	testName: "N",
+	names:    []string{"a", "b", "c"},
+	opts:     &ListOptions{N: 2},
+	want:     []string{"a", "b"},
+}, {
+	testName: "LastAndN",
+	names:    []string{"a", "b", "c", "d"},
+	opts:     &ListOptions{Last: "a", N: 2},
+	want:     []string{"b", "c"},
+}, {
+	testName: "Prefix",
+	names:    []string{"a", "v0.1.0", "v0.2.0", "v1.0.0", "w"},
+	opts:     &ListOptions{Prefix: "v0."},
+	want:     []string{"v0.1.0", "v0.2.0"},
+}, {
+	testName: "PrefixLastAndN",
+	names:    []string{"a", "v0.1.0", "v0.2.0", "v0.3.0", "v1.0.0"},
+	opts:     &ListOptions{Prefix: "v0.", Last: "v0.1.0", N: 1},
+	want:     []string{"v0.2.0"},
+}, {
+	testName: "LastBeforePrefix",
+	names:    []string{"a", "b", "v0.1.0"},
+	opts:     &ListOptions{Prefix: "v", Last: "a"},
+	want:     []string{"v0.1.0"},
+}, {
+	testName: "NoMatch",
+	names:    []string{"a", "b"},
+	opts:     &ListOptions{Prefix: "c"},
+}}
+
+func TestSelectNames(t *testing.T) {
+	for _, test := range selectNamesTests {
+		t.Run(test.testName, func(t *testing.T) {
+			got := SelectNames(test.names, test.opts)
+			if !equalStrings(got, test.want) {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemRegistryPaging(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemRegistry()
+	config := pushBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	m := pushManifest(t, r, "repo", config)
+	var want []string
+	for i := 0; i < 7; i++ {
+		tag := fmt.Sprintf("v0.%d.0", i)
+		if err := r.Tag(ctx, "repo", m.Digest, tag); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, tag)
+	}
+	if err := r.Tag(ctx, "repo", m.Digest, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	// Read the tags a page at a time, as a client
+	// of the distribution API would.
+	var got []string
+	opts := &ListOptions{N: 3, Prefix: "v"}
+	for {
+		page, err := All(r.Tags(ctx, "repo", opts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) > opts.N {
+			t.Fatalf("got %d tags in page; want at most %d", len(page), opts.N)
+		}
+		got = append(got, page...)
+		if len(page) < opts.N {
+			break
+		}
+		opts.Last = page[len(page)-1]
+	}
+	if !equalStrings(got, want) {
+		t.Errorf("got tags %q; want %q", got, want)
+	}
+
+	for _, repo := range []string{"a/x", "a/y", "b"} {
+		pushBlob(t, r, repo, "text/plain", "x")
+	}
+	repos, err := All(r.Repositories(ctx, &ListOptions{Prefix: "a/"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a/x", "a/y"}; !equalStrings(repos, want) {
+		t.Errorf("got repositories %q; want %q", repos, want)
+	}
+	if _, err := All(r.Tags(ctx, "nothing", nil)); err == nil {
+		t.Errorf("expected error listing tags of unknown repository")
+	}
+}
+
+func TestMemRegistryReferrers(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemRegistry()
+	config := pushBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	subject := pushManifest(t, r, "repo", config)
+	sbomConfig := pushBlob(t, r, "repo", "application/vnd.example.sbom", "{}")
+	// One referrer has an explicit artifact type; the
+	// other's artifact type is its config's media type.
+	sig := pushJSON(t, r, "repo", ocispec.MediaTypeImageManifest, map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageManifest,
+		"artifactType":  "application/vnd.example.sig",
+		"config":        config,
+		"layers":        []Descriptor{},
+		"subject":       subject,
+	})
+	sbom := pushJSON(t, r, "repo", ocispec.MediaTypeImageManifest, ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    sbomConfig,
+		Subject:   &subject,
+	})
+
+	tests := []struct {
+		opts        *ReferrersOptions
+		want        []Descriptor
+		wantFilters []string
+	}{{
+		want: []Descriptor{sig, sbom},
+	}, {
+		opts: &ReferrersOptions{},
+		want: []Descriptor{sig, sbom},
+	}, {
+		opts:        &ReferrersOptions{ArtifactType: "application/vnd.example.sig"},
+		want:        []Descriptor{sig},
+		wantFilters: []string{"artifactType"},
+	}, {
+		opts:        &ReferrersOptions{ArtifactType: "application/vnd.example.sbom"},
+		want:        []Descriptor{sbom},
+		wantFilters: []string{"artifactType"},
+	}, {
+		opts:        &ReferrersOptions{ArtifactType: "application/vnd.example.other"},
+		wantFilters: []string{"artifactType"},
+	}}
+	for _, test := range tests {
+		it := r.Referrers(ctx, "repo", subject.Digest, test.opts)
+		got, err := All(it)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(test.want) == 2 && test.want[0].Digest > test.want[1].Digest {
+			test.want[0], test.want[1] = test.want[1], test.want[0]
+		}
+		var gotDigests, wantDigests []Digest
+		for _, desc := range got {
+			gotDigests = append(gotDigests, desc.Digest)
+		}
+		for _, desc := range test.want {
+			wantDigests = append(wantDigests, desc.Digest)
+		}
+		if !equalDigests(gotDigests, wantDigests) {
+			t.Errorf("options %+v: got referrers %v; want %v", test.opts, gotDigests, wantDigests)
+		}
+		for _, desc := range got {
+			if desc.ArtifactType == "" {
+				t.Errorf("referrer %s has no artifact type", desc.Digest)
+			}
+		}
+		if filters := FiltersApplied(it); !equalStrings(filters, test.wantFilters) {
+			t.Errorf("options %+v: got filters %q; want %q", test.opts, filters, test.wantFilters)
+		}
+	}
+}
+
+func TestMemRegistryPushManifestRefs(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemRegistry()
+	config := pushBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	layer := pushBlob(t, r, "repo", "text/plain", "layer")
+	m := pushManifest(t, r, "repo", config, layer)
+	// Blobs in other repositories don't count.
+	other := pushBlob(t, r, "other", "text/plain", "other")
+	missing := Descriptor{
+		MediaType: "text/plain",
+		Digest:    digest.FromString("missing"),
+		Size:      7,
+	}
+	missingManifest := missing
+	missingManifest.MediaType = ocispec.MediaTypeImageManifest
+
+	tests := []struct {
+		testName string
+		manifest any
+		wantErr  error
+	}{{
+		testName: "Present",
+		manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []Descriptor{layer},
+		},
+	}, {
+		testName: "MissingSubject",
+		manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []Descriptor{},
+			Subject:   &missingManifest,
+		},
+	}, {
+		testName: "MissingConfig",
+		manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    missing,
+			Layers:    []Descriptor{layer},
+		},
+		wantErr: ErrManifestBlobUnknown,
+	}, {
+		testName: "MissingLayer",
+		manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []Descriptor{layer, other},
+		},
+		wantErr: ErrManifestBlobUnknown,
+	}, {
+		testName: "Index",
+		manifest: ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []Descriptor{m},
+		},
+	}, {
+		testName: "MissingChild",
+		manifest: ocispec.Index{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageIndex,
+			Manifests: []Descriptor{m, missingManifest},
+		},
+		wantErr: ErrManifestBlobUnknown,
+	}, {
+		testName: "LayerIsManifest",
+		manifest: ocispec.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			MediaType: ocispec.MediaTypeImageManifest,
+			Config:    config,
+			Layers:    []Descriptor{m},
+		},
+		wantErr: ErrManifestBlobUnknown,
+	}, {
+		testName: "NotJSON",
+		manifest: json.RawMessage("[1"),
+		wantErr:  ErrManifestInvalid,
+	}}
+	for _, test := range tests {
+		t.Run(test.testName, func(t *testing.T) {
+			data, ok := test.manifest.(json.RawMessage)
+			if !ok {
+				var err error
+				if data, err = json.Marshal(test.manifest); err != nil {
+					t.Fatal(err)
+				}
+			}
+			desc := Descriptor{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromBytes(data),
+				Size:      int64(len(data)),
+			}
+			_, err := r.PushManifest(ctx, "repo", BytesBlob(data, desc.MediaType), desc)
+			if test.wantErr == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("got error %v; want %v", err, test.wantErr)
+			}
+			if _, err := r.GetManifest(ctx, "repo", desc.Digest); err == nil {
+				t.Errorf("rejected manifest was stored")
+			}
+		})
+	}
+}
@@ -0,0 +1,522 @@
+package ociregistry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// maxManifestSize holds the maximum size of a manifest
+// that can be pushed to a registry served by [Serve].
+const maxManifestSize = 4 << 20
+
+var (
+	repoNamePattern = regexp.MustCompile(`^[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*(/[a-z0-9]+((\.|_|__|-+)[a-z0-9]+)*)*$`)
+	tagPattern      = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9._-]{0,127}$`)
+)
+
+// Serve returns an HTTP handler that provides a handler for the OCI registry API
+// using r as its backing. Errors are reported as described by [WriteError].
+// To check manifests before they're pushed, wrap r with [NewCheckingRegistry].
+// The listing endpoints need r to implement [Lister]; the referrers endpoint
+// sets the OCI-Filters-Applied header from [FiltersApplied].
+//
+// Blob uploads are held in memory until they're complete,
+// so the handler is best suited to tests and small registries.
+func Serve(r Interface) http.Handler {
+	return &server{
+		r:       r,
+		uploads: make(map[string]*upload),
+	}
+}
+
+type server struct {
+	r Interface
+
+	mu      sync.Mutex
+	uploads map[string]*upload
+}
+
+// upload holds the state of a blob upload session.
+// Its mutex guards buf.
+type upload struct {
+	repo string
+	mu   sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path, ok := strings.CutPrefix(req.URL.Path, "/v2/")
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if path == "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+		return
+	}
+	if path == "_catalog" {
+		s.handleCatalog(w, req)
+		return
+	}
+	elems := strings.Split(path, "/")
+	n := len(elems)
+	var (
+		repo, ref string
+		handle    func(w http.ResponseWriter, req *http.Request, repo, ref string) error
+	)
+	switch {
+	case n >= 4 && elems[n-3] == "blobs" && elems[n-2] == "uploads":
+		repo, ref, handle = strings.Join(elems[:n-3], "/"), elems[n-1], s.handleUpload
+	case n >= 3 && elems[n-2] == "blobs" && elems[n-1] == "uploads":
+		repo, handle = strings.Join(elems[:n-2], "/"), s.handleUpload
+	case n >= 3 && elems[n-2] == "tags" && elems[n-1] == "list":
+		repo, handle = strings.Join(elems[:n-2], "/"), s.handleTags
+	case n >= 3 && elems[n-2] == "manifests":
+		repo, ref, handle = strings.Join(elems[:n-2], "/"), elems[n-1], s.handleManifest
+	case n >= 3 && elems[n-2] == "blobs":
+		repo, ref, handle = strings.Join(elems[:n-2], "/"), elems[n-1], s.handleBlob
+	case n >= 3 && elems[n-2] == "referrers":
+		repo, ref, handle = strings.Join(elems[:n-2], "/"), elems[n-1], s.handleReferrers
+	default:
+		http.NotFound(w, req)
+		return
+	}
+	if !repoNamePattern.MatchString(repo) {
+		WriteError(w, fmt.Errorf("invalid repository name %q: %w", repo, ErrNameInvalid))
+		return
+	}
+	if err := handle(w, req, repo, ref); err != nil {
+		WriteError(w, err)
+	}
+}
+
+func (s *server) handleBlob(w http.ResponseWriter, req *http.Request, repo, ref string) error {
+	dig, err := parseDigest(ref)
+	if err != nil {
+		return err
+	}
+	ctx := req.Context()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		b, err := s.r.GetBlob(ctx, repo, dig)
+		if err != nil {
+			return err
+		}
+		writeContent(w, req, b, "application/octet-stream")
+		return nil
+	case http.MethodDelete:
+		if err := s.r.DeleteBlob(ctx, repo, dig); err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+	return unsupportedMethod(req)
+}
+
+func (s *server) handleManifest(w http.ResponseWriter, req *http.Request, repo, ref string) error {
+	ctx := req.Context()
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		var b BlobReader
+		var err error
+		if isDigest(ref) {
+			var dig Digest
+			if dig, err = parseDigest(ref); err != nil {
+				return err
+			}
+			b, err = s.r.GetManifest(ctx, repo, dig)
+		} else {
+			b, err = s.r.GetTag(ctx, repo, ref)
+		}
+		if err != nil {
+			return err
+		}
+		writeContent(w, req, b, "")
+		return nil
+	case http.MethodPut:
+		return s.putManifest(w, req, repo, ref)
+	case http.MethodDelete:
+		var err error
+		if isDigest(ref) {
+			var dig Digest
+			if dig, err = parseDigest(ref); err != nil {
+				return err
+			}
+			err = s.r.DeleteManifest(ctx, repo, dig)
+		} else {
+			err = s.r.DeleteTag(ctx, repo, ref)
+		}
+		if err != nil {
+			return err
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return nil
+	}
+	return unsupportedMethod(req)
+}
+
+// putManifest pushes the manifest in the body of req to repo,
+// tagging it if ref is a tag rather than a digest.
+func (s *server) putManifest(w http.ResponseWriter, req *http.Request, repo, ref string) error {
+	ctx := req.Context()
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxManifestSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxManifestSize {
+		return fmt.Errorf("manifest is more than %d bytes: %w", maxManifestSize, ErrManifestInvalid)
+	}
+	desc := Descriptor{
+		MediaType: req.Header.Get("Content-Type"),
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if desc.MediaType == "" {
+		return fmt.Errorf("no manifest media type: %w", ErrManifestInvalid)
+	}
+	tag := ""
+	if isDigest(ref) {
+		dig, err := parseDigest(ref)
+		if err != nil {
+			return err
+		}
+		if desc.Digest = dig.Algorithm().FromBytes(data); desc.Digest != dig {
+			return fmt.Errorf("manifest digest %s does not match %s: %w", desc.Digest, dig, ErrDigestInvalid)
+		}
+	} else if !tagPattern.MatchString(ref) {
+		return fmt.Errorf("invalid tag %q: %w", ref, ErrManifestInvalid)
+	} else {
+		tag = ref
+	}
+	if _, err := s.r.PushManifest(ctx, repo, BytesBlob(data, desc.MediaType), desc); err != nil {
+		return err
+	}
+	if tag != "" {
+		if err := s.r.Tag(ctx, repo, desc.Digest, tag); err != nil {
+			return err
+		}
+	}
+	var m struct {
+		Subject *Descriptor `json:"subject"`
+	}
+	if json.Unmarshal(data, &m) == nil && m.Subject != nil {
+		w.Header().Set("OCI-Subject", string(m.Subject.Digest))
+	}
+	w.Header().Set("Location", "/v2/"+repo+"/manifests/"+string(desc.Digest))
+	w.Header().Set("Docker-Content-Digest", string(desc.Digest))
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+// handleUpload implements the blob upload endpoints. The id is
+// empty when an upload is being started.
+func (s *server) handleUpload(w http.ResponseWriter, req *http.Request, repo, id string) error {
+	ctx := req.Context()
+	if id == "" {
+		if req.Method != http.MethodPost {
+			return unsupportedMethod(req)
+		}
+		query := req.URL.Query()
+		if from, mount := query.Get("from"), query.Get("mount"); from != "" && mount != "" {
+			dig, err := parseDigest(mount)
+			if err != nil {
+				return err
+			}
+			err = s.r.Mount(ctx, repo, from, dig)
+			if err == nil {
+				writeBlobCreated(w, repo, dig)
+				return nil
+			}
+			if !IsNotFound(err) {
+				return err
+			}
+			// As the spec allows, fall back to
+			// an ordinary upload if the mount fails.
+		}
+		if dig := query.Get("digest"); dig != "" {
+			// A monolithic upload.
+			up := &upload{repo: repo}
+			if _, err := io.Copy(&up.buf, req.Body); err != nil {
+				return err
+			}
+			return s.finishUpload(w, req, up, dig)
+		}
+		id, err := newUploadID()
+		if err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.uploads[id] = &upload{repo: repo}
+		s.mu.Unlock()
+		writeUploadStatus(w, repo, id, 0, http.StatusAccepted)
+		return nil
+	}
+	s.mu.Lock()
+	up, ok := s.uploads[id]
+	s.mu.Unlock()
+	if !ok || up.repo != repo {
+		return fmt.Errorf("upload %q: %w", id, ErrBlobUploadUnknown)
+	}
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	switch req.Method {
+	case http.MethodGet:
+		writeUploadStatus(w, repo, id, up.buf.Len(), http.StatusNoContent)
+		return nil
+	case http.MethodPatch:
+		if _, err := io.Copy(&up.buf, req.Body); err != nil {
+			return err
+		}
+		writeUploadStatus(w, repo, id, up.buf.Len(), http.StatusAccepted)
+		return nil
+	case http.MethodPut:
+		if _, err := io.Copy(&up.buf, req.Body); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		delete(s.uploads, id)
+		s.mu.Unlock()
+		return s.finishUpload(w, req, up, req.URL.Query().Get("digest"))
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.uploads, id)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return unsupportedMethod(req)
+}
+
+// finishUpload pushes the content of up, which
+// must have the digest dig, as a blob.
+func (s *server) finishUpload(w http.ResponseWriter, req *http.Request, up *upload, dig string) error {
+	want, err := parseDigest(dig)
+	if err != nil {
+		return err
+	}
+	data := up.buf.Bytes()
+	if got := want.Algorithm().FromBytes(data); got != want {
+		return fmt.Errorf("blob digest %s does not match %s: %w", got, want, ErrDigestInvalid)
+	}
+	desc := Descriptor{
+		MediaType: "application/octet-stream",
+		Digest:    want,
+		Size:      int64(len(data)),
+	}
+	if _, err := s.r.PushBlob(req.Context(), up.repo, BytesBlob(data, desc.MediaType), desc); err != nil {
+		return err
+	}
+	writeBlobCreated(w, up.repo, want)
+	return nil
+}
+
+func (s *server) handleCatalog(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		WriteError(w, unsupportedMethod(req))
+		return
+	}
+	l, ok := s.r.(Lister)
+	if !ok {
+		WriteError(w, fmt.Errorf("cannot list repositories: %w", ErrUnsupported))
+		return
+	}
+	opts, err := listOptions(req)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	names, err := All(l.Repositories(req.Context(), opts))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	writeNextLink(w, "/v2/_catalog", names, opts)
+	writeJSON(w, "application/json", struct {
+		Repositories []string `json:"repositories"`
+	}{
+		Repositories: nonNil(names),
+	})
+}
+
+func (s *server) handleTags(w http.ResponseWriter, req *http.Request, repo, _ string) error {
+	if req.Method != http.MethodGet {
+		return unsupportedMethod(req)
+	}
+	l, ok := s.r.(Lister)
+	if !ok {
+		return fmt.Errorf("cannot list tags: %w", ErrUnsupported)
+	}
+	opts, err := listOptions(req)
+	if err != nil {
+		return err
+	}
+	tags, err := All(l.Tags(req.Context(), repo, opts))
+	if err != nil {
+		return err
+	}
+	writeNextLink(w, "/v2/"+repo+"/tags/list", tags, opts)
+	writeJSON(w, "application/json", struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{
+		Name: repo,
+		Tags: nonNil(tags),
+	})
+	return nil
+}
+
+func (s *server) handleReferrers(w http.ResponseWriter, req *http.Request, repo, ref string) error {
+	if req.Method != http.MethodGet {
+		return unsupportedMethod(req)
+	}
+	dig, err := parseDigest(ref)
+	if err != nil {
+		return err
+	}
+	l, ok := s.r.(Lister)
+	if !ok {
+		return fmt.Errorf("cannot list referrers: %w", ErrUnsupported)
+	}
+	it := l.Referrers(req.Context(), repo, dig, &ReferrersOptions{
+		ArtifactType: req.URL.Query().Get("artifactType"),
+	})
+	defer it.Close()
+	referrers, err := All(it)
+	if err != nil {
+		return err
+	}
+	if filters := FiltersApplied(it); len(filters) > 0 {
+		w.Header().Set("OCI-Filters-Applied", strings.Join(filters, ","))
+	}
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: nonNil(referrers),
+	}
+	index.SchemaVersion = 2
+	writeJSON(w, ocispec.MediaTypeImageIndex, index)
+	return nil
+}
+
+// listOptions returns the list options
+// given by the n and last query parameters.
+func listOptions(req *http.Request) (*ListOptions, error) {
+	query := req.URL.Query()
+	opts := &ListOptions{
+		Last: query.Get("last"),
+	}
+	if s := query.Get("n"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid n parameter %q: %w", s, ErrUnsupported)
+		}
+		opts.N = n
+	}
+	return opts, nil
+}
+
+// writeNextLink writes the Link header that points to the next
+// page of names when names, a page of a list at path, is full.
+func writeNextLink(w http.ResponseWriter, path string, names []string, opts *ListOptions) {
+	if opts.N == 0 || len(names) < opts.N {
+		return
+	}
+	query := url.Values{
+		"n":    {strconv.Itoa(opts.N)},
+		"last": {names[len(names)-1]},
+	}
+	w.Header().Set("Link", fmt.Sprintf("<%s?%s>; rel=\"next\"", path, query.Encode()))
+}
+
+// writeContent writes the content of b in response to req,
+// which is a GET or HEAD request. If b's descriptor has no
+// media type, defaultMediaType is used.
+func writeContent(w http.ResponseWriter, req *http.Request, b BlobReader, defaultMediaType string) {
+	desc := b.Descriptor()
+	mediaType := desc.MediaType
+	if mediaType == "" {
+		mediaType = defaultMediaType
+	}
+	if mediaType != "" {
+		w.Header().Set("Content-Type", mediaType)
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(desc.Size, 10))
+	w.Header().Set("Docker-Content-Digest", string(desc.Digest))
+	if req.Method == http.MethodHead {
+		return
+	}
+	rc := b.Open()
+	defer rc.Close()
+	io.Copy(w, rc)
+}
+
+func writeJSON(w http.ResponseWriter, contentType string, x any) {
+	data, err := json.Marshal(x)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+func writeBlobCreated(w http.ResponseWriter, repo string, dig Digest) {
+	w.Header().Set("Location", "/v2/"+repo+"/blobs/"+string(dig))
+	w.Header().Set("Docker-Content-Digest", string(dig))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func writeUploadStatus(w http.ResponseWriter, repo, id string, size int, status int) {
+	w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", max(size-1, 0)))
+	w.WriteHeader(status)
+}
+
+func newUploadID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// isDigest reports whether ref, a manifest reference,
+// is a digest rather than a tag. Tags can't contain colons.
+func isDigest(ref string) bool {
+	return strings.Contains(ref, ":")
+}
+
+func parseDigest(s string) (Digest, error) {
+	dig, err := digest.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest %q: %w", s, ErrDigestInvalid)
+	}
+	return dig, nil
+}
+
+func unsupportedMethod(req *http.Request) error {
+	return fmt.Errorf("method %s not supported for %s: %w", req.Method, req.URL.Path, ErrUnsupported)
+}
+
+// nonNil returns xs, or an empty slice if xs is nil,
+// so that it's encoded as an empty JSON array.
+func nonNil[T any](xs []T) []T {
+	if xs == nil {
+		return []T{}
+	}
+	return xs
+}
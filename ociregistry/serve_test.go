@@ -0,0 +1,369 @@
+package ociregistry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// serveRequest describes a request to a registry served
+// by Serve and the response that's expected.
+type serveRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+
+	wantStatus int
+	// wantCode holds the expected error code, if any.
+	wantCode string
+	// wantBody holds the expected body, if not empty.
+	wantBody string
+	// wantHeader holds headers that must be in the response.
+	wantHeader map[string]string
+}
+
+func TestServe(t *testing.T) {
+	config := "{}"
+	configDigest := digest.FromString(config)
+	layer := "hello"
+	layerDigest := digest.FromString(layer)
+	manifest := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":%q,"size":2},"layers":[{"mediaType":"text/plain","digest":%q,"size":5}]}`, ocispec.MediaTypeImageManifest, configDigest, layerDigest)
+	manifestDigest := digest.FromString(manifest)
+	sig := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"artifactType":"application/vnd.example.sig","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":%q,"size":2},"layers":[],"subject":{"mediaType":%q,"digest":%q,"size":%d}}`, ocispec.MediaTypeImageManifest, configDigest, ocispec.MediaTypeImageManifest, manifestDigest, len(manifest))
+	sigDigest := digest.FromString(sig)
+
+	srv := httptest.NewServer(Serve(NewMemRegistry()))
+	defer srv.Close()
+
+	// Push the config monolithically.
+	do(t, srv, serveRequest{
+		method:     "POST",
+		path:       "/v2/a/b/blobs/uploads/?digest=" + string(configDigest),
+		body:       config,
+		wantStatus: http.StatusCreated,
+		wantHeader: map[string]string{
+			"Location":              "/v2/a/b/blobs/" + string(configDigest),
+			"Docker-Content-Digest": string(configDigest),
+		},
+	})
+	// Push the layer in two chunks.
+	resp := do(t, srv, serveRequest{
+		method:     "POST",
+		path:       "/v2/a/b/blobs/uploads/",
+		wantStatus: http.StatusAccepted,
+	})
+	upload := resp.Header.Get("Location")
+	do(t, srv, serveRequest{
+		method:     "PATCH",
+		path:       upload,
+		body:       layer[:2],
+		wantStatus: http.StatusAccepted,
+		wantHeader: map[string]string{
+			"Range": "0-1",
+		},
+	})
+	do(t, srv, serveRequest{
+		method:     "PUT",
+		path:       upload + "?digest=" + string(layerDigest),
+		body:       layer[2:],
+		wantStatus: http.StatusCreated,
+	})
+
+	for _, req := range []serveRequest{{
+		method:     "GET",
+		path:       "/v2/",
+		wantStatus: http.StatusOK,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/blobs/" + string(layerDigest),
+		wantStatus: http.StatusOK,
+		wantBody:   layer,
+	}, {
+		method:     "HEAD",
+		path:       "/v2/a/b/blobs/" + string(layerDigest),
+		wantStatus: http.StatusOK,
+		wantHeader: map[string]string{
+			"Content-Length":        "5",
+			"Docker-Content-Digest": string(layerDigest),
+		},
+	}, {
+		method:     "PUT",
+		path:       upload + "?digest=" + string(layerDigest),
+		wantStatus: http.StatusNotFound,
+		wantCode:   "BLOB_UPLOAD_UNKNOWN",
+	}, {
+		method:     "POST",
+		path:       "/v2/a/b/blobs/uploads/?digest=" + string(layerDigest),
+		body:       "wrong",
+		wantStatus: http.StatusBadRequest,
+		wantCode:   "DIGEST_INVALID",
+	}, {
+		method:      "PUT",
+		path:        "/v2/a/b/manifests/v1",
+		contentType: ocispec.MediaTypeImageManifest,
+		body:        manifest,
+		wantStatus:  http.StatusCreated,
+		wantHeader: map[string]string{
+			"Location":              "/v2/a/b/manifests/" + string(manifestDigest),
+			"Docker-Content-Digest": string(manifestDigest),
+		},
+	}, {
+		method:      "PUT",
+		path:        "/v2/a/b/manifests/" + string(sigDigest),
+		contentType: ocispec.MediaTypeImageManifest,
+		body:        sig,
+		wantStatus:  http.StatusCreated,
+		wantHeader: map[string]string{
+			"OCI-Subject": string(manifestDigest),
+		},
+	}, {
+		method:      "PUT",
+		path:        "/v2/a/b/manifests/" + string(manifestDigest),
+		contentType: ocispec.MediaTypeImageManifest,
+		body:        sig,
+		wantStatus:  http.StatusBadRequest,
+		wantCode:    "DIGEST_INVALID",
+	}, {
+		method:      "PUT",
+		path:        "/v2/a/b/manifests/v2",
+		contentType: ocispec.MediaTypeImageManifest,
+		body:        strings.Replace(manifest, string(layerDigest), string(digest.FromString("missing")), 1),
+		wantStatus:  http.StatusNotFound,
+		wantCode:    "MANIFEST_BLOB_UNKNOWN",
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/manifests/v1",
+		wantStatus: http.StatusOK,
+		wantBody:   manifest,
+		wantHeader: map[string]string{
+			"Content-Type":          ocispec.MediaTypeImageManifest,
+			"Docker-Content-Digest": string(manifestDigest),
+		},
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/manifests/" + string(manifestDigest),
+		wantStatus: http.StatusOK,
+		wantBody:   manifest,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/manifests/nothing",
+		wantStatus: http.StatusNotFound,
+		wantCode:   "MANIFEST_UNKNOWN",
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/blobs/sha256:bad",
+		wantStatus: http.StatusBadRequest,
+		wantCode:   "DIGEST_INVALID",
+	}, {
+		method:     "GET",
+		path:       "/v2/other/blobs/" + string(layerDigest),
+		wantStatus: http.StatusNotFound,
+		wantCode:   "NAME_UNKNOWN",
+	}, {
+		method:     "GET",
+		path:       "/v2/Upper/tags/list",
+		wantStatus: http.StatusBadRequest,
+		wantCode:   "NAME_INVALID",
+	}, {
+		method:     "POST",
+		path:       "/v2/c/blobs/uploads/?mount=" + string(layerDigest) + "&from=a/b",
+		wantStatus: http.StatusCreated,
+	}, {
+		method:     "GET",
+		path:       "/v2/c/blobs/" + string(layerDigest),
+		wantStatus: http.StatusOK,
+		wantBody:   layer,
+	}, {
+		method:     "GET",
+		path:       "/v2/_catalog",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"repositories":["a/b","c"]}`,
+	}, {
+		method:     "GET",
+		path:       "/v2/_catalog?n=1",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"repositories":["a/b"]}`,
+		wantHeader: map[string]string{
+			"Link": `</v2/_catalog?last=a%2Fb&n=1>; rel="next"`,
+		},
+	}, {
+		method:     "GET",
+		path:       "/v2/_catalog?n=1&last=a/b",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"repositories":["c"]}`,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/tags/list",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"name":"a/b","tags":["v1"]}`,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/referrers/" + string(manifestDigest),
+		wantStatus: http.StatusOK,
+		wantBody:   referrersBody(sigDigest, len(sig)),
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/referrers/" + string(manifestDigest) + "?artifactType=application/vnd.example.sig",
+		wantStatus: http.StatusOK,
+		wantBody:   referrersBody(sigDigest, len(sig)),
+		wantHeader: map[string]string{
+			"OCI-Filters-Applied": "artifactType",
+		},
+	}, {
+		method:     "DELETE",
+		path:       "/v2/a/b/manifests/v1",
+		wantStatus: http.StatusAccepted,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/tags/list",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"name":"a/b","tags":[]}`,
+	}, {
+		method:     "DELETE",
+		path:       "/v2/a/b/manifests/" + string(manifestDigest),
+		wantStatus: http.StatusAccepted,
+	}, {
+		method:     "GET",
+		path:       "/v2/a/b/manifests/" + string(manifestDigest),
+		wantStatus: http.StatusNotFound,
+		wantCode:   "MANIFEST_UNKNOWN",
+	}, {
+		method:     "DELETE",
+		path:       "/v2/c/blobs/" + string(layerDigest),
+		wantStatus: http.StatusAccepted,
+	}, {
+		method:     "POST",
+		path:       "/v2/a/b/manifests/v1",
+		wantStatus: http.StatusMethodNotAllowed,
+		wantCode:   "UNSUPPORTED",
+	}, {
+		method:     "GET",
+		path:       "/other",
+		wantStatus: http.StatusNotFound,
+	}} {
+		do(t, srv, req)
+	}
+}
+
+func TestServeCheckingRegistry(t *testing.T) {
+	r := NewCheckingRegistry(NewMemRegistry(), func(ctx context.Context, r Reader, repo string, desc Descriptor, data []byte) error {
+		if strings.Contains(string(data), "forbidden") {
+			return fmt.Errorf("manifest mentions forbidden things")
+		}
+		return nil
+	})
+	srv := httptest.NewServer(Serve(r))
+	defer srv.Close()
+
+	resp := do(t, srv, serveRequest{
+		method:      "PUT",
+		path:        "/v2/repo/manifests/latest",
+		contentType: ocispec.MediaTypeImageIndex,
+		body:        `{"schemaVersion":2,"manifests":[],"annotations":{"a":"forbidden"}}`,
+		wantStatus:  http.StatusBadRequest,
+		wantCode:    "MANIFEST_INVALID",
+	})
+	if msg := errorMessage(t, resp); !strings.Contains(msg, "manifest mentions forbidden things") {
+		t.Errorf("error message %q does not explain the problem", msg)
+	}
+	do(t, srv, serveRequest{
+		method:      "PUT",
+		path:        "/v2/repo/manifests/latest",
+		contentType: ocispec.MediaTypeImageIndex,
+		body:        `{"schemaVersion":2,"manifests":[]}`,
+		wantStatus:  http.StatusCreated,
+	})
+	// The checking registry passes listing through.
+	do(t, srv, serveRequest{
+		method:     "GET",
+		path:       "/v2/repo/tags/list",
+		wantStatus: http.StatusOK,
+		wantBody:   `{"name":"repo","tags":["latest"]}`,
+	})
+}
+
+func TestServeNoLister(t *testing.T) {
+	// Hide MemRegistry's Lister methods.
+	r := struct{ Interface }{NewMemRegistry()}
+	srv := httptest.NewServer(Serve(r))
+	defer srv.Close()
+	do(t, srv, serveRequest{
+		method:     "GET",
+		path:       "/v2/repo/tags/list",
+		wantStatus: http.StatusMethodNotAllowed,
+		wantCode:   "UNSUPPORTED",
+	})
+}
+
+// do makes the given request to srv and checks the response,
+// which is returned with its body read and stored in
+// the returned response's Body field.
+func do(t *testing.T, srv *httptest.Server, sr serveRequest) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(sr.method, srv.URL+sr.path, strings.NewReader(sr.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sr.contentType != "" {
+		req.Header.Set("Content-Type", sr.contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body = io.NopCloser(strings.NewReader(string(body)))
+	if resp.StatusCode != sr.wantStatus {
+		t.Fatalf("%s %s: got status %d; want %d; body %q", sr.method, sr.path, resp.StatusCode, sr.wantStatus, body)
+	}
+	if sr.wantCode != "" {
+		var e struct {
+			Errors []struct {
+				Code string `json:"code"`
+			} `json:"errors"`
+		}
+		if err := json.Unmarshal(body, &e); err != nil || len(e.Errors) != 1 || e.Errors[0].Code != sr.wantCode {
+			t.Errorf("%s %s: got body %q; want error code %s", sr.method, sr.path, body, sr.wantCode)
+		}
+	}
+	if sr.wantBody != "" && string(body) != sr.wantBody {
+		t.Errorf("%s %s: got body %q; want %q", sr.method, sr.path, body, sr.wantBody)
+	}
+	for name, want := range sr.wantHeader {
+		if got := resp.Header.Get(name); got != want {
+			t.Errorf("%s %s: got %s header %q; want %q", sr.method, sr.path, name, got, want)
+		}
+	}
+	return resp
+}
+
+// errorMessage returns the message of the
+// error in the body of resp.
+func errorMessage(t *testing.T, resp *http.Response) string {
+	var e struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || len(e.Errors) != 1 {
+		t.Fatalf("invalid error response: %v", err)
+	}
+	return e.Errors[0].Message
+}
+
+func referrersBody(dig Digest, size int) string {
+	return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[{"mediaType":%q,"digest":%q,"size":%d,"artifactType":"application/vnd.example.sig"}]}`, ocispec.MediaTypeImageIndex, ocispec.MediaTypeImageManifest, dig, size)
+}
@@ -1,10 +1,54 @@
 package oras
 
+// #repoBlob pushes a blob with the given source to repo,
+// filling in the digest and size of desc.
+// The blob is not pushed if it's already present in repo.
+//
+// For JSON media types, source holds the JSON value itself.
+// For application/zip, source may hold a map from file names
+// to file contents, unless it has a single file named after one of
+// the kinds of source below. Otherwise, source is either a string,
+// used as is, or one of:
+//
+//	{base64: string}: content encoded as base64.
+//	{file: string}: the content of a file, relative to the CUE package
+//	    directory. The file must be inside that directory; it may be
+//	    a symbolic link only to another file inside the directory.
+//	{tar: [name]: source}: a tar archive of the given files.
+//	{tarGzip: [name]: source}: a gzip-compressed tar archive of the given files.
+//	{zip: [name]: source}: a zip archive of the given files.
+//	{zstd: source}: content in a zstd frame. The frame holds only raw
+//	    blocks: any zstd decoder can read it, but the content is
+//	    stored, not compressed, so it's slightly larger than the
+//	    content itself.
+//	{lf: source}: content with CRLF line endings replaced by LF.
+//
+// Files in tar archives have mode 0o644 unless a mode is given
+// alongside their source, as in {mode: 0o755, file: "tool"}.
+//
+// Archives are reproducible: files are in lexical order, with
+// fixed modification times and ownership. The exception is gzip
+// compression, whose output can change between Go releases, so the
+// digest of a tarGzip or zip source may change when oras-apply is
+// rebuilt; use tar where digests must never change. Files in zip
+// archives are deflated at the best compression level, with no
+// directory entries or extra fields. File names in zip archives must follow the rules
+// for files in CUE module zips: they must be valid module file
+// paths, no two may differ only in case, and no file may have
+// the same name as a directory.
 #repoBlob: {
 	_oras:   "blob"
 	repo!:   string
 	desc!:   #descriptor
 	source!: _
+
+	// mountFrom holds other repositories in the same registry
+	// that might already contain the blob. If the registry
+	// supports it, the blob is mounted from one of these
+	// rather than being uploaded again. Repositories that
+	// the blob has been pushed to earlier in the same run
+	// are always tried.
+	mountFrom?: [... string]
 }
 
 #repoTag: {
@@ -15,6 +59,9 @@ package oras
 	desc!: #descriptor
 }
 
+// #repoManifest pushes a manifest. If the manifest has a subject,
+// it is also registered as a referrer of that subject, using the referrers
+// API if the registry supports it, or the referrers tag schema otherwise.
 #repoManifest: {
 	_oras:     "manifest"
 	repo!:     string
@@ -24,7 +71,158 @@ package oras
 	desc?: #descriptor
 }
 
-#repoDump: {
-	_oras: "dump"
-	...
+// #repoIndex pushes an image index that refers to all
+// the given manifests. Apart from repo and desc, its fields
+// are those of the index itself, as defined by #imageIndex,
+// with schemaVersion and mediaType filled in. The desc field
+// is filled in with the descriptor of the resulting index.
+#repoIndex: {
+	#imageIndex
+	_oras: "index"
+	repo!: string
+
+	schemaVersion: 2
+	mediaType:     "application/vnd.oci.image.index.v1+json"
+
+	// manifests holds the descriptors of the manifests
+	// in the index. Each entry should usually have either
+	// a platform or some annotations so that clients
+	// can choose between them.
+	manifests!: _
+
+	desc?: #descriptor
+}
+
+// #repoReferrers lists all the manifests in repo that have
+// subject as their subject, filling in referrers with their
+// descriptors. If artifactType is specified, only referrers
+// with that artifact type are included.
+//
+// Note that the query is only ordered after tasks that it
+// depends on, so to see a referrer pushed by the same
+// configuration, take the subject from that referrer's manifest
+// (for example subject: signature.manifest.subject).
+#repoReferrers: {
+	_oras:         "referrers"
+	repo!:         string
+	subject!:      #descriptor
+	artifactType?: string
+	referrers?: [... #descriptor]
+}
+
+// #resolve resolves a reference in repo, filling in desc
+// with the descriptor of the manifest it refers to.
+// The reference may be a tag or a digest.
+#resolve: {
+	_oras:      "resolve"
+	repo!:      string
+	reference!: string
+	desc?:      #descriptor
+}
+
+// #fetchManifest fetches the manifest with the given
+// reference from repo, filling in its descriptor and its
+// decoded content.
+#fetchManifest: {
+	_oras:      "fetchManifest"
+	repo!:      string
+	reference!: string
+	desc?:      #descriptor
+	manifest?:  _
+}
+
+// #fetchBlob fetches the blob with the given descriptor
+// from repo, filling in content. Content with a JSON media type
+// is decoded as a CUE value; other valid UTF-8 content is
+// decoded as a string and anything else as bytes.
+#fetchBlob: {
+	_oras:    "fetchBlob"
+	repo!:    string
+	desc!:    #descriptor
+	content?: _
+}
+
+// #listTags fills in tags with all the tags in repo,
+// in lexical order.
+#listTags: {
+	_oras: "listTags"
+	repo!: string
+	tags?: [... string]
+}
+
+// #copy copies the manifest with the given reference
+// in from.repo to to.repo, along with all the content it refers to,
+// filling in desc with its descriptor. If to.tag is specified,
+// the copied manifest is tagged with it.
+//
+// When includeReferrers is true, all referrers of the copied manifests
+// (for example signatures or SBOMs) are copied too.
+//
+// If from.registry is specified, the manifest is copied from the
+// registry with that host name rather than from the registry that
+// the configuration is applied to.
+#copy: {
+	_oras: "copy"
+	from!: {
+		registry?:  string
+		repo!:      string
+		reference!: string
+	}
+	to!: {
+		repo!: string
+		tag?:  string
+	}
+	includeReferrers: *false | bool
+	desc?:            #descriptor
+}
+
+// #deleteTag removes the tag with the given name from repo.
+// The manifest that it refers to is not deleted.
+//
+// Deletion must be explicitly enabled (for example with
+// oras-apply -allow-delete).
+#deleteTag: {
+	_oras: "deleteTag"
+	repo!: string
+	name!: string
+}
+
+// #deleteManifest deletes the manifest with the given
+// descriptor from repo, along with any tags that refer to it.
+//
+// Deletion must be explicitly enabled (for example with
+// oras-apply -allow-delete).
+#deleteManifest: {
+	_oras: "deleteManifest"
+	repo!: string
+	desc!: #descriptor
+}
+
+// #output reports value once all the tasks it depends on have
+// completed, so that a pipeline can capture results such as
+// the digest of a manifest that it has just pushed.
+//
+// The value is written according to to:
+//
+//	"stdout" writes it to standard output;
+//	"file" writes it to file, relative to the CUE package directory;
+//	"results" records it under name in the results
+//	document (see oras-apply -results).
+//
+// When writing to stdout or a file, format determines how the
+// value is rendered: "text" writes a string followed by a newline
+// and "json" writes the value as JSON. By default, strings are
+// written as text and other values as JSON.
+#output: {
+	_oras:   "output"
+	name?:   string
+	value!:  _
+	to:      *"stdout" | "file" | "results"
+	format?: "text" | "json"
+	if to == "file" {
+		file!: string
+	}
+	if to == "results" {
+		name!: string
+	}
 }
@@ -0,0 +1,98 @@
+package orasflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+)
+
+// encodeJSON returns the encoding of the given JSON content
+// that's pushed to the registry: canonical JSON, unless
+// Options.RawJSON is set.
+func (a *applier) encodeJSON(data []byte) ([]byte, error) {
+	if a.opts.RawJSON {
+		return data, nil
+	}
+	return canonicalJSON(data)
+}
+
+// canonicalJSON returns the canonical encoding of the given JSON:
+// object keys are sorted, there's no insignificant white space,
+// strings are escaped consistently (only where JSON requires it,
+// plus U+2028 and U+2029) and numbers are written in their shortest
+// form, as in RFC 8785 (JCS), so 1.0, 1 and 1e0 are all written
+// as 1, and 1e2 as 100. Semantically equal JSON values always
+// have the same canonical encoding.
+//
+// Like RFC 8785, numbers are taken to be IEEE 754 doubles, but
+// integers that a double can't represent exactly are an error
+// rather than being silently rounded. Unlike RFC 8785, keys are
+// sorted by their UTF-8 encoding rather than by UTF-16 code units,
+// which only differs for keys outside the Basic Multilingual Plane.
+func canonicalJSON(data []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var x any
+	if err := dec.Decode(&x); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %v", err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("invalid JSON: extra data after value")
+	}
+	x, err := canonicalNumbers(x)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	// Maps are always encoded with sorted keys, and float64
+	// values in the shortest form that round-trips, using the
+	// same rules as ECMAScript, which RFC 8785 also uses.
+	if err := enc.Encode(x); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// canonicalNumbers returns x, as decoded with json.Decoder.UseNumber,
+// with every number converted to a float64. Integers must
+// be represented exactly.
+func canonicalNumbers(x any) (any, error) {
+	switch x := x.(type) {
+	case json.Number:
+		f, err := strconv.ParseFloat(string(x), 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot encode number %s as canonical JSON: %v", x, err)
+		}
+		if i, ok := new(big.Int).SetString(string(x), 10); ok {
+			if fi, _ := big.NewFloat(f).Int(nil); fi.Cmp(i) != 0 {
+				return nil, fmt.Errorf("cannot encode number %s as canonical JSON: integer too large to represent exactly", x)
+			}
+		}
+		if f == 0 {
+			// Write -0 as 0, as RFC 8785 requires.
+			f = 0
+		}
+		return f, nil
+	case map[string]any:
+		for k, e := range x {
+			e, err := canonicalNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+			x[k] = e
+		}
+	case []any:
+		for i, e := range x {
+			e, err := canonicalNumbers(e)
+			if err != nil {
+				return nil, err
+			}
+			x[i] = e
+		}
+	}
+	return x, nil
+}
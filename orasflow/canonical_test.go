@@ -0,0 +1,109 @@
+package orasflow
+
+import (
+	"strings"
+	"testing"
+)
+
+var canonicalJSONTests = []struct {
+	testName string
+	data     string
+	want     string
+	wantErr  string
+}{{
+	testName: "Empty",
+	data:     `{}`,
+	want:     `{}`,
+}, {
+	testName: "KeyOrder",
+	data:     `{"b": 1, "a": {"d": [], "c": null}}`,
+	want:     `{"a":{"c":null,"d":[]},"b":1}`,
+}, {
+	testName: "WhiteSpace",
+	data:     " [ 1 ,\n\t2 ] ",
+	want:     `[1,2]`,
+}, {
+	testName: "Strings",
+	data:     `["<&>", "\u00e9", "\u2028", "\t\"\\", "\u0001", "\/"]`,
+	want:     `["<&>","é","\u2028","\t\"\\","\u0001","/"]`,
+}, {
+	testName: "IntegerForms",
+	data:     `[1, 1.0, 1e0, 1E+0, 10e-1, 100, 1e2, 1.00e2, -0, -0.0, 0.0]`,
+	want:     `[1,1,1,1,1,100,100,100,0,0,0]`,
+}, {
+	testName: "Fractions",
+	data:     `[0.1, 1.50, 1e-7, 0.000001, 123.456e1]`,
+	want:     `[0.1,1.5,1e-7,0.000001,1234.56]`,
+}, {
+	testName: "LargeNumbers",
+	data:     `[1e20, 1e21, 1.5e300, 9007199254740992, -9007199254740992]`,
+	want:     `[100000000000000000000,1e+21,1.5e+300,9007199254740992,-9007199254740992]`,
+}, {
+	testName: "Descriptor",
+	data: `{
+		"mediaType": "application/vnd.oci.image.manifest.v1+json",
+		"size": 417,
+		"digest": "sha256:db2686ac545f889244fd8118cb98ece9666242645feac14b65902c6bb95e6cf5"
+	}`,
+	want: `{"digest":"sha256:db2686ac545f889244fd8118cb98ece9666242645feac14b65902c6bb95e6cf5","mediaType":"application/vnd.oci.image.manifest.v1+json","size":417}`,
+}, {
+	testName: "InexactInteger",
+	data:     `{"size": 9007199254740993}`,
+	wantErr:  "cannot encode number 9007199254740993 as canonical JSON: integer too large to represent exactly",
+}, {
+	testName: "HugeInteger",
+	data:     `[-100000000000000000000000000000]`,
+	wantErr:  "integer too large to represent exactly",
+}, {
+	testName: "OutOfRange",
+	data:     `[1e400]`,
+	wantErr:  "cannot encode number 1e400 as canonical JSON",
+}, {
+	testName: "Invalid",
+	data:     `{"a":}`,
+	wantErr:  "invalid JSON",
+}, {
+	testName: "ExtraData",
+	data:     `{} {}`,
+	wantErr:  "invalid JSON: extra data after value",
+}}
+
+func TestCanonicalJSON(t *testing.T) {
+	for _, test := range canonicalJSONTests {
+		t.Run(test.testName, func(t *testing.T) {
+			got, err := canonicalJSON([]byte(test.data))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s; want %s", got, test.want)
+			}
+			// Canonical JSON must be a fixed point.
+			again, err := canonicalJSON(got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(again) != string(got) {
+				t.Errorf("canonical form is not stable: %s became %s", got, again)
+			}
+		})
+	}
+}
+
+func TestRawJSON(t *testing.T) {
+	a := &applier{opts: &Options{RawJSON: true}}
+	data := `{"b": 1.0, "a": 1e2}`
+	got, err := a.encodeJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != data {
+		t.Errorf("got %s; want %s unchanged", got, data)
+	}
+}
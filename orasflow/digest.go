@@ -0,0 +1,222 @@
+package orasflow
+
+import (
+	"encoding/json"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/tools/flow"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	descDigestPath = cue.MakePath(cue.Str("desc"), cue.Str("digest"))
+	descSizePath   = cue.MakePath(cue.Str("desc"), cue.Str("size"))
+	manifestPath   = cue.MakePath(cue.Str("manifest"))
+)
+
+// FillDigests returns v with the descriptors of blob, manifest and index
+// tasks filled in wherever they can be computed from v alone, without
+// running any tasks or talking to a registry. This makes the digests
+// available to the rest of the configuration, so that, for example,
+// manifests that refer to blobs can be fully evaluated.
+//
+// Tasks whose content depends on the results of other tasks,
+// such as fetched content, are left alone: Apply fills in their
+// descriptors when they run. If opts is nil, default options are used.
+//
+// [Apply] calls FillDigests before running any tasks.
+func FillDigests(v cue.Value, opts *Options) cue.Value {
+	if opts == nil {
+		opts = &Options{}
+	}
+	a := &applier{
+		opts: opts,
+	}
+	return a.fillDigests(v)
+}
+
+func (a *applier) fillDigests(v cue.Value) cue.Value {
+	// Filling in one descriptor can make others computable, so
+	// fill them in the dependency order found by tools/flow:
+	// each task's dependencies are filled in before it is.
+	ctl := flow.New(&flow.Config{}, v, func(t cue.Value) (flow.Runner, error) {
+		if _, err := t.LookupPath(orasField).String(); err != nil {
+			return nil, nil
+		}
+		return noopRunner, nil
+	})
+	for _, task := range sortTasks(ctl.Tasks()) {
+		t := v.LookupPath(task.Path())
+		kind, _ := t.LookupPath(orasField).String()
+		switch kind {
+		case "blob", "manifest", "index":
+		default:
+			continue
+		}
+		if digestKnown(t) {
+			continue
+		}
+		desc, ok := a.taskDesc(t, kind)
+		if !ok {
+			continue
+		}
+		v = v.FillPath(cue.MakePath(append(task.Path().Selectors(), cue.Str("desc"))...), desc)
+	}
+	return v
+}
+
+// noopRunner is the runner used to find tasks
+// and their dependencies without running them.
+var noopRunner = flow.RunnerFunc(func(t *flow.Task) error {
+	return nil
+})
+
+// sortTasks returns the given tasks sorted so that each task
+// comes after all the tasks that it depends on.
+func sortTasks(tasks []*flow.Task) []*flow.Task {
+	sorted := make([]*flow.Task, 0, len(tasks))
+	visited := make(map[*flow.Task]bool)
+	var visit func(t *flow.Task)
+	visit = func(t *flow.Task) {
+		if visited[t] {
+			return
+		}
+		// Marking the task before visiting its dependencies
+		// means that a cycle, which flow reports as an
+		// error when the tasks run, can't loop forever.
+		visited[t] = true
+		for _, dep := range t.Dependencies() {
+			visit(dep)
+		}
+		sorted = append(sorted, t)
+	}
+	for _, t := range tasks {
+		visit(t)
+	}
+	return sorted
+}
+
+// taskDesc returns the parts of the given task's descriptor that are
+// determined by its content, or false if they can't yet be computed.
+func (a *applier) taskDesc(t cue.Value, kind string) (any, bool) {
+	switch kind {
+	case "blob":
+		var p blobPush
+		if err := t.Decode(&p); err != nil {
+			return nil, false
+		}
+		if isJSON(p.Desc.MediaType) && !descriptorsComplete(p.Source, true) {
+			return nil, false
+		}
+		data, err := a.blobContent(p.Desc.MediaType, p.Source)
+		if err != nil {
+			return nil, false
+		}
+		return map[string]any{
+			"digest": digest.FromBytes(data),
+			"size":   len(data),
+		}, true
+	case "manifest":
+		data, err := t.LookupPath(manifestPath).MarshalJSON()
+		if err != nil || !descriptorsComplete(data, false) {
+			return nil, false
+		}
+		data, err = a.encodeJSON(data)
+		if err != nil {
+			return nil, false
+		}
+		desc, err := manifestDesc(data)
+		if err != nil {
+			return nil, false
+		}
+		return map[string]any{
+			"mediaType": desc.MediaType,
+			"digest":    desc.Digest,
+			"size":      desc.Size,
+		}, true
+	case "index":
+		var p indexPush
+		if err := t.Decode(&p); err != nil {
+			return nil, false
+		}
+		desc, data, err := a.indexContent(p)
+		if err != nil || !descriptorsComplete(data, false) {
+			return nil, false
+		}
+		return desc, true
+	}
+	return nil, false
+}
+
+// descriptorsComplete reports whether every descriptor in the
+// given JSON has a digest and size. Any object with a mediaType
+// field is taken to be a descriptor, except the top level object
+// itself when includeTop is false.
+//
+// Descriptors of content pushed by other tasks have no digest or size
+// until that content's digest is known, but they're still valid JSON
+// because those fields are optional in #descriptor, so content that
+// refers to them isn't final until this returns true.
+func descriptorsComplete(data []byte, includeTop bool) bool {
+	var x any
+	if err := json.Unmarshal(data, &x); err != nil {
+		return false
+	}
+	var complete func(x any, top bool) bool
+	complete = func(x any, top bool) bool {
+		switch x := x.(type) {
+		case map[string]any:
+			if _, ok := x["mediaType"]; ok && (!top || includeTop) {
+				if x["digest"] == nil || x["size"] == nil {
+					return false
+				}
+			}
+			for _, e := range x {
+				if !complete(e, false) {
+					return false
+				}
+			}
+		case []any:
+			for _, e := range x {
+				if !complete(e, false) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return complete(x, true)
+}
+
+// digestKnown reports whether the task's descriptor
+// already has a concrete digest and size.
+func digestKnown(t cue.Value) bool {
+	return t.LookupPath(descDigestPath).IsConcrete() && t.LookupPath(descSizePath).IsConcrete()
+}
+
+// walkTasks calls f for each task in v, in the same
+// way that tools/flow finds them.
+func walkTasks(v cue.Value, f func(t cue.Value, kind string)) {
+	if kind, err := v.LookupPath(orasField).String(); err == nil {
+		f(v, kind)
+		return
+	}
+	switch v.IncompleteKind() {
+	case cue.StructKind:
+		iter, err := v.Fields()
+		if err != nil {
+			return
+		}
+		for iter.Next() {
+			walkTasks(iter.Value(), f)
+		}
+	case cue.ListKind:
+		iter, err := v.List()
+		if err != nil {
+			return
+		}
+		for iter.Next() {
+			walkTasks(iter.Value(), f)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package orasflow
+
+import (
+	"testing"
+
+	"cuelang.org/go/cue"
+)
+
+// chainConfig holds an index that refers to a manifest that refers
+// to a blob, declared in the opposite order to their dependencies,
+// and a tag of the index.
+const chainConfig = `
+package test
+
+import "github.com/cue-exp/oras"
+
+a: oras.#repoTag & {
+	repo: "chain"
+	name: "latest"
+	desc: b.desc
+}
+b: oras.#repoIndex & {
+	repo: "chain"
+	manifests: [c.desc]
+}
+c: oras.#repoManifest & {
+	repo: "chain"
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-chain"
+		config:       d.desc
+		layers: [e.desc]
+	}
+}
+d: oras.#repoBlob & {
+	repo:   "chain"
+	desc:   oras.scratchConfig
+	source: {}
+}
+e: oras.#repoBlob & {
+	repo: "chain"
+	desc: mediaType: "text/plain"
+	source: "hello"
+}
+`
+
+func TestFillDigests(t *testing.T) {
+	v, dir := loadConfig(t, "testdata/digest", chainConfig)
+	events := applyEvents(t, v, dir)
+	v = FillDigests(v, &Options{Dir: dir})
+	for _, name := range []string{"b", "c", "d", "e"} {
+		task := v.LookupPath(cue.ParsePath(name))
+		if !digestKnown(task) {
+			t.Errorf("%s: digest not filled in", name)
+			continue
+		}
+		got, _ := task.LookupPath(descDigestPath).String()
+		if want := string(events[name].Digest); got != want {
+			t.Errorf("%s: got digest %s; want %s", name, got, want)
+		}
+	}
+	// The tag's descriptor is the index's.
+	got, _ := v.LookupPath(cue.ParsePath("a.desc.digest")).String()
+	if want := string(events["b"].Digest); got != want {
+		t.Errorf("tag has digest %s; want %s", got, want)
+	}
+}
@@ -0,0 +1,136 @@
+package orasflow
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"cuelang.org/go/cue"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Options holds optional parameters for [Apply].
+type Options struct {
+	// MaxConcurrency holds the maximum number of tasks
+	// that can run at the same time. If it's zero,
+	// there is no limit.
+	MaxConcurrency int
+
+	// SingleThreaded causes tasks to run one at a time,
+	// which can make debugging easier. It's equivalent
+	// to setting MaxConcurrency to 1.
+	SingleThreaded bool
+
+	// RawJSON causes manifests and JSON blobs to be pushed
+	// exactly as CUE encodes them. By default, they're
+	// re-encoded as canonical JSON, so that semantically equal
+	// configurations always produce the same digests.
+	RawJSON bool
+
+	// Dir holds the directory that file sources and
+	// the files written by output tasks are resolved
+	// relative to. If it's empty, the current directory
+	// is used.
+	Dir string
+
+	// SourceRegistry, if non-nil, returns the registry with the
+	// given host name. It's used by copy tasks that copy from
+	// another registry, as named by their from.registry field.
+	// If it's nil, such tasks fail.
+	SourceRegistry func(ctx context.Context, host string) (Registry, error)
+
+	// Logger is used for debug logging.
+	// If it's nil, nothing is logged.
+	Logger *slog.Logger
+
+	// Event, if non-nil, is called once for each task
+	// when it completes, whether it succeeded or not.
+	// Calls are never made concurrently.
+	Event func(Event)
+
+	// Progress, if non-nil, is called once before any
+	// tasks run and then again after each task completes.
+	// Calls are never made concurrently.
+	Progress func(Progress)
+
+	// Output, if non-nil, is called with the value of each
+	// output task once the tasks it depends on have completed.
+	// If it returns an error, the task fails with that error.
+	// Calls are never made concurrently, either with each
+	// other or with calls to Event.
+	// If Output is nil, output tasks do nothing.
+	Output func(Output) error
+}
+
+// Output holds the value reported by an output task.
+type Output struct {
+	// Task holds the path of the task in the configuration.
+	Task cue.Path
+
+	// Name holds the name of the output, if any.
+	Name string
+
+	// To holds where the output should be written:
+	// "stdout", "file" or "results".
+	To string
+
+	// File holds the name of the file to write
+	// when To is "file". Relative names in the
+	// configuration are resolved against Options.Dir.
+	File string
+
+	// Value holds the value encoded as JSON.
+	Value json.RawMessage
+
+	// Data holds the value rendered in the task's format.
+	// It's nil when To is "results".
+	Data []byte
+}
+
+// Progress describes how far through its tasks [Apply] has got.
+type Progress struct {
+	// Done holds the number of tasks that have completed.
+	Done int
+
+	// Total holds the total number of tasks.
+	Total int
+}
+
+// Event describes a completed task.
+type Event struct {
+	// Task holds the path of the task in the configuration.
+	Task cue.Path
+
+	// Action holds the kind of the task: the value of its
+	// _oras field, for example "blob", "tag" or "copy".
+	Action string
+
+	// Repo holds the repository that the task acted on.
+	// For a copy, it's the destination repository.
+	Repo string
+
+	// Reference holds the tag or other reference used by the
+	// task, if any.
+	Reference string
+
+	// MediaType, Digest and Size describe the content
+	// that the task pushed, fetched or deleted, if any.
+	// For a referrers query, they describe the subject.
+	MediaType string
+	Digest    digest.Digest
+	Size      int64
+
+	// Duration holds how long the task took to run.
+	Duration time.Duration
+
+	// Err holds the error that the task failed with, if any.
+	Err error
+}
+
+func (e *Event) setDesc(desc ocispec.Descriptor) {
+	e.MediaType = desc.MediaType
+	e.Digest = desc.Digest
+	e.Size = desc.Size
+}
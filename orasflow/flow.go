@@ -1,38 +1,70 @@
 package orasflow
 
 import (
-	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"cuelang.org/go/cue"
 	"cuelang.org/go/cue/errors"
+	cuejson "cuelang.org/go/encoding/json"
 	"cuelang.org/go/tools/flow"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
-)
 
-const (
-	debug          = false
-	singleThreaded = false
+	"github.com/cue-exp/oras/mediatypes"
+	"github.com/cue-exp/oras/ociregistry"
 )
 
 const orasPkg = "github.com/cue-exp/oras"
 
 var orasField = cue.MakePath(cue.Hid("_oras", orasPkg))
 
-func Apply(ctx context.Context, v cue.Value, registry Registry) error {
+// Apply runs all the tasks in v against the given registry.
+// If opts is nil, default options are used.
+func Apply(ctx context.Context, v cue.Value, registry Registry, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
 	a := &applier{
 		cueCtx:   v.Context(),
 		registry: registry,
+		pusher:   newPusher(registry, logger),
+		opts:     opts,
+		logger:   logger,
 	}
-	ctl := flow.New(nil, v, a.getTask)
+	maxConcurrency := opts.MaxConcurrency
+	if opts.SingleThreaded {
+		maxConcurrency = 1
+	}
+	if maxConcurrency > 0 {
+		a.sem = make(chan struct{}, maxConcurrency)
+	}
+	if err := a.compileSchemas(); err != nil {
+		return err
+	}
+	v = a.fillDigests(v)
+	if err := a.checkManifests(v); err != nil {
+		return fmt.Errorf("invalid manifests: %v", errors.Details(err, nil))
+	}
+	var cfg flow.Config
+	if opts.Progress != nil {
+		cfg.UpdateFunc = a.progress
+	}
+	ctl := flow.New(&cfg, v, a.getTask)
 	if err := ctl.Run(ctx); err != nil {
 		return fmt.Errorf("error running flow: %v", errors.Details(err, nil))
 	}
@@ -42,6 +74,78 @@ func Apply(ctx context.Context, v cue.Value, registry Registry) error {
 type applier struct {
 	cueCtx   *cue.Context
 	registry Registry
+	// schemas holds the schemas from the mediatypes
+	// package, compiled in cueCtx.
+	schemas *mediatypes.Schemas
+	// pusher is used for all pushes so that
+	// existing content is not pushed again.
+	pusher *pusher
+	opts   *Options
+	logger *slog.Logger
+
+	// sem limits the number of concurrently running
+	// tasks when non-nil.
+	sem chan struct{}
+
+	// eventMu guards calls to opts.Event and opts.Output.
+	eventMu sync.Mutex
+}
+
+// taskFunc is the type of a function that runs a task.
+// It fills in ev with details of what the task did.
+type taskFunc func(t *flow.Task, ev *Event) error
+
+// runner returns a runner for the given task function
+// that reports an event when it completes.
+func (a *applier) runner(action string, f taskFunc) flow.Runner {
+	return flow.RunnerFunc(func(t *flow.Task) error {
+		if a.sem != nil {
+			select {
+			case a.sem <- struct{}{}:
+			case <-t.Context().Done():
+				return t.Context().Err()
+			}
+			defer func() { <-a.sem }()
+		}
+		a.logger.Debug("running task", "task", t.Path(), "action", action)
+		ev := Event{
+			Task:   t.Path(),
+			Action: action,
+		}
+		start := time.Now()
+		err := f(t, &ev)
+		ev.Duration = time.Since(start)
+		ev.Err = err
+		a.logger.Debug("task done",
+			"task", ev.Task,
+			"repo", ev.Repo,
+			"reference", ev.Reference,
+			"mediaType", ev.MediaType,
+			"digest", ev.Digest,
+			"duration", ev.Duration,
+			"error", ev.Err,
+		)
+		if a.opts.Event != nil {
+			a.eventMu.Lock()
+			defer a.eventMu.Unlock()
+			a.opts.Event(ev)
+		}
+		return err
+	})
+}
+
+// progress implements [flow.Config.UpdateFunc]
+// by calling the Progress callback.
+func (a *applier) progress(c *flow.Controller, _ *flow.Task) error {
+	var p Progress
+	for _, t := range c.Tasks() {
+		p.Total++
+		if t.State() == flow.Terminated {
+			p.Done++
+		}
+	}
+	a.opts.Progress(p)
+	return nil
 }
 
 func (a *applier) getTask(v cue.Value) (flow.Runner, error) {
@@ -55,64 +159,66 @@ func (a *applier) getTask(v cue.Value) (flow.Runner, error) {
 	}
 	switch s {
 	case "blob":
-		return wrapRunner(a.pushBlob), nil
+		return a.runner(s, a.pushBlob), nil
 	case "tag":
-		return wrapRunner(a.pushTag), nil
+		return a.runner(s, a.pushTag), nil
 	case "manifest":
-		return wrapRunner(a.pushManifest), nil
-	case "dump":
-		return wrapRunner(a.dump), nil
+		return a.runner(s, a.pushManifest), nil
+	case "index":
+		return a.runner(s, a.pushIndex), nil
+	case "referrers":
+		return a.runner(s, a.referrers), nil
+	case "resolve":
+		return a.runner(s, a.resolve), nil
+	case "fetchManifest":
+		return a.runner(s, a.fetchManifest), nil
+	case "fetchBlob":
+		return a.runner(s, a.fetchBlob), nil
+	case "listTags":
+		return a.runner(s, a.listTags), nil
+	case "copy":
+		return a.runner(s, a.copy), nil
+	case "deleteTag", "deleteManifest":
+		deleter, ok := a.registry.(Deleter)
+		if !ok {
+			return nil, fmt.Errorf("%s task found but the registry does not allow deletion", s)
+		}
+		if s == "deleteTag" {
+			return a.runner(s, deleteTagTask(deleter)), nil
+		}
+		return a.runner(s, deleteManifestTask(deleter)), nil
+	case "output":
+		return a.runner(s, a.output), nil
 	default:
 		return nil, fmt.Errorf("unknown _oras field value %q", s)
 	}
 }
 
 type blobPush struct {
-	Desc   ocispec.Descriptor `json:"desc"`
-	Repo   string             `json:"repo,omitempty"`
-	Source json.RawMessage    `json:"source"`
+	Desc      ocispec.Descriptor `json:"desc"`
+	Repo      string             `json:"repo,omitempty"`
+	Source    json.RawMessage    `json:"source"`
+	MountFrom []string           `json:"mountFrom,omitempty"`
 }
 
-func (a *applier) pushBlob(t *flow.Task) error {
+func (a *applier) pushBlob(t *flow.Task, ev *Event) error {
 	ctx := t.Context()
 	var p blobPush
 	if err := t.Value().Decode(&p); err != nil {
 		return fmt.Errorf("cannot decode blob spec from path %v (%v): %v", t.Path(), t.Value(), err)
 	}
-	var sourceData []byte
-	switch mtype := p.Desc.MediaType; {
-	case isJSON(mtype):
-		sourceData = p.Source
-	case mtype == "text/plain":
-		var s string
-		if err := json.Unmarshal(p.Source, &s); err != nil {
-			return fmt.Errorf("invalid source %#v for text/plain media type", p.Source)
-		}
-		sourceData = []byte(s)
-	case mtype == "application/zip":
-		var files map[string]string
-		if err := json.Unmarshal(p.Source, &files); err != nil {
-			return fmt.Errorf("invalid source %#v for application/zip media type", p.Source)
-		}
-		data, err := getZip(files)
-		if err != nil {
-			return fmt.Errorf("cannot make zip: %v", err)
-		}
-		sourceData = data
-	default:
-		var s string
-		if err := json.Unmarshal(p.Source, &s); err != nil {
-			return fmt.Errorf("invalid source %#v for media type %q (want string)", p.Source, mtype)
-		}
-		sourceData = []byte(s)
+	sourceData, err := a.blobContent(p.Desc.MediaType, p.Source)
+	if err != nil {
+		return fmt.Errorf("cannot get content of blob at %v: %v", t.Path(), err)
 	}
 	p.Desc.Digest = digest.FromBytes(sourceData)
 	p.Desc.Size = int64(len(sourceData))
+	ev.Repo = p.Repo
+	ev.setDesc(p.Desc)
 
-	prettySource, _ := json.MarshalIndent(p.Source, "\t", "\t")
-	logf("%v: push %s to %s -> %s\n\tsource: %s", t.Path(), p.Desc.MediaType, p.Repo, p.Desc.Digest, prettySource)
+	a.logger.Debug("blob source", "task", t.Path(), "source", string(p.Source))
 
-	if err := a.registry.Push(ctx, p.Repo, p.Desc, bytes.NewReader(sourceData)); err != nil {
+	if err := a.pusher.pushBlob(ctx, p.Repo, p.Desc, bytes.NewReader(sourceData), p.MountFrom); err != nil {
 		return fmt.Errorf("error pushing blob to repo %q: %v", p.Repo, err)
 	}
 	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc"), cue.Str("digest")), p.Desc.Digest))
@@ -126,125 +232,466 @@ type manifestPush struct {
 	Desc     ocispec.Descriptor `json:"desc"`
 }
 
-func (a *applier) pushManifest(t *flow.Task) error {
+func (a *applier) pushManifest(t *flow.Task, ev *Event) error {
 	var p manifestPush
 	if err := t.Value().Decode(&p); err != nil {
 		return fmt.Errorf("cannot decode #blob from path %v (%v): %v", t.Path(), t.Value(), err)
 	}
+	if err := a.checkTask(t.Value(), "manifest"); err != nil {
+		return fmt.Errorf("manifest at %v: %v", t.Path(), err)
+	}
 	ctx := t.Context()
+	data, err := a.encodeJSON(p.Manifest)
+	if err != nil {
+		return fmt.Errorf("cannot encode manifest at %v: %v", t.Path(), err)
+	}
+	desc, err := manifestDesc(data)
+	if err != nil {
+		return err
+	}
+	p.Desc.MediaType = desc.MediaType
+	p.Desc.Digest = desc.Digest
+	p.Desc.Size = desc.Size
+	ev.Repo = p.Repo
+	ev.setDesc(p.Desc)
+
+	a.logger.Debug("manifest source", "task", t.Path(), "source", string(data))
 
+	if err := a.pusher.PushManifest(ctx, p.Repo, p.Desc, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error pushing manifest to repo %q: %v", p.Repo, err)
+	}
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc")), p.Desc))
+	return nil
+}
+
+// manifestDesc returns the media type, digest and size
+// of the manifest with the given content.
+func manifestDesc(data []byte) (ocispec.Descriptor, error) {
 	var m struct {
 		MediaType string `json:"mediaType"`
 	}
-	if err := json.Unmarshal(p.Manifest, &m); err != nil {
-		return err
+	if err := json.Unmarshal(data, &m); err != nil {
+		return ocispec.Descriptor{}, err
 	}
+	return ocispec.Descriptor{
+		MediaType: m.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}, nil
+}
 
-	p.Desc.MediaType = m.MediaType
-	p.Desc.Digest = digest.FromBytes(p.Manifest)
-	p.Desc.Size = int64(len(p.Manifest))
+type indexPush struct {
+	Repo         string               `json:"repo,omitempty"`
+	Manifests    []ocispec.Descriptor `json:"manifests"`
+	ArtifactType string               `json:"artifactType,omitempty"`
+	Subject      *ocispec.Descriptor  `json:"subject,omitempty"`
+	Annotations  map[string]string    `json:"annotations,omitempty"`
+}
 
-	var buf bytes.Buffer
-	fmt.Fprintf(&buf, "%v: push manifest to %s -> %s\n", t.Path(), p.Repo, p.Desc.Digest)
-	fmt.Fprintf(&buf, "\tsource: ")
-	json.Indent(&buf, p.Manifest, "\t", "\t")
-	logf("%s", buf.String())
+// imageIndex holds the wire format of an image index.
+// It's defined here rather than using ocispec.Index because
+// the version of the spec we're using does not yet include
+// the artifactType and subject fields.
+type imageIndex struct {
+	SchemaVersion int                  `json:"schemaVersion"`
+	MediaType     string               `json:"mediaType"`
+	ArtifactType  string               `json:"artifactType,omitempty"`
+	Manifests     []ocispec.Descriptor `json:"manifests"`
+	Subject       *ocispec.Descriptor  `json:"subject,omitempty"`
+	Annotations   map[string]string    `json:"annotations,omitempty"`
+}
 
-	if err := a.registry.PushManifest(ctx, p.Repo, p.Desc, bytes.NewReader(p.Manifest)); err != nil {
-		return fmt.Errorf("error pushing manifest to repo %q: %v", p.Repo, err)
+func (a *applier) pushIndex(t *flow.Task, ev *Event) error {
+	var p indexPush
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode index spec from path %v (%v): %v", t.Path(), t.Value(), err)
 	}
-	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc")), p.Desc))
+	if err := a.checkTask(t.Value(), "index"); err != nil {
+		return fmt.Errorf("index at %v: %v", t.Path(), err)
+	}
+	ctx := t.Context()
+	desc, data, err := a.indexContent(p)
+	if err != nil {
+		return err
+	}
+	ev.Repo = p.Repo
+	ev.setDesc(desc)
+
+	a.logger.Debug("index source", "task", t.Path(), "source", string(data))
+
+	if err := a.pusher.PushManifest(ctx, p.Repo, desc, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("error pushing index to repo %q: %v", p.Repo, err)
+	}
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc")), desc))
 	return nil
 }
 
+// indexContent returns the descriptor and content
+// of the index described by p.
+func (a *applier) indexContent(p indexPush) (ocispec.Descriptor, []byte, error) {
+	if p.Manifests == nil {
+		p.Manifests = []ocispec.Descriptor{}
+	}
+	data, err := json.Marshal(imageIndex{
+		SchemaVersion: 2,
+		MediaType:     ocispec.MediaTypeImageIndex,
+		ArtifactType:  p.ArtifactType,
+		Manifests:     p.Manifests,
+		Subject:       p.Subject,
+		Annotations:   p.Annotations,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, nil, fmt.Errorf("cannot marshal index: %v", err)
+	}
+	data, err = a.encodeJSON(data)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	return ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageIndex,
+		ArtifactType: p.ArtifactType,
+		Digest:       digest.FromBytes(data),
+		Size:         int64(len(data)),
+	}, data, nil
+}
+
 type tagPush struct {
 	Repo string             `json:"repo,omitempty"`
 	Name string             `json:"name"`
 	Desc ocispec.Descriptor `json:"desc"`
 }
 
-func (a *applier) pushTag(t *flow.Task) error {
+func (a *applier) pushTag(t *flow.Task, ev *Event) error {
 	ctx := t.Context()
 	var p tagPush
 	if err := t.Value().Decode(&p); err != nil {
 		return fmt.Errorf("cannot decode manifest spec from path %v (%v): %v", t.Path(), t.Value(), err)
 	}
-	logf("%v: push tag %s:%s", t.Path(), p.Repo, p.Name)
+	ev.Repo, ev.Reference = p.Repo, p.Name
+	ev.setDesc(p.Desc)
 	if err := a.registry.Tag(ctx, p.Repo, p.Desc, p.Name); err != nil {
 		return fmt.Errorf("cannot create tag %q: %v", p.Name, err)
 	}
 	return nil
 }
 
-func (a *applier) dump(t *flow.Task) error {
+type referrersQuery struct {
+	Repo         string             `json:"repo,omitempty"`
+	Subject      ocispec.Descriptor `json:"subject"`
+	ArtifactType string             `json:"artifactType,omitempty"`
+}
+
+func (a *applier) referrers(t *flow.Task, ev *Event) error {
+	ctx := t.Context()
+	var p referrersQuery
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode referrers query from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Repo = p.Repo
+	ev.setDesc(p.Subject)
+	descs, err := a.registry.Referrers(ctx, p.Repo, p.Subject, p.ArtifactType)
+	if err != nil {
+		return fmt.Errorf("cannot list referrers of %s in repo %q: %v", p.Subject.Digest, p.Repo, err)
+	}
+	if descs == nil {
+		descs = []ocispec.Descriptor{}
+	}
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("referrers")), descs))
+	return nil
+}
+
+type resolveQuery struct {
+	Repo      string `json:"repo,omitempty"`
+	Reference string `json:"reference"`
+}
+
+func (a *applier) resolve(t *flow.Task, ev *Event) error {
+	ctx := t.Context()
+	var p resolveQuery
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode resolve query from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Repo, ev.Reference = p.Repo, p.Reference
+	desc, err := a.registry.Resolve(ctx, p.Repo, p.Reference)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %q in repo %q: %v", p.Reference, p.Repo, err)
+	}
+	ev.setDesc(desc)
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc")), desc))
+	return nil
+}
+
+func (a *applier) fetchManifest(t *flow.Task, ev *Event) error {
 	ctx := t.Context()
-	var p json.RawMessage
+	var p resolveQuery
 	if err := t.Value().Decode(&p); err != nil {
-		return fmt.Errorf("cannot decode dump from path %v (%v): %v", t.Path(), t.Value(), err)
+		return fmt.Errorf("cannot decode manifest fetch from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Repo, ev.Reference = p.Repo, p.Reference
+	desc, err := a.registry.Resolve(ctx, p.Repo, p.Reference)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %q in repo %q: %v", p.Reference, p.Repo, err)
+	}
+	ev.setDesc(desc)
+	if !isJSON(desc.MediaType) {
+		return fmt.Errorf("manifest %s in repo %q has non-JSON media type %q", desc.Digest, p.Repo, desc.MediaType)
 	}
+	data, err := readAll(a.registry.FetchManifest(ctx, p.Repo, desc))
+	if err != nil {
+		return fmt.Errorf("cannot fetch manifest %s from repo %q: %v", desc.Digest, p.Repo, err)
+	}
+	manifest, err := a.decodeContent(desc.MediaType, data)
+	if err != nil {
+		return fmt.Errorf("cannot decode manifest %s from repo %q: %v", desc.Digest, p.Repo, err)
+	}
+	v := t.Value().FillPath(cue.MakePath(cue.Str("desc")), desc)
+	t.Fill(v.FillPath(cue.MakePath(cue.Str("manifest")), manifest))
+	return nil
+}
+
+type repoContent struct {
+	Repo string             `json:"repo,omitempty"`
+	Desc ocispec.Descriptor `json:"desc"`
+}
 
-	logf("%v: dump %q", p)
-	a.registry.Dump(ctx, p)
+func (a *applier) fetchBlob(t *flow.Task, ev *Event) error {
+	ctx := t.Context()
+	var p repoContent
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode blob fetch from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Repo = p.Repo
+	ev.setDesc(p.Desc)
+	data, err := readAll(a.registry.Fetch(ctx, p.Repo, p.Desc))
+	if err != nil {
+		return fmt.Errorf("cannot fetch blob %s from repo %q: %v", p.Desc.Digest, p.Repo, err)
+	}
+	content, err := a.decodeContent(p.Desc.MediaType, data)
+	if err != nil {
+		return fmt.Errorf("cannot decode blob %s from repo %q: %v", p.Desc.Digest, p.Repo, err)
+	}
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("content")), content))
 	return nil
 }
 
-// getZip returns a zip archive consisting of all the given files, keyed by filename.
-func getZip(files map[string]string) ([]byte, error) {
-	if len(files) == 0 {
-		return nil, fmt.Errorf("no files found in zip archive")
+type tagsQuery struct {
+	Repo string `json:"repo,omitempty"`
+}
+
+func (a *applier) listTags(t *flow.Task, ev *Event) error {
+	ctx := t.Context()
+	var p tagsQuery
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode tags query from path %v (%v): %v", t.Path(), t.Value(), err)
 	}
-	names := make([]string, 0, len(files))
-	for k := range files {
-		names = append(names, k)
+	ev.Repo = p.Repo
+	tags, err := a.registry.Tags(ctx, p.Repo)
+	if err != nil {
+		return fmt.Errorf("cannot list tags in repo %q: %v", p.Repo, err)
 	}
-	sort.Strings(names)
+	if tags == nil {
+		tags = []string{}
+	}
+	sort.Strings(tags)
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("tags")), tags))
+	return nil
+}
+
+type copySpec struct {
+	From struct {
+		Registry  string `json:"registry,omitempty"`
+		Repo      string `json:"repo"`
+		Reference string `json:"reference"`
+	} `json:"from"`
+	To struct {
+		Repo string `json:"repo"`
+		Tag  string `json:"tag,omitempty"`
+	} `json:"to"`
+	IncludeReferrers bool `json:"includeReferrers,omitempty"`
+}
 
-	var buf bytes.Buffer
-	zipw := zip.NewWriter(&buf)
-	for _, name := range names {
-		w, err := zipw.Create(name)
+func (a *applier) copy(t *flow.Task, ev *Event) error {
+	ctx := t.Context()
+	var p copySpec
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode copy spec from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Repo, ev.Reference = p.To.Repo, p.To.Tag
+	dst := newOCIRegistry(a.pusher)
+	src := dst
+	from := p.From.Repo
+	if p.From.Registry != "" {
+		if a.opts.SourceRegistry == nil {
+			return fmt.Errorf("cannot copy from registry %q: no way of opening other registries", p.From.Registry)
+		}
+		r, err := a.opts.SourceRegistry(ctx, p.From.Registry)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("cannot open registry %q: %v", p.From.Registry, err)
 		}
-		if _, err := w.Write([]byte(files[name])); err != nil {
-			return nil, err
+		src = newOCIRegistry(r)
+		from = p.From.Registry + "/" + from
+	}
+	srcDesc, err := src.r.Resolve(ctx, p.From.Repo, p.From.Reference)
+	if err != nil {
+		return fmt.Errorf("cannot resolve %q in repo %q: %v", p.From.Reference, from, err)
+	}
+	desc, err := ociregistry.Copy(ctx, dst, p.To.Repo, src, p.From.Repo, srcDesc.Digest, p.IncludeReferrers)
+	if err != nil {
+		return fmt.Errorf("cannot copy %s:%s to %s: %v", from, p.From.Reference, p.To.Repo, err)
+	}
+	ev.setDesc(desc)
+	if p.To.Tag != "" {
+		if err := a.registry.Tag(ctx, p.To.Repo, desc, p.To.Tag); err != nil {
+			return fmt.Errorf("cannot create tag %q: %v", p.To.Tag, err)
 		}
 	}
-	if err := zipw.Close(); err != nil {
-		return nil, err
+	t.Fill(t.Value().FillPath(cue.MakePath(cue.Str("desc")), desc))
+	return nil
+}
+
+func deleteTagTask(deleter Deleter) taskFunc {
+	return func(t *flow.Task, ev *Event) error {
+		ctx := t.Context()
+		var p tagPush
+		if err := t.Value().Decode(&p); err != nil {
+			return fmt.Errorf("cannot decode tag deletion from path %v (%v): %v", t.Path(), t.Value(), err)
+		}
+		ev.Repo, ev.Reference = p.Repo, p.Name
+		if err := deleter.DeleteTag(ctx, p.Repo, p.Name); err != nil {
+			return fmt.Errorf("cannot delete tag %q from repo %q: %v", p.Name, p.Repo, err)
+		}
+		return nil
 	}
-	return buf.Bytes(), nil
 }
 
-// isJSON reports whether the given media type has JSON as an underlying encoding.
-// TODO this is a guess. There's probably a more correct way to do it.
-func isJSON(mediaType string) bool {
-	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "/json")
+func deleteManifestTask(deleter Deleter) taskFunc {
+	return func(t *flow.Task, ev *Event) error {
+		ctx := t.Context()
+		var p repoContent
+		if err := t.Value().Decode(&p); err != nil {
+			return fmt.Errorf("cannot decode manifest deletion from path %v (%v): %v", t.Path(), t.Value(), err)
+		}
+		ev.Repo = p.Repo
+		ev.setDesc(p.Desc)
+		if err := deleter.DeleteManifest(ctx, p.Repo, p.Desc); err != nil {
+			return fmt.Errorf("cannot delete manifest %s from repo %q: %v", p.Desc.Digest, p.Repo, err)
+		}
+		return nil
+	}
 }
 
-var globalMutex sync.Mutex
-var prevTime = time.Now()
+// decodeContent returns the CUE representation of the given
+// content: JSON media types are decoded as CUE values,
+// other valid UTF-8 content as a string,
+// and anything else as bytes.
+func (a *applier) decodeContent(mediaType string, data []byte) (cue.Value, error) {
+	switch {
+	case isJSON(mediaType):
+		expr, err := cuejson.Extract(mediaType, data)
+		if err != nil {
+			return cue.Value{}, err
+		}
+		v := a.cueCtx.BuildExpr(expr)
+		return v, v.Err()
+	case utf8.Valid(data):
+		return a.cueCtx.Encode(string(data)), nil
+	default:
+		return a.cueCtx.Encode(data), nil
+	}
+}
 
-func wrapRunner(f flow.RunnerFunc) flow.RunnerFunc {
-	if !singleThreaded {
-		return f
+// readAll reads all the data from r and closes it.
+// As a convenience, it returns any non-nil error
+// passed to it.
+func readAll(r io.ReadCloser, err error) ([]byte, error) {
+	if err != nil {
+		return nil, err
 	}
-	return func(t *flow.Task) error {
-		globalMutex.Lock()
-		logf("-- run %v (%v){", t.Path(), time.Since(prevTime))
-		defer logf("}")
-		defer globalMutex.Unlock()
-		err := f(t)
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type outputSpec struct {
+	Name   string          `json:"name"`
+	Value  json.RawMessage `json:"value"`
+	To     string          `json:"to"`
+	File   string          `json:"file"`
+	Format string          `json:"format"`
+}
+
+func (a *applier) output(t *flow.Task, ev *Event) error {
+	var p outputSpec
+	if err := t.Value().Decode(&p); err != nil {
+		return fmt.Errorf("cannot decode output from path %v (%v): %v", t.Path(), t.Value(), err)
+	}
+	ev.Reference = p.Name
+	out := Output{
+		Task:  t.Path(),
+		Name:  p.Name,
+		To:    p.To,
+		Value: p.Value,
+	}
+	switch p.To {
+	case "stdout":
+	case "file":
+		if p.File == "" {
+			return fmt.Errorf("output at %v has no file name", t.Path())
+		}
+		out.File = p.File
+		if !filepath.IsAbs(out.File) {
+			out.File = filepath.Join(a.opts.Dir, out.File)
+		}
+	case "results":
+		if p.Name == "" {
+			return fmt.Errorf("output at %v has no name", t.Path())
+		}
+	default:
+		return fmt.Errorf("output at %v has unknown destination %q", t.Path(), p.To)
+	}
+	if p.To != "results" {
+		data, err := renderOutput(p.Value, p.Format)
 		if err != nil {
-			logf("-> error %v", err)
+			return fmt.Errorf("cannot render output at %v: %v", t.Path(), err)
 		}
-		prevTime = time.Now()
-		return err
+		out.Data = data
 	}
+	a.logger.Debug("output", "task", t.Path(), "name", p.Name, "to", p.To, "file", p.File)
+	if a.opts.Output == nil {
+		return nil
+	}
+	a.eventMu.Lock()
+	defer a.eventMu.Unlock()
+	return a.opts.Output(out)
 }
 
-func logf(f string, a ...any) {
-	if debug {
-		fmt.Println(fmt.Sprintf(f, a...))
+// renderOutput renders the JSON-encoded value in the given format.
+// If format is empty, strings are rendered as text and
+// everything else as JSON.
+func renderOutput(value json.RawMessage, format string) ([]byte, error) {
+	if format == "" {
+		format = "json"
+		if len(value) > 0 && value[0] == '"' {
+			format = "text"
+		}
 	}
+	switch format {
+	case "text":
+		var s string
+		if err := json.Unmarshal(value, &s); err != nil {
+			return nil, fmt.Errorf("text format requires a string value")
+		}
+		if !strings.HasSuffix(s, "\n") {
+			s += "\n"
+		}
+		return []byte(s), nil
+	case "json":
+		return append(value[:len(value):len(value)], '\n'), nil
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// isJSON reports whether the given media type has JSON as an underlying encoding.
+// TODO this is a guess. There's probably a more correct way to do it.
+func isJSON(mediaType string) bool {
+	return strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "/json")
 }
@@ -0,0 +1,382 @@
+package orasflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/cue/load"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// loadConfig loads the CUE package with the given source, which
+// can import the oras package, as if it was in dir, a directory
+// inside the module. It returns the value and the absolute
+// directory of the package.
+func loadConfig(t *testing.T, dir, src string) (cue.Value, string) {
+	t.Helper()
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	insts := load.Instances([]string{"."}, &load.Config{
+		Dir: dir,
+		Overlay: map[string]load.Source{
+			filepath.Join(dir, "test.cue"): load.FromString(src),
+		},
+	})
+	if err := insts[0].Err; err != nil {
+		t.Fatalf("cannot load config: %v", errors.Details(err, nil))
+	}
+	v := cuecontext.New().BuildInstance(insts[0])
+	if err := v.Err(); err != nil {
+		t.Fatalf("cannot build config: %v", errors.Details(err, nil))
+	}
+	return v, insts[0].Dir
+}
+
+// applyConfig applies the configuration in src, loaded as by
+// loadConfig from testdata/dir, to a new in-memory registry.
+func applyConfig(t *testing.T, dir, src string, opts *Options) (*ociregistry.MemRegistry, error) {
+	t.Helper()
+	v, pkgDir := loadConfig(t, filepath.Join("testdata", dir), src)
+	if opts == nil {
+		opts = &Options{}
+	}
+	if opts.Dir == "" {
+		opts.Dir = pkgDir
+	}
+	r := ociregistry.NewMemRegistry()
+	return r, Apply(context.Background(), v, RegistryFromInterface(r), opts)
+}
+
+func TestOutputFile(t *testing.T) {
+	var outputs []Output
+	_, err := applyConfig(t, "output", `
+package test
+
+import "github.com/cue-exp/oras"
+
+relative: oras.#output & {
+	to:    "file"
+	file:  "out/digest.txt"
+	value: "x"
+}
+
+absolute: oras.#output & {
+	to:    "file"
+	file:  "/tmp/digest.txt"
+	value: "y"
+}
+`, &Options{
+		Output: func(o Output) error {
+			outputs = append(outputs, o)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, _ := filepath.Abs(filepath.Join("testdata", "output"))
+	want := map[string]string{
+		"x\n": filepath.Join(dir, "out", "digest.txt"),
+		"y\n": "/tmp/digest.txt",
+	}
+	if len(outputs) != len(want) {
+		t.Fatalf("got %d outputs, want %d", len(outputs), len(want))
+	}
+	for _, o := range outputs {
+		if o.File != want[string(o.Data)] {
+			t.Errorf("output %q written to %q; want %q", o.Data, o.File, want[string(o.Data)])
+		}
+	}
+}
+
+func TestApplyChecksManifestsFirst(t *testing.T) {
+	// The module manifest has no layers, which the schema for
+	// its artifact type doesn't allow, so nothing at all should
+	// be pushed, not even the blob that's independent of it.
+	r, err := applyConfig(t, "check", `
+package test
+
+import "github.com/cue-exp/oras"
+
+blob: oras.#repoBlob & {
+	repo: "check"
+	desc: mediaType: "text/plain"
+	source: "hello"
+}
+
+module: oras.#repoManifest & {
+	repo: "check"
+	manifest: {
+		mediaType:    _
+		artifactType: "application/vnd.cue.module.v1+json"
+		config:       oras.scratchConfig
+		layers: []
+	}
+}
+`, nil)
+	if err == nil {
+		t.Fatal("expected error applying invalid manifest")
+	}
+	if want := "invalid manifests: "; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("unexpected error %q; want prefix %q", err, want)
+	}
+	repos, err := ociregistry.All(r.Repositories(context.Background(), nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repos) != 0 {
+		t.Errorf("content was pushed to %q despite the invalid manifest", repos)
+	}
+}
+
+var copyBetweenRegistriesTests = []struct {
+	testName string
+	registry string
+	noSource bool
+	wantErr  string
+}{{
+	testName: "OtherRegistry",
+	registry: "src.example.com",
+}, {
+	testName: "UnknownRegistry",
+	registry: "other.example.com",
+	wantErr:  `cannot open registry "other.example.com": unknown registry`,
+}, {
+	testName: "NoSourceRegistry",
+	registry: "src.example.com",
+	noSource: true,
+	wantErr:  `cannot copy from registry "src.example.com": no way of opening other registries`,
+}}
+
+func TestCopyBetweenRegistries(t *testing.T) {
+	ctx := context.Background()
+	src := ociregistry.NewMemRegistry()
+	push := func(mediaType string, data []byte) ociregistry.Descriptor {
+		b := ociregistry.BytesBlob(data, mediaType)
+		var err error
+		if ociregistry.IsManifest(mediaType) {
+			_, err = src.PushManifest(ctx, "app", b, b.Descriptor())
+		} else {
+			_, err = src.PushBlob(ctx, "app", b, b.Descriptor())
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Descriptor()
+	}
+	config := push("application/vnd.oci.empty.v1+json", []byte("{}"))
+	layer := push("text/plain", []byte("hello"))
+	data, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := push(ocispec.MediaTypeImageManifest, data)
+	if err := src.Tag(ctx, "app", m.Digest, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range copyBetweenRegistriesTests {
+		t.Run(test.testName, func(t *testing.T) {
+			opts := &Options{
+				SourceRegistry: func(ctx context.Context, host string) (Registry, error) {
+					if host != "src.example.com" {
+						return nil, fmt.Errorf("unknown registry")
+					}
+					return RegistryFromInterface(src), nil
+				},
+			}
+			if test.noSource {
+				opts.SourceRegistry = nil
+			}
+			r, err := applyConfig(t, "copy", `
+package test
+
+import "github.com/cue-exp/oras"
+
+promote: oras.#copy & {
+	from: {
+		registry:  "`+test.registry+`"
+		repo:      "app"
+		reference: "v1"
+	}
+	to: {
+		repo: "prod/app"
+		tag:  "v1"
+	}
+}
+`, opts)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := r.GetTag(ctx, "prod/app", "v1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := b.Descriptor().Digest; got != m.Digest {
+				t.Errorf("copied manifest has digest %s; want %s", got, m.Digest)
+			}
+			for _, desc := range []ociregistry.Descriptor{config, layer} {
+				if _, err := r.GetBlob(ctx, "prod/app", desc.Digest); err != nil {
+					t.Errorf("blob not copied: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// deleteConfig holds a configuration that pushes a release
+// and tags it as v1 and latest. Delete tasks are appended to it.
+const deleteConfig = `
+package test
+
+import "github.com/cue-exp/oras"
+
+_repo: "delete"
+
+scratch: oras.#repoBlob & {
+	repo:   _repo
+	desc:   oras.scratchConfig
+	source: {}
+}
+release: oras.#repoManifest & {
+	repo: _repo
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-release"
+		config:       scratch.desc
+		layers: []
+	}
+}
+tags: [name=string]: oras.#repoTag & {
+	repo: _repo
+	"name": name
+	desc: release.desc
+}
+tags: v1:     _
+tags: latest: _
+`
+
+var deleteTests = []struct {
+	testName     string
+	deletes      string
+	noDeleter    bool
+	wantTags     []string
+	wantManifest bool
+	wantErr      string
+}{{
+	testName:     "NoDeletes",
+	wantTags:     []string{"latest", "v1"},
+	wantManifest: true,
+}, {
+	testName: "DeleteTag",
+	deletes: `
+retire: oras.#deleteTag & {
+	repo:   _repo
+	name:   "v1"
+	_after: tags.v1.desc
+}
+`,
+	wantTags:     []string{"latest"},
+	wantManifest: true,
+}, {
+	testName: "DeleteManifest",
+	deletes: `
+retire: oras.#deleteManifest & {
+	repo:   _repo
+	desc:   release.desc
+	_after: [tags.v1.desc, tags.latest.desc]
+}
+`,
+	wantTags: []string{},
+}, {
+	testName: "DeleteMissingTag",
+	deletes: `
+retire: oras.#deleteTag & {
+	repo:   _repo
+	name:   "v2"
+	_after: tags.v1.desc
+}
+`,
+	wantErr: `cannot delete tag "v2" from repo "delete"`,
+}, {
+	testName: "NotAllowed",
+	deletes: `
+retire: oras.#deleteTag & {
+	repo: _repo
+	name: "v1"
+}
+`,
+	noDeleter: true,
+	wantErr:   "deleteTag task found but the registry does not allow deletion",
+}}
+
+func TestDeleteTasks(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range deleteTests {
+		t.Run(test.testName, func(t *testing.T) {
+			v, dir := loadConfig(t, filepath.Join("testdata", "delete"), deleteConfig+test.deletes)
+			mr := ociregistry.NewMemRegistry()
+			r := RegistryFromInterface(mr)
+			if test.noDeleter {
+				// Hide the Deleter methods.
+				r = struct{ Registry }{r}
+			}
+			var manifest ociregistry.Digest
+			err := Apply(ctx, v, r, &Options{
+				Dir: dir,
+				Event: func(ev Event) {
+					if ev.Action == "manifest" {
+						manifest = ev.Digest
+					}
+				},
+			})
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				if test.noDeleter {
+					if repos, _ := ociregistry.All(mr.Repositories(ctx, nil)); len(repos) != 0 {
+						t.Errorf("content was pushed to %q despite the refused deletion", repos)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			tags, err := ociregistry.All(mr.Tags(ctx, "delete", nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(tags, " ") != strings.Join(test.wantTags, " ") {
+				t.Errorf("got tags %q; want %q", tags, test.wantTags)
+			}
+			_, err = mr.GetManifest(ctx, "delete", manifest)
+			if gotManifest := err == nil; gotManifest != test.wantManifest {
+				t.Errorf("manifest present: %v; want %v", gotManifest, test.wantManifest)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package orasflow
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// GC deletes the manifests and blobs in the layout that aren't
+// reachable from a tag in their repository (see [ociregistry.Reachable]).
+// They're removed from the index, which is written by Close, and
+// then the files of content that's no longer in any repository are
+// deleted, along with any files left by interrupted uploads.
+//
+// Content whose file was written within the grace period is kept,
+// as is everything that a manifest written within the grace period
+// refers to. Pushing content that's already in the layout counts
+// as writing it.
+// If opts is nil, default options are used.
+func (r *LayoutRegistry) GC(ctx context.Context, opts *ociregistry.GCOptions) (*ociregistry.GCResult, error) {
+	if opts == nil {
+		opts = &ociregistry.GCOptions{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	recent := func(file string) bool {
+		info, err := os.Stat(file)
+		return err == nil && info.ModTime().After(cutoff)
+	}
+
+	repos := make(map[string][]ocispec.Descriptor)
+	for k, desc := range r.contents {
+		repos[k.repo] = append(repos[k.repo], desc)
+	}
+	roots := make(map[string][]digest.Digest)
+	for k, desc := range r.tags {
+		roots[k.repo] = append(roots[k.repo], desc.Digest)
+	}
+	result := &ociregistry.GCResult{}
+	indexed := make(map[digest.Digest]bool)
+	keep := make(map[digest.Digest]bool)
+	for repo, descs := range repos {
+		manifests := make(map[digest.Digest][]byte)
+		for _, desc := range descs {
+			indexed[desc.Digest] = true
+			if !ociregistry.IsManifest(desc.MediaType) {
+				continue
+			}
+			data, err := os.ReadFile(r.blobPath(desc.Digest))
+			if err != nil {
+				return nil, err
+			}
+			manifests[desc.Digest] = data
+			if recent(r.blobPath(desc.Digest)) {
+				roots[repo] = append(roots[repo], desc.Digest)
+			}
+		}
+		live := ociregistry.Reachable(roots[repo], manifests)
+		for _, desc := range descs {
+			if live[desc.Digest] || recent(r.blobPath(desc.Digest)) {
+				keep[desc.Digest] = true
+				continue
+			}
+			result.Deleted = append(result.Deleted, ociregistry.GCItem{
+				Repo: repo,
+				Desc: desc,
+			})
+			if !opts.DryRun {
+				delete(r.contents, repoRef{repo, string(desc.Digest)})
+			}
+		}
+	}
+
+	// Delete the files that nothing refers to any more.
+	err := filepath.WalkDir(filepath.Join(r.dir, layoutBlobsDir), func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		dig := digest.NewDigestFromEncoded(digest.Algorithm(filepath.Base(filepath.Dir(file))), d.Name())
+		if keep[dig] || recent(file) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		result.Bytes += info.Size()
+		if !indexed[dig] && !strings.HasPrefix(d.Name(), "tmp-") {
+			result.Deleted = append(result.Deleted, ociregistry.GCItem{
+				Desc: ocispec.Descriptor{
+					Digest: dig,
+					Size:   info.Size(),
+				},
+			})
+		}
+		if opts.DryRun {
+			return nil
+		}
+		return os.Remove(file)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(result.Deleted, func(i, j int) bool {
+		d0, d1 := result.Deleted[i], result.Deleted[j]
+		if d0.Repo != d1.Repo {
+			return d0.Repo < d1.Repo
+		}
+		return d0.Desc.Digest < d1.Desc.Digest
+	})
+	return result, nil
+}
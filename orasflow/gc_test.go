@@ -0,0 +1,177 @@
+package orasflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+func TestLayoutGC(t *testing.T) {
+	ctx := context.Background()
+	r, err := OpenLayout(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	age := func(desc ocispec.Descriptor) {
+		if err := os.Chtimes(r.blobPath(desc.Digest), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// An upload that's in progress: its config and layer were
+	// written long ago, but the manifest that refers to them
+	// has only just been written and not yet tagged.
+	config := pushLayoutBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	layer := pushLayoutBlob(t, r, "repo", "text/plain", "layer")
+	age(config)
+	age(layer)
+	m := pushLayoutManifest(t, r, "repo", config, layer)
+
+	// A tagged image, all written long ago.
+	taggedLayer := pushLayoutBlob(t, r, "repo", "text/plain", "tagged")
+	tagged := pushLayoutManifest(t, r, "repo", config, taggedLayer)
+	if err := r.Tag(ctx, "repo", tagged, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	age(taggedLayer)
+	age(tagged)
+
+	// Garbage: an old untagged manifest and its layer, and a
+	// file that was never in any repository.
+	oldLayer := pushLayoutBlob(t, r, "repo", "text/plain", "old")
+	oldManifest := pushLayoutManifest(t, r, "repo", config, oldLayer)
+	age(oldLayer)
+	age(oldManifest)
+	strayDigest := digest.FromString("stray")
+	if err := os.WriteFile(r.blobPath(strayDigest), []byte("stray"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	stray := ocispec.Descriptor{Digest: strayDigest, Size: 5}
+	age(stray)
+
+	want := []ociregistry.GCItem{
+		{Desc: stray},
+		{Repo: "repo", Desc: oldLayer},
+		{Repo: "repo", Desc: oldManifest},
+	}
+	sortLayoutGCItems(want)
+	wantBytes := stray.Size + oldLayer.Size + oldManifest.Size
+	for _, dryRun := range []bool{true, false} {
+		result, err := r.GC(ctx, &ociregistry.GCOptions{
+			GracePeriod: time.Hour,
+			DryRun:      dryRun,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalGCItems(result.Deleted, want) || result.Bytes != wantBytes {
+			t.Errorf("dry run %v: got deleted %v (%d bytes); want %v (%d bytes)", dryRun, result.Deleted, result.Bytes, want, wantBytes)
+		}
+	}
+	for _, desc := range []ocispec.Descriptor{config, layer, m, taggedLayer, tagged} {
+		if ok, err := r.Exists(ctx, "repo", desc); err != nil || !ok {
+			t.Errorf("%s was deleted", desc.Digest)
+		}
+		if _, err := os.Stat(r.blobPath(desc.Digest)); err != nil {
+			t.Errorf("file of %s was deleted", desc.Digest)
+		}
+	}
+	for _, desc := range []ocispec.Descriptor{oldLayer, oldManifest, stray} {
+		if ok, _ := r.Exists(ctx, "repo", desc); ok {
+			t.Errorf("%s was not deleted", desc.Digest)
+		}
+		if _, err := os.Stat(r.blobPath(desc.Digest)); err == nil {
+			t.Errorf("file of %s was not deleted", desc.Digest)
+		}
+	}
+
+	// Once the grace period is over, only the
+	// tagged content is left.
+	result, err := r.GC(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []ociregistry.GCItem{
+		{Repo: "repo", Desc: layer},
+		{Repo: "repo", Desc: m},
+	}
+	sortLayoutGCItems(want)
+	if !equalGCItems(result.Deleted, want) {
+		t.Errorf("got deleted %v; want %v", result.Deleted, want)
+	}
+	files, err := filepath.Glob(filepath.Join(r.dir, layoutBlobsDir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("got %d files left; want 3", len(files))
+	}
+}
+
+func pushLayoutBlob(t *testing.T, r *LayoutRegistry, repo, mediaType, data string) ocispec.Descriptor {
+	t.Helper()
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromString(data),
+		Size:      int64(len(data)),
+	}
+	if err := r.Push(context.Background(), repo, desc, bytes.NewReader([]byte(data))); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func pushLayoutManifest(t *testing.T, r *LayoutRegistry, repo string, config ocispec.Descriptor, layers ...ocispec.Descriptor) ocispec.Descriptor {
+	t.Helper()
+	m := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    layers,
+	}
+	m.SchemaVersion = 2
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := ocispec.Descriptor{
+		MediaType: m.MediaType,
+		Digest:    digest.FromBytes(data),
+		Size:      int64(len(data)),
+	}
+	if err := r.PushManifest(context.Background(), repo, desc, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func sortLayoutGCItems(items []ociregistry.GCItem) {
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Repo != items[j].Repo {
+			return items[i].Repo < items[j].Repo
+		}
+		return items[i].Desc.Digest < items[j].Desc.Digest
+	})
+}
+
+func equalGCItems(a, b []ociregistry.GCItem) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Repo != b[i].Repo || a[i].Desc.Digest != b[i].Desc.Digest || a[i].Desc.Size != b[i].Desc.Size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package orasflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// RegistryFromInterface returns a Registry that talks to r.
+// The result also implements [Deleter] and [Mounter].
+// Referrers and Tags are only supported when r
+// also implements [ociregistry.Lister].
+func RegistryFromInterface(r ociregistry.Interface) Registry {
+	return interfaceShim{r}
+}
+
+// interfaceShim adapts an ociregistry.Interface to Registry.
+type interfaceShim struct {
+	r ociregistry.Interface
+}
+
+var (
+	_ Registry = interfaceShim{}
+	_ Deleter  = interfaceShim{}
+	_ Mounter  = interfaceShim{}
+	_ Exister  = interfaceShim{}
+)
+
+func (r interfaceShim) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	_, err = r.r.PushBlob(ctx, repoName, ociregistry.BytesBlob(data, desc.MediaType), desc)
+	return err
+}
+
+func (r interfaceShim) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	_, err = r.r.PushManifest(ctx, repoName, ociregistry.BytesBlob(data, desc.MediaType), desc)
+	return err
+}
+
+func (r interfaceShim) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
+	return r.r.Tag(ctx, repoName, desc.Digest, reference)
+}
+
+func (r interfaceShim) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	lister, ok := r.r.(ociregistry.Lister)
+	if !ok {
+		return nil, fmt.Errorf("registry does not support listing referrers")
+	}
+	it := lister.Referrers(ctx, repoName, desc.Digest, &ociregistry.ReferrersOptions{
+		ArtifactType: artifactType,
+	})
+	descs, err := ociregistry.All(it)
+	if err != nil || artifactType == "" || slices.Contains(ociregistry.FiltersApplied(it), "artifactType") {
+		return descs, err
+	}
+	filtered := descs[:0]
+	for _, desc := range descs {
+		if desc.ArtifactType == artifactType {
+			filtered = append(filtered, desc)
+		}
+	}
+	return filtered, nil
+}
+
+func (r interfaceShim) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	var b ociregistry.BlobReader
+	var err error
+	if dig, derr := digest.Parse(reference); derr == nil {
+		b, err = r.r.GetManifest(ctx, repoName, dig)
+	} else {
+		b, err = r.r.GetTag(ctx, repoName, reference)
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return b.Descriptor(), nil
+}
+
+func (r interfaceShim) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	b, err := r.r.GetBlob(ctx, repoName, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return b.Open(), nil
+}
+
+func (r interfaceShim) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	b, err := r.r.GetManifest(ctx, repoName, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	return b.Open(), nil
+}
+
+func (r interfaceShim) Tags(ctx context.Context, repoName string) ([]string, error) {
+	lister, ok := r.r.(ociregistry.Lister)
+	if !ok {
+		return nil, fmt.Errorf("registry does not support listing tags")
+	}
+	return ociregistry.All(lister.Tags(ctx, repoName, nil))
+}
+
+func (r interfaceShim) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	if ociregistry.IsManifest(desc.MediaType) {
+		_, err := r.r.GetManifest(ctx, repoName, desc.Digest)
+		if err == nil {
+			return true, nil
+		}
+		if !ociregistry.IsNotFound(err) {
+			return false, err
+		}
+		// Content with a manifest media type can also be
+		// pushed as a blob, so look for it there too.
+	}
+	_, err := r.r.GetBlob(ctx, repoName, desc.Digest)
+	if err == nil {
+		return true, nil
+	}
+	if ociregistry.IsNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (r interfaceShim) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	return r.r.DeleteTag(ctx, repoName, tag)
+}
+
+func (r interfaceShim) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	return r.r.DeleteManifest(ctx, repoName, desc.Digest)
+}
+
+func (r interfaceShim) Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error {
+	return r.r.Mount(ctx, repoName, fromRepo, desc.Digest)
+}
@@ -0,0 +1,101 @@
+package orasflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// unfilteredLister is a registry that ignores the
+// artifactType filter when listing referrers, as
+// some registries do.
+type unfilteredLister struct {
+	*ociregistry.MemRegistry
+}
+
+func (r unfilteredLister) Referrers(ctx context.Context, repo string, dig ociregistry.Digest, opts *ociregistry.ReferrersOptions) ociregistry.Iter[ociregistry.Descriptor] {
+	return r.MemRegistry.Referrers(ctx, repo, dig, nil)
+}
+
+func TestInterfaceReferrers(t *testing.T) {
+	ctx := context.Background()
+	for _, filtered := range []bool{true, false} {
+		mr := ociregistry.NewMemRegistry()
+		var r Registry
+		if filtered {
+			r = RegistryFromInterface(mr)
+		} else {
+			r = RegistryFromInterface(unfilteredLister{mr})
+		}
+		push := func(mediaType string, x any) ocispec.Descriptor {
+			data, err := json.Marshal(x)
+			if err != nil {
+				t.Fatal(err)
+			}
+			desc := ocispec.Descriptor{
+				MediaType: mediaType,
+				Digest:    digest.FromBytes(data),
+				Size:      int64(len(data)),
+			}
+			if ociregistry.IsManifest(mediaType) {
+				err = r.PushManifest(ctx, "repo", desc, bytes.NewReader(data))
+			} else {
+				err = r.Push(ctx, "repo", desc, bytes.NewReader(data))
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			return desc
+		}
+		config := push("application/vnd.oci.empty.v1+json", struct{}{})
+		subject := push(ocispec.MediaTypeImageManifest, map[string]any{
+			"schemaVersion": 2,
+			"mediaType":     ocispec.MediaTypeImageManifest,
+			"config":        config,
+			"layers":        []ocispec.Descriptor{},
+		})
+		var sigs []digest.Digest
+		for _, artifactType := range []string{"application/vnd.example.sig", "application/vnd.example.sbom", "application/vnd.example.sig"} {
+			desc := push(ocispec.MediaTypeImageManifest, map[string]any{
+				"schemaVersion": 2,
+				"mediaType":     ocispec.MediaTypeImageManifest,
+				"artifactType":  artifactType,
+				"config":        config,
+				"layers":        []ocispec.Descriptor{},
+				"subject":       subject,
+				"annotations":   map[string]string{"n": string(rune('a' + len(sigs)))},
+			})
+			if artifactType == "application/vnd.example.sig" {
+				sigs = append(sigs, desc.Digest)
+			}
+		}
+		sort.Slice(sigs, func(i, j int) bool {
+			return sigs[i] < sigs[j]
+		})
+		descs, err := r.Referrers(ctx, "repo", subject, "application/vnd.example.sig")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []digest.Digest
+		for _, desc := range descs {
+			got = append(got, desc.Digest)
+		}
+		if len(got) != len(sigs) || got[0] != sigs[0] || got[1] != sigs[1] {
+			t.Errorf("filtered by registry %v: got referrers %v; want %v", filtered, got, sigs)
+		}
+		all, err := r.Referrers(ctx, "repo", subject, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 {
+			t.Errorf("filtered by registry %v: got %d referrers with no filter; want 3", filtered, len(all))
+		}
+	}
+}
@@ -0,0 +1,573 @@
+package orasflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// LayoutRepoAnnotation is the annotation used in the index of an image
+// layout written by [LayoutRegistry] to record the repository
+// that each entry belongs to.
+const LayoutRepoAnnotation = "org.cue-exp.oras.repository"
+
+// The names of the index file and blobs directory in an image layout.
+// The version of image-spec we're using does not define these.
+const (
+	layoutIndexFile = "index.json"
+	layoutBlobsDir  = "blobs"
+)
+
+// LayoutRegistry is a [Registry] that stores everything pushed to it
+// in an OCI image layout on the local filesystem instead of
+// talking to a real registry.
+//
+// The layout's index lists each tag, with the standard
+// org.opencontainers.image.ref.name annotation, and each untagged
+// manifest or index that no other manifest refers to. Everything
+// else in the layout is found by following those entries.
+// An image layout holds a single repository, so LayoutRegistry
+// also records the repository of each entry with the
+// [LayoutRepoAnnotation] annotation. Blobs that no manifest
+// refers to aren't recorded in the index, so they're lost
+// when the layout is opened again.
+//
+// The index is only written when Close is called.
+type LayoutRegistry struct {
+	dir string
+
+	mu       sync.Mutex
+	contents map[repoRef]ocispec.Descriptor // keyed by repository and digest
+	tags     map[repoRef]ocispec.Descriptor // keyed by repository and tag
+}
+
+// repoRef holds a reference (a digest or a tag) within a repository.
+type repoRef struct {
+	repo string
+	ref  string
+}
+
+// OpenLayout returns a LayoutRegistry that stores its content
+// in the image layout in dir, creating the layout if needed.
+// If dir already holds a layout, its contents are available
+// from the returned registry.
+func OpenLayout(dir string) (*LayoutRegistry, error) {
+	r := &LayoutRegistry{
+		dir:      dir,
+		contents: make(map[repoRef]ocispec.Descriptor),
+		tags:     make(map[repoRef]ocispec.Descriptor),
+	}
+	data, err := os.ReadFile(filepath.Join(dir, layoutIndexFile))
+	if err == nil {
+		var index ocispec.Index
+		if err := json.Unmarshal(data, &index); err != nil {
+			return nil, fmt.Errorf("invalid image layout index: %v", err)
+		}
+		for _, desc := range index.Manifests {
+			repo := desc.Annotations[LayoutRepoAnnotation]
+			tag := desc.Annotations[ocispec.AnnotationRefName]
+			desc = trimDesc(desc)
+			if _, err := os.Stat(r.blobPath(desc.Digest)); err != nil {
+				return nil, fmt.Errorf("invalid image layout: %v", err)
+			}
+			if tag != "" {
+				r.tags[repoRef{repo, tag}] = desc
+			}
+			if err := r.addTree(repo, desc); err != nil {
+				return nil, err
+			}
+		}
+		return r, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, layoutBlobsDir), 0o777); err != nil {
+		return nil, err
+	}
+	layout, _ := json.Marshal(ocispec.ImageLayout{
+		Version: ocispec.ImageLayoutVersion,
+	})
+	if err := os.WriteFile(filepath.Join(dir, ocispec.ImageLayoutFile), layout, 0o666); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// addTree records desc and, if it's a manifest, everything
+// it refers to, recursively, as being in repo. Content whose
+// file isn't in the layout is left out.
+func (r *LayoutRegistry) addTree(repo string, desc ocispec.Descriptor) error {
+	k := repoRef{repo, string(desc.Digest)}
+	if _, ok := r.contents[k]; ok {
+		return nil
+	}
+	file := r.blobPath(desc.Digest)
+	if _, err := os.Stat(file); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	r.contents[k] = trimDesc(desc)
+	if !ociregistry.IsManifest(desc.MediaType) {
+		return nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	for _, child := range manifestChildren(data) {
+		if err := r.addTree(repo, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close writes the index of the image layout.
+func (r *LayoutRegistry) Close() error {
+	index, err := r.index()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(r.dir, layoutIndexFile), data, 0o666)
+}
+
+// index returns the index of the layout. Entries are sorted
+// by repository, then untagged manifests before tags.
+func (r *LayoutRegistry) index() (ocispec.Index, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// Tagged manifests and those that other manifests
+	// in their repository refer to aren't listed
+	// on their own.
+	omit := make(map[repoRef]bool)
+	for k, desc := range r.tags {
+		omit[repoRef{k.repo, string(desc.Digest)}] = true
+	}
+	for k, desc := range r.contents {
+		if !ociregistry.IsManifest(desc.MediaType) {
+			continue
+		}
+		data, err := os.ReadFile(r.blobPath(desc.Digest))
+		if err != nil {
+			return ocispec.Index{}, err
+		}
+		for _, child := range manifestChildren(data) {
+			if child.Digest != desc.Digest {
+				omit[repoRef{k.repo, string(child.Digest)}] = true
+			}
+		}
+	}
+	type entry struct {
+		repo  string
+		order int
+		ref   string
+		desc  ocispec.Descriptor
+	}
+	var entries []entry
+	for k, desc := range r.contents {
+		if ociregistry.IsManifest(desc.MediaType) && !omit[k] {
+			entries = append(entries, entry{k.repo, 0, k.ref, desc})
+		}
+	}
+	for k, desc := range r.tags {
+		entries = append(entries, entry{k.repo, 1, k.ref, desc})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		e0, e1 := entries[i], entries[j]
+		if e0.repo != e1.repo {
+			return e0.repo < e1.repo
+		}
+		if e0.order != e1.order {
+			return e0.order < e1.order
+		}
+		return e0.ref < e1.ref
+	})
+	index := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: make([]ocispec.Descriptor, 0, len(entries)),
+	}
+	index.SchemaVersion = 2
+	for _, e := range entries {
+		desc := e.desc
+		desc.Annotations = map[string]string{
+			LayoutRepoAnnotation: e.repo,
+		}
+		if e.order == 1 {
+			desc.Annotations[ocispec.AnnotationRefName] = e.ref
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+	return index, nil
+}
+
+// WriteTar writes the image layout to w as a tar archive.
+// Only content that's in one of the layout's repositories
+// is included.
+// The archive is deterministic: the same content
+// always results in the same bytes.
+// It should be called after Close.
+func (r *LayoutRegistry) WriteTar(w io.Writer) error {
+	index, err := os.ReadFile(filepath.Join(r.dir, layoutIndexFile))
+	if err != nil {
+		return err
+	}
+	layout, err := os.ReadFile(filepath.Join(r.dir, ocispec.ImageLayoutFile))
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	writeFile := func(name string, size int64, content io.Reader) error {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0o444,
+			ModTime:  time.Unix(0, 0),
+			Format:   tar.FormatPAX,
+		}); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, content)
+		return err
+	}
+	if err := writeFile(ocispec.ImageLayoutFile, int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+	if err := writeFile(layoutIndexFile, int64(len(index)), bytes.NewReader(index)); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	digests := make(map[digest.Digest]int64)
+	for _, desc := range r.contents {
+		digests[desc.Digest] = desc.Size
+	}
+	r.mu.Unlock()
+	sorted := make([]digest.Digest, 0, len(digests))
+	for dig := range digests {
+		sorted = append(sorted, dig)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	for _, dig := range sorted {
+		f, err := os.Open(r.blobPath(dig))
+		if err != nil {
+			return err
+		}
+		err = writeFile(path.Join(layoutBlobsDir, dig.Algorithm().String(), dig.Encoded()), digests[dig], f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ExtractLayoutTar extracts an image layout written by
+// [LayoutRegistry.WriteTar] into dir, which is created
+// if needed. Files other than those that can be
+// part of an image layout are rejected.
+func ExtractLayoutTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag == tar.TypeDir {
+			continue
+		}
+		if h.Typeflag != tar.TypeReg {
+			return fmt.Errorf("unexpected file type for %q in image layout", h.Name)
+		}
+		name := path.Clean(h.Name)
+		if !isLayoutFile(name) {
+			return fmt.Errorf("unexpected file %q in image layout", h.Name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o777); err != nil {
+			return err
+		}
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func isLayoutFile(name string) bool {
+	if name == ocispec.ImageLayoutFile || name == layoutIndexFile {
+		return true
+	}
+	alg, encoded, ok := strings.Cut(strings.TrimPrefix(name, layoutBlobsDir+"/"), "/")
+	if !ok || !strings.HasPrefix(name, layoutBlobsDir+"/") {
+		return false
+	}
+	return digest.NewDigestFromEncoded(digest.Algorithm(alg), encoded).Validate() == nil
+}
+
+// CopyTo copies the content listed in the layout's index to dst,
+// along with everything it refers to, preserving repositories,
+// digests and tags.
+// Content that already exists in dst is not pushed again.
+func (r *LayoutRegistry) CopyTo(ctx context.Context, dst Registry) error {
+	index, err := r.index()
+	if err != nil {
+		return err
+	}
+	pusher := newPusher(dst, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	src := newOCIRegistry(r)
+	for _, desc := range index.Manifests {
+		repo := desc.Annotations[LayoutRepoAnnotation]
+		tag := desc.Annotations[ocispec.AnnotationRefName]
+		desc.Annotations = nil
+		if ociregistry.IsManifest(desc.MediaType) {
+			if _, err := ociregistry.Copy(ctx, newOCIRegistry(pusher), repo, src, repo, desc.Digest, false); err != nil {
+				return fmt.Errorf("cannot copy manifest %s to %q: %v", desc.Digest, repo, err)
+			}
+		} else {
+			// Only a tag can refer to a blob.
+			rc, err := r.Fetch(ctx, repo, desc)
+			if err != nil {
+				return err
+			}
+			err = pusher.Push(ctx, repo, desc, rc)
+			rc.Close()
+			if err != nil {
+				return fmt.Errorf("cannot push blob %s to %q: %v", desc.Digest, repo, err)
+			}
+		}
+		if tag == "" {
+			continue
+		}
+		if err := dst.Tag(ctx, repo, desc, tag); err != nil {
+			return fmt.Errorf("cannot tag %s in %q as %q: %v", desc.Digest, repo, tag, err)
+		}
+	}
+	return nil
+}
+
+func (r *LayoutRegistry) blobPath(dig digest.Digest) string {
+	return filepath.Join(r.dir, layoutBlobsDir, dig.Algorithm().String(), dig.Encoded())
+}
+
+// Push implements [Registry.Push].
+func (r *LayoutRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	if err := r.writeBlob(desc, content); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.contents[repoRef{repoName, string(desc.Digest)}] = trimDesc(desc)
+	return nil
+}
+
+// PushManifest implements [Registry.PushManifest].
+// Referrers are found by looking at the manifests
+// in the layout, so there's no referrers index to update.
+func (r *LayoutRegistry) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	return r.Push(ctx, repoName, desc, content)
+}
+
+// writeBlob writes the given content to its file in the layout,
+// checking that it matches desc.
+func (r *LayoutRegistry) writeBlob(desc ocispec.Descriptor, content io.Reader) error {
+	if err := desc.Digest.Validate(); err != nil {
+		return fmt.Errorf("invalid digest %q: %v", desc.Digest, err)
+	}
+	file := r.blobPath(desc.Digest)
+	if _, err := os.Stat(file); err == nil {
+		// The content is pushed again, so GC's grace
+		// period starts again. It's not an error if the
+		// time can't be changed.
+		now := time.Now()
+		os.Chtimes(file, now, now)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o777); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(file), "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	verifier := desc.Digest.Verifier()
+	n, err := io.Copy(io.MultiWriter(f, verifier), content)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	if n != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("content does not match descriptor (digest %s, size %d)", desc.Digest, desc.Size)
+	}
+	return os.Rename(f.Name(), file)
+}
+
+// trimDesc returns desc with only the fields
+// that identify its content.
+func trimDesc(desc ocispec.Descriptor) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType:    desc.MediaType,
+		ArtifactType: desc.ArtifactType,
+		Digest:       desc.Digest,
+		Size:         desc.Size,
+	}
+}
+
+// Tag implements [Registry.Tag].
+func (r *LayoutRegistry) Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.contents[repoRef{repoName, string(desc.Digest)}]
+	if !ok {
+		return fmt.Errorf("cannot tag %s: not found in repository %q", desc.Digest, repoName)
+	}
+	r.tags[repoRef{repoName, reference}] = stored
+	return nil
+}
+
+// Referrers implements [Registry.Referrers].
+func (r *LayoutRegistry) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	r.mu.Lock()
+	var manifests []ocispec.Descriptor
+	for k, desc := range r.contents {
+		if k.repo == repoName && ociregistry.IsManifest(desc.MediaType) {
+			manifests = append(manifests, desc)
+		}
+	}
+	r.mu.Unlock()
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].Digest < manifests[j].Digest
+	})
+	var referrers []ocispec.Descriptor
+	for _, m := range manifests {
+		data, err := os.ReadFile(r.blobPath(m.Digest))
+		if err != nil {
+			return nil, err
+		}
+		var content struct {
+			ArtifactType string              `json:"artifactType"`
+			Config       ocispec.Descriptor  `json:"config"`
+			Subject      *ocispec.Descriptor `json:"subject"`
+		}
+		if err := json.Unmarshal(data, &content); err != nil || content.Subject == nil || content.Subject.Digest != desc.Digest {
+			continue
+		}
+		if m.ArtifactType = content.ArtifactType; m.ArtifactType == "" {
+			m.ArtifactType = content.Config.MediaType
+		}
+		if artifactType == "" || m.ArtifactType == artifactType {
+			referrers = append(referrers, m)
+		}
+	}
+	return referrers, nil
+}
+
+// Resolve implements [Registry.Resolve].
+func (r *LayoutRegistry) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if desc, ok := r.tags[repoRef{repoName, reference}]; ok {
+		return desc, nil
+	}
+	if desc, ok := r.contents[repoRef{repoName, reference}]; ok {
+		return desc, nil
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("%s:%s: %w", repoName, reference, fs.ErrNotExist)
+}
+
+// Fetch implements [Registry.Fetch].
+func (r *LayoutRegistry) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	if ok, _ := r.Exists(ctx, repoName, desc); !ok {
+		return nil, fmt.Errorf("%s@%s: %w", repoName, desc.Digest, fs.ErrNotExist)
+	}
+	return os.Open(r.blobPath(desc.Digest))
+}
+
+// FetchManifest implements [Registry.FetchManifest].
+func (r *LayoutRegistry) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	return r.Fetch(ctx, repoName, desc)
+}
+
+// Tags implements [Registry.Tags].
+func (r *LayoutRegistry) Tags(ctx context.Context, repoName string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []string
+	for k := range r.tags {
+		if k.repo == repoName {
+			tags = append(tags, k.ref)
+		}
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// Exists implements [Exister].
+func (r *LayoutRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.contents[repoRef{repoName, string(desc.Digest)}]
+	return ok, nil
+}
+
+// DeleteTag implements [Deleter.DeleteTag].
+func (r *LayoutRegistry) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tags, repoRef{repoName, tag})
+	return nil
+}
+
+// DeleteManifest implements [Deleter.DeleteManifest].
+// The manifest is removed from the index, along with any tags that
+// refer to it, but its file is left in place.
+func (r *LayoutRegistry) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.contents, repoRef{repoName, string(desc.Digest)})
+	for k, tagDesc := range r.tags {
+		if k.repo == repoName && tagDesc.Digest == desc.Digest {
+			delete(r.tags, k)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+package orasflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"cuelang.org/go/cue"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// layoutConfig pushes an index of two images, tagged in repository a,
+// and an untagged image with a referrer in repository b.
+const layoutConfig = `
+package test
+
+import "github.com/cue-exp/oras"
+
+scratch: [repo=_]: oras.#repoBlob & {
+	"repo": repo
+	desc:   oras.scratchConfig
+	source: {}
+}
+scratch: {a: _, b: _}
+
+images: [name=_]: {
+	repo: *"a" | string
+	content: oras.#repoBlob & {
+		"repo": repo
+		desc: mediaType: "text/plain"
+		source: name
+	}
+	manifest: oras.#repoManifest & {
+		"repo": repo
+		manifest: {
+			mediaType:    _
+			artifactType: "application/x-test"
+			config:       scratch[repo].desc
+			layers: [content.desc]
+		}
+	}
+}
+images: {
+	amd64: _
+	arm64: _
+	untagged: repo: "b"
+}
+
+index: oras.#repoIndex & {
+	repo: "a"
+	manifests: [images.amd64.manifest.desc, images.arm64.manifest.desc]
+}
+
+tag: oras.#repoTag & {
+	repo: "a"
+	name: "latest"
+	desc: index.desc
+}
+
+signature: oras.#repoManifest & {
+	repo: "b"
+	manifest: {
+		mediaType:    _
+		artifactType: "application/x-signature"
+		config:       scratch.b.desc
+		layers: []
+		subject: images.untagged.manifest.desc
+	}
+}
+`
+
+func TestLayoutRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	v, dir := loadConfig(t, "testdata/layout", layoutConfig)
+	layoutDir := t.TempDir()
+	r, err := OpenLayout(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Apply(ctx, v, r, &Options{Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events := applyEvents(t, v, dir)
+
+	// The index only holds the tag and the manifests
+	// that nothing else refers to.
+	data, err := os.ReadFile(filepath.Join(layoutDir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, desc := range index.Manifests {
+		got = append(got, desc.Annotations[LayoutRepoAnnotation]+" "+desc.Annotations[ocispec.AnnotationRefName]+" "+string(desc.Digest))
+		if len(desc.Annotations) > 2 {
+			t.Errorf("unexpected annotations %v", desc.Annotations)
+		}
+	}
+	want := []string{
+		"a latest " + string(events["index"].Digest),
+	}
+	b := []string{
+		"b  " + string(events["signature"].Digest),
+		"b  " + string(events["images.untagged.manifest"].Digest),
+	}
+	sort.Strings(b)
+	want = append(want, b...)
+	if !equalStrings(got, want) {
+		t.Errorf("unexpected index entries\ngot  %q\nwant %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := r.WriteTar(&buf); err != nil {
+		t.Fatal(err)
+	}
+	extracted := t.TempDir()
+	if err := ExtractLayoutTar(bytes.NewReader(buf.Bytes()), extracted); err != nil {
+		t.Fatal(err)
+	}
+	r, err = OpenLayout(extracted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := ociregistry.NewMemRegistry()
+	if err := r.CopyTo(ctx, RegistryFromInterface(mr)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Everything that was pushed is in the registry.
+	for task, ev := range events {
+		if ev.Repo == "" || ev.Digest == "" {
+			continue
+		}
+		var err error
+		if ociregistry.IsManifest(ev.MediaType) {
+			_, err = mr.GetManifest(ctx, ev.Repo, ev.Digest)
+		} else {
+			_, err = mr.GetBlob(ctx, ev.Repo, ev.Digest)
+		}
+		if err != nil {
+			t.Errorf("%s: %v", task, err)
+		}
+	}
+	desc, err := mr.GetTag(ctx, "a", "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := desc.Descriptor().Digest, events["index"].Digest; got != want {
+		t.Errorf("latest is %s; want %s", got, want)
+	}
+	if tags, err := ociregistry.All(mr.Tags(ctx, "b", nil)); err != nil || len(tags) != 0 {
+		t.Errorf("got tags %q, %v in b; want none", tags, err)
+	}
+
+	// Writing the extracted layout again gives the same archive.
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var buf1 bytes.Buffer
+	if err := r.WriteTar(&buf1); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf1.Bytes(), buf.Bytes()) {
+		t.Errorf("archive changed after round trip")
+	}
+}
+
+func TestOpenLayoutMissingBlob(t *testing.T) {
+	dir := t.TempDir()
+	r, err := OpenLayout(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := pushLayoutBlob(t, r, "repo", "application/vnd.oci.empty.v1+json", "{}")
+	m := pushLayoutManifest(t, r, "repo", config)
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(r.blobPath(m.Digest)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenLayout(dir); err == nil {
+		t.Fatalf("no error opening layout with missing manifest")
+	}
+}
+
+// applyEvents applies v to an in-memory registry
+// and returns the events of its tasks by task path.
+func applyEvents(t *testing.T, v cue.Value, dir string) map[string]Event {
+	t.Helper()
+	events := make(map[string]Event)
+	err := Apply(context.Background(), v, RegistryFromInterface(ociregistry.NewMemRegistry()), &Options{
+		Dir: dir,
+		Event: func(ev Event) {
+			events[ev.Task.String()] = ev
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
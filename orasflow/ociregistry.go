@@ -0,0 +1,185 @@
+package orasflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// ociRegistry adapts a Registry to the ociregistry interfaces
+// so that it can be used with functions such as [ociregistry.Copy].
+//
+// The ociregistry interfaces refer to blobs by digest only,
+// but a Registry needs the full descriptor, so ociRegistry
+// remembers the descriptors found in each manifest it
+// reads and only allows blobs to be read once a manifest
+// referring to them has been read.
+type ociRegistry struct {
+	r Registry
+
+	mu    sync.Mutex
+	descs map[digest.Digest]ocispec.Descriptor
+}
+
+func newOCIRegistry(r Registry) *ociRegistry {
+	return &ociRegistry{
+		r:     r,
+		descs: make(map[digest.Digest]ocispec.Descriptor),
+	}
+}
+
+var (
+	_ ociregistry.ReadWriter = (*ociRegistry)(nil)
+	_ ociregistry.Lister     = (*ociRegistry)(nil)
+)
+
+func (r *ociRegistry) GetBlob(ctx context.Context, repo string, dig ociregistry.Digest) (ociregistry.BlobReader, error) {
+	desc, ok := r.desc(dig)
+	if !ok {
+		return nil, fmt.Errorf("blob %s is not referred to by any known manifest", dig)
+	}
+	data, err := readAll(r.r.Fetch(ctx, repo, desc))
+	if err != nil {
+		return nil, err
+	}
+	return ociregistry.BytesBlob(data, desc.MediaType), nil
+}
+
+func (r *ociRegistry) GetManifest(ctx context.Context, repo string, dig ociregistry.Digest) (ociregistry.BlobReader, error) {
+	return r.getManifest(ctx, repo, string(dig))
+}
+
+func (r *ociRegistry) GetTag(ctx context.Context, repo string, tagName string) (ociregistry.BlobReader, error) {
+	return r.getManifest(ctx, repo, tagName)
+}
+
+func (r *ociRegistry) getManifest(ctx context.Context, repo string, reference string) (ociregistry.BlobReader, error) {
+	desc, err := r.r.Resolve(ctx, repo, reference)
+	if err != nil {
+		return nil, err
+	}
+	data, err := readAll(r.r.FetchManifest(ctx, repo, desc))
+	if err != nil {
+		return nil, err
+	}
+	r.addDescs(data)
+	r.mu.Lock()
+	r.descs[desc.Digest] = desc
+	r.mu.Unlock()
+	return ociregistry.BytesBlob(data, desc.MediaType), nil
+}
+
+func (r *ociRegistry) PushBlob(ctx context.Context, repo string, c ociregistry.BlobReader, desc ociregistry.Descriptor) (ociregistry.Descriptor, error) {
+	rc := c.Open()
+	defer rc.Close()
+	if err := r.r.Push(ctx, repo, desc, rc); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (r *ociRegistry) PushManifest(ctx context.Context, repo string, c ociregistry.BlobReader, desc ociregistry.Descriptor) (ociregistry.Descriptor, error) {
+	rc := c.Open()
+	defer rc.Close()
+	if err := r.r.PushManifest(ctx, repo, desc, rc); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (r *ociRegistry) Mount(ctx context.Context, repo string, fromRepo string, dig ociregistry.Digest) error {
+	return fmt.Errorf("mount not supported")
+}
+
+func (r *ociRegistry) Tag(ctx context.Context, repo string, dig ociregistry.Digest, tag string) error {
+	desc, ok := r.desc(dig)
+	if !ok {
+		return fmt.Errorf("manifest %s has not been read", dig)
+	}
+	return r.r.Tag(ctx, repo, desc, tag)
+}
+
+func (r *ociRegistry) Repositories(ctx context.Context, opts *ociregistry.ListOptions) ociregistry.Iter[string] {
+	return ociregistry.ErrorIter[string](fmt.Errorf("listing repositories not supported"))
+}
+
+func (r *ociRegistry) Tags(ctx context.Context, repo string, opts *ociregistry.ListOptions) ociregistry.Iter[string] {
+	tags, err := r.r.Tags(ctx, repo)
+	if err != nil {
+		return ociregistry.ErrorIter[string](err)
+	}
+	sort.Strings(tags)
+	return ociregistry.SliceIter(ociregistry.SelectNames(tags, opts))
+}
+
+func (r *ociRegistry) Referrers(ctx context.Context, repo string, dig ociregistry.Digest, opts *ociregistry.ReferrersOptions) ociregistry.Iter[ociregistry.Descriptor] {
+	desc, ok := r.desc(dig)
+	if !ok {
+		desc = ocispec.Descriptor{
+			Digest: dig,
+		}
+	}
+	var artifactType string
+	if opts != nil {
+		artifactType = opts.ArtifactType
+	}
+	descs, err := r.r.Referrers(ctx, repo, desc, artifactType)
+	if err != nil {
+		return ociregistry.ErrorIter[ociregistry.Descriptor](err)
+	}
+	if artifactType != "" {
+		// Registry.Referrers filters the results itself
+		// when the server doesn't.
+		return ociregistry.FilteredIter(ociregistry.SliceIter(descs), "artifactType")
+	}
+	return ociregistry.SliceIter(descs)
+}
+
+func (r *ociRegistry) desc(dig digest.Digest) (ocispec.Descriptor, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	desc, ok := r.descs[dig]
+	return desc, ok
+}
+
+// addDescs records all the descriptors found in the
+// given manifest data.
+func (r *ociRegistry) addDescs(data []byte) {
+	children := manifestChildren(data)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, desc := range children {
+		r.descs[desc.Digest] = desc
+	}
+}
+
+// manifestChildren returns the descriptors of all the content
+// directly referred to by the manifest or index with the given
+// data, not including its subject. It returns nil if the data
+// isn't valid JSON.
+func manifestChildren(data []byte) []ocispec.Descriptor {
+	var m struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+		Blobs     []ocispec.Descriptor `json:"blobs"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil
+	}
+	var children []ocispec.Descriptor
+	if m.Config != nil {
+		children = append(children, *m.Config)
+	}
+	children = append(children, m.Layers...)
+	children = append(children, m.Blobs...)
+	children = append(children, m.Manifests...)
+	return children
+}
@@ -0,0 +1,175 @@
+package orasflow
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// blobsConfig pushes eight blobs that don't depend on one another,
+// so they can all be pushed at the same time.
+const blobsConfig = `
+package test
+
+import "github.com/cue-exp/oras"
+
+blobs: [name=_]: oras.#repoBlob & {
+	repo: "blobs"
+	desc: mediaType: "text/plain"
+	source: name
+}
+blobs: {a: _, b: _, c: _, d: _, e: _, f: _, g: _, h: _}
+`
+
+var concurrencyTests = []struct {
+	testName       string
+	maxConcurrency int
+	singleThreaded bool
+	// wantMax holds the maximum number of pushes that are allowed
+	// to run at once; zero means that there's no limit, but some
+	// pushes must run at the same time.
+	wantMax int
+}{{
+	testName: "NoLimit",
+}, {
+	testName:       "MaxConcurrency",
+	maxConcurrency: 3,
+	wantMax:        3,
+}, {
+	testName:       "SingleThreaded",
+	singleThreaded: true,
+	wantMax:        1,
+}, {
+	testName:       "SingleThreadedOverridesMaxConcurrency",
+	maxConcurrency: 3,
+	singleThreaded: true,
+	wantMax:        1,
+}}
+
+func TestConcurrency(t *testing.T) {
+	v, dir := loadConfig(t, "testdata/options", blobsConfig)
+	for _, test := range concurrencyTests {
+		t.Run(test.testName, func(t *testing.T) {
+			r := &concurrencyRegistry{
+				Registry: RegistryFromInterface(ociregistry.NewMemRegistry()),
+			}
+			err := Apply(context.Background(), v, r, &Options{
+				Dir:            dir,
+				MaxConcurrency: test.maxConcurrency,
+				SingleThreaded: test.singleThreaded,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.pushes != 8 {
+				t.Fatalf("got %d pushes; want 8", r.pushes)
+			}
+			if test.wantMax == 0 {
+				if r.max < 2 {
+					t.Errorf("pushes ran one at a time; want some to run concurrently")
+				}
+				return
+			}
+			if r.max > test.wantMax {
+				t.Errorf("got %d pushes running at once; want at most %d", r.max, test.wantMax)
+			}
+		})
+	}
+}
+
+// concurrencyRegistry records the maximum number of
+// pushes that run at the same time. Each push takes long
+// enough that pushes that can overlap do.
+type concurrencyRegistry struct {
+	Registry
+
+	mu      sync.Mutex
+	running int
+	max     int
+	pushes  int
+}
+
+func (r *concurrencyRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	r.mu.Lock()
+	r.pushes++
+	r.running++
+	r.max = max(r.max, r.running)
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	return r.Registry.Push(ctx, repoName, desc, content)
+}
+
+func TestProgress(t *testing.T) {
+	var progress []Progress
+	_, err := applyConfig(t, "options", blobsConfig, &Options{
+		Progress: func(p Progress) {
+			progress = append(progress, p)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(progress) < 2 {
+		t.Fatalf("got %d progress reports; want at least 2", len(progress))
+	}
+	if p := progress[0]; p.Done != 0 {
+		t.Errorf("first progress report %+v; want no tasks done", p)
+	}
+	for i, p := range progress {
+		if p.Total != 8 {
+			t.Errorf("progress report %d: got %d tasks; want 8", i, p.Total)
+		}
+		if i > 0 && p.Done < progress[i-1].Done {
+			t.Errorf("progress report %d: done went from %d to %d", i, progress[i-1].Done, p.Done)
+		}
+	}
+	if p := progress[len(progress)-1]; p.Done != p.Total {
+		t.Errorf("last progress report %+v; want all tasks done", p)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := applyConfig(t, "options", blobsConfig, &Options{
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := buf.String()
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		for _, msg := range []string{
+			`msg="running task" task=blobs.` + name + " action=blob",
+			`msg="task done" task=blobs.` + name + " repo=blobs",
+		} {
+			if !strings.Contains(log, msg) {
+				t.Errorf("log does not contain %q; got:\n%s", msg, log)
+			}
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	// With the default logger, nothing is logged, but
+	// everything still works.
+	if _, err := applyConfig(t, "options", blobsConfig, nil); err != nil {
+		t.Fatal(err)
+	}
+}
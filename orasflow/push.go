@@ -0,0 +1,113 @@
+package orasflow
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// pusher wraps a Registry so that content that already exists
+// in the target repository is not pushed again, and blobs
+// that exist in another repository in the same registry
+// are mounted from there rather than uploaded when the
+// registry implements [Mounter].
+type pusher struct {
+	Registry
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// repos holds the repositories that each blob
+	// is known to have been pushed to.
+	repos map[digest.Digest][]string
+}
+
+func newPusher(r Registry, logger *slog.Logger) *pusher {
+	return &pusher{
+		Registry: r,
+		logger:   logger,
+		repos:    make(map[digest.Digest][]string),
+	}
+}
+
+// Push implements [Registry.Push].
+func (p *pusher) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	return p.pushBlob(ctx, repoName, desc, content, nil)
+}
+
+// pushBlob pushes a blob to repoName if it's not already there.
+// If possible, the blob is mounted from one of the repositories that
+// it's previously been pushed to or, failing that, one of the
+// repositories in mountFrom.
+func (p *pusher) pushBlob(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader, mountFrom []string) error {
+	exists, err := p.exists(ctx, repoName, desc)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		if !p.mount(ctx, repoName, desc, mountFrom) {
+			if err := p.Registry.Push(ctx, repoName, desc, content); err != nil {
+				return err
+			}
+		}
+	}
+	p.addRepo(desc.Digest, repoName)
+	return nil
+}
+
+func (p *pusher) mount(ctx context.Context, repoName string, desc ocispec.Descriptor, mountFrom []string) bool {
+	mounter, ok := p.Registry.(Mounter)
+	if !ok {
+		return false
+	}
+	p.mu.Lock()
+	candidates := append([]string(nil), p.repos[desc.Digest]...)
+	p.mu.Unlock()
+	candidates = append(candidates, mountFrom...)
+	for _, fromRepo := range candidates {
+		if fromRepo == repoName {
+			continue
+		}
+		if err := mounter.Mount(ctx, repoName, fromRepo, desc); err == nil {
+			p.logger.Debug("mounted blob", "digest", desc.Digest, "from", fromRepo, "repo", repoName)
+			return true
+		}
+	}
+	return false
+}
+
+// PushManifest implements [Registry.PushManifest].
+func (p *pusher) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	exists, err := p.exists(ctx, repoName, desc)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	return p.Registry.PushManifest(ctx, repoName, desc, content)
+}
+
+// exists reports whether the given content is already in repoName.
+// If the registry can't tell, it reports that it isn't.
+func (p *pusher) exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	exister, ok := p.Registry.(Exister)
+	if !ok {
+		return false, nil
+	}
+	return exister.Exists(ctx, repoName, desc)
+}
+
+func (p *pusher) addRepo(dig digest.Digest, repoName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, r := range p.repos[dig] {
+		if r == repoName {
+			return
+		}
+	}
+	p.repos[dig] = append(p.repos[dig], repoName)
+}
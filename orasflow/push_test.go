@@ -0,0 +1,208 @@
+package orasflow
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+// pushStep describes a push made through a pusher: a blob,
+// or a manifest if manifest is true, holding content.
+type pushStep struct {
+	repo      string
+	content   string
+	manifest  bool
+	mountFrom []string
+}
+
+var pusherTests = []struct {
+	testName string
+	// existing holds content that's pushed to the
+	// registry directly before the test starts.
+	existing []pushStep
+	// hide holds the optional interface that the registry
+	// doesn't implement: "Mounter" or "Exister".
+	hide      string
+	steps     []pushStep
+	wantCalls []string
+}{{
+	testName: "SkipExistingBlob",
+	existing: []pushStep{{repo: "x", content: "a"}},
+	steps:    []pushStep{{repo: "x", content: "a"}},
+}, {
+	testName: "SkipBlobPushedEarlier",
+	steps: []pushStep{
+		{repo: "x", content: "a"},
+		{repo: "x", content: "a"},
+	},
+	wantCalls: []string{"push x a"},
+}, {
+	testName: "SkipExistingManifest",
+	existing: []pushStep{{repo: "x", content: "{}", manifest: true}},
+	steps:    []pushStep{{repo: "x", content: "{}", manifest: true}},
+}, {
+	testName: "MountFromEarlierPush",
+	steps: []pushStep{
+		{repo: "x", content: "a"},
+		{repo: "y", content: "a"},
+	},
+	wantCalls: []string{"push x a", "mount y a from x"},
+}, {
+	testName: "MountFrom",
+	existing: []pushStep{{repo: "z", content: "a"}},
+	steps: []pushStep{
+		{repo: "y", content: "a", mountFrom: []string{"y", "z"}},
+	},
+	wantCalls: []string{"mount y a from z"},
+}, {
+	testName: "MountFails",
+	steps: []pushStep{
+		{repo: "y", content: "a", mountFrom: []string{"nowhere"}},
+	},
+	wantCalls: []string{"mount y a from nowhere", "push y a"},
+}, {
+	testName: "NoMounter",
+	hide:     "Mounter",
+	steps: []pushStep{
+		{repo: "x", content: "a"},
+		{repo: "y", content: "a"},
+		{repo: "y", content: "a"},
+	},
+	wantCalls: []string{"push x a", "push y a"},
+}, {
+	testName: "NoExister",
+	hide:     "Exister",
+	existing: []pushStep{{repo: "x", content: "{}", manifest: true}},
+	steps: []pushStep{
+		{repo: "x", content: "a"},
+		{repo: "x", content: "a"},
+		{repo: "y", content: "a"},
+		{repo: "x", content: "{}", manifest: true},
+	},
+	wantCalls: []string{"push x a", "push x a", "mount y a from x", "pushManifest x {}"},
+}}
+
+func TestPusher(t *testing.T) {
+	ctx := context.Background()
+	for _, test := range pusherTests {
+		t.Run(test.testName, func(t *testing.T) {
+			base := RegistryFromInterface(ociregistry.NewMemRegistry())
+			for _, step := range test.existing {
+				push(t, base, step)
+			}
+			rec := &recordingRegistry{
+				Registry: base,
+				names:    make(map[digest.Digest]string),
+			}
+			for _, step := range test.steps {
+				rec.names[stepDesc(step).Digest] = step.content
+			}
+			var r Registry = rec
+			switch test.hide {
+			case "Mounter":
+				r = struct {
+					Registry
+					Exister
+				}{rec, rec}
+			case "Exister":
+				r = struct {
+					Registry
+					Mounter
+				}{rec, rec}
+			}
+			p := newPusher(r, slog.New(slog.NewTextHandler(io.Discard, nil)))
+			for _, step := range test.steps {
+				desc := stepDesc(step)
+				var err error
+				if step.manifest {
+					err = p.PushManifest(ctx, step.repo, desc, strings.NewReader(step.content))
+				} else {
+					err = p.pushBlob(ctx, step.repo, desc, strings.NewReader(step.content), step.mountFrom)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Whichever way it got there, the content
+				// must now be in the repository.
+				if ok, err := base.(Exister).Exists(ctx, step.repo, desc); err != nil || !ok {
+					t.Errorf("%s not in %s after push: %v", step.content, step.repo, err)
+				}
+			}
+			if strings.Join(rec.calls, "\n") != strings.Join(test.wantCalls, "\n") {
+				t.Errorf("got calls:\n%s\nwant:\n%s", strings.Join(rec.calls, "\n"), strings.Join(test.wantCalls, "\n"))
+			}
+		})
+	}
+}
+
+func stepDesc(step pushStep) ocispec.Descriptor {
+	mediaType := "text/plain"
+	if step.manifest {
+		mediaType = ocispec.MediaTypeImageManifest
+	}
+	return ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromString(step.content),
+		Size:      int64(len(step.content)),
+	}
+}
+
+func push(t *testing.T, r Registry, step pushStep) {
+	t.Helper()
+	var err error
+	if step.manifest {
+		err = r.PushManifest(context.Background(), step.repo, stepDesc(step), strings.NewReader(step.content))
+	} else {
+		err = r.Push(context.Background(), step.repo, stepDesc(step), strings.NewReader(step.content))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// recordingRegistry records the pushes and mounts made
+// through it, naming content by its text, before passing
+// them on to its Registry, which must implement [Mounter]
+// and [Exister].
+type recordingRegistry struct {
+	Registry
+	// names maps the digests of the blobs
+	// that may be mounted to their text.
+	names map[digest.Digest]string
+	calls []string
+}
+
+func (r *recordingRegistry) Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	r.calls = append(r.calls, "push "+repoName+" "+string(data))
+	return r.Registry.Push(ctx, repoName, desc, bytes.NewReader(data))
+}
+
+func (r *recordingRegistry) PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error {
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	r.calls = append(r.calls, "pushManifest "+repoName+" "+string(data))
+	return r.Registry.PushManifest(ctx, repoName, desc, bytes.NewReader(data))
+}
+
+func (r *recordingRegistry) Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error {
+	r.calls = append(r.calls, "mount "+repoName+" "+r.names[desc.Digest]+" from "+fromRepo)
+	return r.Registry.(Mounter).Mount(ctx, repoName, fromRepo, desc)
+}
+
+func (r *recordingRegistry) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	return r.Registry.(Exister).Exists(ctx, repoName, desc)
+}
@@ -2,12 +2,14 @@ package orasflow
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"oras.land/oras-go/v2/registry/remote"
+	"oras.land/oras-go/v2/registry/remote/auth"
 )
 
 func RegistryFromRemote(r *remote.Registry) Registry {
@@ -18,10 +20,75 @@ func RegistryFromRemote(r *remote.Registry) Registry {
 // It's defined like this so that the required API surface area is clear
 // and it's easier to implement.
 type Registry interface {
+	// Push pushes the blob with the given descriptor.
 	Push(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error
+
+	// PushManifest pushes a manifest. If the manifest has a subject
+	// field, the implementation is responsible for making it visible
+	// to Referrers, either with the registry's referrers API
+	// or by falling back to the referrers tag schema.
 	PushManifest(ctx context.Context, repoName string, desc ocispec.Descriptor, content io.Reader) error
+
+	// Tag tags the manifest with the given descriptor, which has
+	// already been pushed. The whole descriptor is passed rather than
+	// just its digest because some backends (oras-go, and the scripts
+	// written by oras-apply) need the media type to fetch the manifest.
+	// Backends that identify manifests by digest alone can use desc.Digest.
 	Tag(ctx context.Context, repoName string, desc ocispec.Descriptor, reference string) error
-	Dump(ctx context.Context, stuff json.RawMessage)
+
+	// Referrers returns the descriptors of all the manifests
+	// that have the given descriptor as their subject.
+	// If artifactType is non-empty, only referrers with that
+	// artifact type are returned.
+	Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error)
+
+	// Resolve returns the descriptor of the manifest
+	// with the given reference, which may be a tag or a digest.
+	Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error)
+
+	// Fetch returns the content of the blob with the given descriptor.
+	Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error)
+
+	// FetchManifest returns the content of the manifest
+	// with the given descriptor.
+	FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error)
+
+	// Tags returns all the tags in the given repository.
+	Tags(ctx context.Context, repoName string) ([]string, error)
+}
+
+// Deleter is implemented by registries that allow content to be deleted.
+// Apply refuses to run a configuration that contains delete tasks
+// unless its registry implements Deleter, so a caller can
+// prevent deletion by hiding these methods.
+type Deleter interface {
+	// DeleteTag removes the given tag from the repository.
+	// The manifest that it refers to is not deleted.
+	DeleteTag(ctx context.Context, repoName string, tag string) error
+
+	// DeleteManifest deletes the given manifest
+	// from the repository.
+	DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error
+}
+
+// Mounter is implemented by registries that can make a blob
+// that's already in one repository available in another
+// without uploading it again.
+type Mounter interface {
+	// Mount makes the blob with the given descriptor in fromRepo
+	// available in repoName. It returns an error if the blob could
+	// not be mounted, for example because it does not exist in fromRepo.
+	Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error
+}
+
+// Exister is implemented by registries that can report whether
+// content is already present. Apply skips pushing content that
+// the registry reports as present; if the registry doesn't
+// implement Exister, everything is pushed.
+type Exister interface {
+	// Exists reports whether the blob or manifest
+	// with the given descriptor exists in the repository.
+	Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error)
 }
 
 type registryShim struct {
@@ -52,5 +119,150 @@ func (r registryShim) Tag(ctx context.Context, repoName string, desc ocispec.Des
 	return repo.Tag(ctx, desc, reference)
 }
 
-func (r registryShim) Dump(ctx context.Context, stuff json.RawMessage) {
+func (r registryShim) Referrers(ctx context.Context, repoName string, desc ocispec.Descriptor, artifactType string) ([]ocispec.Descriptor, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	var descs []ocispec.Descriptor
+	if err := repo.Referrers(ctx, desc, artifactType, func(referrers []ocispec.Descriptor) error {
+		descs = append(descs, referrers...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return descs, nil
+}
+
+func (r registryShim) Resolve(ctx context.Context, repoName string, reference string) (ocispec.Descriptor, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	return repo.Manifests().Resolve(ctx, reference)
+}
+
+func (r registryShim) Fetch(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	return repo.Blobs().Fetch(ctx, desc)
+}
+
+func (r registryShim) FetchManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	return repo.Manifests().Fetch(ctx, desc)
+}
+
+func (r registryShim) Tags(ctx context.Context, repoName string) ([]string, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	var tags []string
+	if err := repo.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+func (r registryShim) Exists(ctx context.Context, repoName string, desc ocispec.Descriptor) (bool, error) {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return false, fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	return repo.Exists(ctx, desc)
+}
+
+func (r registryShim) DeleteManifest(ctx context.Context, repoName string, desc ocispec.Descriptor) error {
+	repo, err := r.r.Repository(ctx, repoName)
+	if err != nil {
+		return fmt.Errorf("cannot make repository from %q: %v", repoName, err)
+	}
+	return repo.Manifests().Delete(ctx, desc)
+}
+
+// DeleteTag implements [Deleter.DeleteTag]. The oras-go API
+// only supports deleting by digest, so we make the request ourselves.
+func (r registryShim) DeleteTag(ctx context.Context, repoName string, tag string) error {
+	ctx = auth.AppendScopes(ctx, auth.ScopeRepository(repoName, auth.ActionDelete))
+	resp, err := r.do(ctx, http.MethodDelete, r.repoURL(repoName)+"/manifests/"+url.PathEscape(tag))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("cannot delete tag: unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
+// Mount implements [Mounter.Mount]. The oras-go API
+// does not support mounting, so we make the request ourselves.
+func (r registryShim) Mount(ctx context.Context, repoName string, fromRepo string, desc ocispec.Descriptor) error {
+	ctx = auth.AppendScopes(ctx,
+		auth.ScopeRepository(repoName, auth.ActionPull, auth.ActionPush),
+		auth.ScopeRepository(fromRepo, auth.ActionPull),
+	)
+	q := url.Values{
+		"mount": {string(desc.Digest)},
+		"from":  {fromRepo},
+	}
+	resp, err := r.do(ctx, http.MethodPost, r.repoURL(repoName)+"/blobs/uploads/?"+q.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusAccepted {
+		// The registry cannot mount the blob, so it has started
+		// a regular upload session instead. We don't want it.
+		r.cancelUpload(ctx, resp)
+		return fmt.Errorf("cannot mount blob: registry started an upload instead")
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("cannot mount blob: unexpected response %s", resp.Status)
+	}
+	return nil
+}
+
+// cancelUpload cancels the upload session started by the request
+// that produced resp, so that the registry doesn't have to wait for
+// it to expire. Cancellation is only a courtesy, so errors are ignored.
+func (r registryShim) cancelUpload(ctx context.Context, resp *http.Response) {
+	loc, err := resp.Location()
+	if err != nil {
+		return
+	}
+	resp, err = r.do(ctx, http.MethodDelete, loc.String())
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func (r registryShim) repoURL(repoName string) string {
+	scheme := "https"
+	if r.r.PlainHTTP {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, r.r.Reference.Host(), repoName)
+}
+
+func (r registryShim) do(ctx context.Context, method string, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := r.r.Client
+	if client == nil {
+		client = auth.DefaultClient
+	}
+	return client.Do(req)
 }
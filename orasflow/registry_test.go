@@ -0,0 +1,112 @@
+package orasflow
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+var mountTests = []struct {
+	testName string
+	// status holds the status of the response to the mount request.
+	status int
+	// location holds its Location header.
+	location     string
+	wantErr      string
+	wantRequests []string
+}{{
+	testName: "Mounted",
+	status:   http.StatusCreated,
+	wantRequests: []string{
+		"POST /v2/repo/blobs/uploads/?from=other&mount=" + string(mountDigest),
+	},
+}, {
+	testName: "UploadStarted",
+	status:   http.StatusAccepted,
+	location: "/v2/repo/blobs/uploads/session1",
+	wantErr:  "cannot mount blob: registry started an upload instead",
+	wantRequests: []string{
+		"POST /v2/repo/blobs/uploads/?from=other&mount=" + string(mountDigest),
+		"DELETE /v2/repo/blobs/uploads/session1",
+	},
+}, {
+	testName: "UploadStartedAbsoluteLocation",
+	status:   http.StatusAccepted,
+	location: "{server}/v2/repo/blobs/uploads/session2?state=x",
+	wantErr:  "cannot mount blob: registry started an upload instead",
+	wantRequests: []string{
+		"POST /v2/repo/blobs/uploads/?from=other&mount=" + string(mountDigest),
+		"DELETE /v2/repo/blobs/uploads/session2?state=x",
+	},
+}, {
+	testName: "UploadStartedNoLocation",
+	status:   http.StatusAccepted,
+	wantErr:  "cannot mount blob: registry started an upload instead",
+	wantRequests: []string{
+		"POST /v2/repo/blobs/uploads/?from=other&mount=" + string(mountDigest),
+	},
+}, {
+	testName: "Denied",
+	status:   http.StatusForbidden,
+	wantErr:  "cannot mount blob: unexpected response 403 Forbidden",
+	wantRequests: []string{
+		"POST /v2/repo/blobs/uploads/?from=other&mount=" + string(mountDigest),
+	},
+}}
+
+var mountDigest = digest.FromString("blob")
+
+func TestMount(t *testing.T) {
+	for _, test := range mountTests {
+		t.Run(test.testName, func(t *testing.T) {
+			var (
+				mu       sync.Mutex
+				requests []string
+			)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				uri, _ := url.QueryUnescape(req.URL.RequestURI())
+				mu.Lock()
+				requests = append(requests, req.Method+" "+uri)
+				mu.Unlock()
+				if req.Method == http.MethodDelete {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				if test.location != "" {
+					w.Header().Set("Location", strings.ReplaceAll(test.location, "{server}", "http://"+req.Host))
+				}
+				w.WriteHeader(test.status)
+			}))
+			defer srv.Close()
+			reg, err := remote.NewRegistry(strings.TrimPrefix(srv.URL, "http://"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			reg.PlainHTTP = true
+			r := RegistryFromRemote(reg).(Mounter)
+			err = r.Mount(context.Background(), "repo", "other", ocispec.Descriptor{
+				MediaType: "text/plain",
+				Digest:    mountDigest,
+				Size:      4,
+			})
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if err == nil || err.Error() != test.wantErr {
+				t.Errorf("got error %v; want %q", err, test.wantErr)
+			}
+			if strings.Join(requests, "\n") != strings.Join(test.wantRequests, "\n") {
+				t.Errorf("got requests:\n%s\nwant:\n%s", strings.Join(requests, "\n"), strings.Join(test.wantRequests, "\n"))
+			}
+		})
+	}
+}
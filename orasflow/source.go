@@ -0,0 +1,308 @@
+package orasflow
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// blobContent returns the content of a blob with the given
+// media type from its source.
+func (a *applier) blobContent(mtype string, src json.RawMessage) ([]byte, error) {
+	switch {
+	case isJSON(mtype):
+		return a.encodeJSON(src)
+	case mtype == "application/zip":
+		// Zip sources used to be a map from file names to
+		// contents. That form is still accepted, as long as
+		// it can't be mistaken for a source object.
+		var files map[string]string
+		if err := json.Unmarshal(src, &files); err == nil && !isSourceObject(files) {
+			contents := make(map[string][]byte)
+			for name, data := range files {
+				contents[name] = []byte(data)
+			}
+			data, err := getZip(contents)
+			if err != nil {
+				return nil, fmt.Errorf("cannot make zip: %v", err)
+			}
+			return data, nil
+		}
+	}
+	data, err := a.decodeSource(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source for media type %q: %v", mtype, err)
+	}
+	return data, nil
+}
+
+// sourceKinds holds the fields that identify the kind of a source object.
+var sourceKinds = []string{"base64", "file", "tar", "tarGzip", "zip", "zstd", "lf"}
+
+// isSourceObject reports whether m could be a source object:
+// that is, whether it has exactly one key, naming a kind of
+// source. A map with any other keys, or more than one,
+// can't be a source object.
+func isSourceObject[T any](m map[string]T) bool {
+	if len(m) != 1 {
+		return false
+	}
+	for _, kind := range sourceKinds {
+		if _, ok := m[kind]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSource returns the content described by src, which
+// is either a string, used as is, or an object with exactly
+// one of the following fields:
+//
+//	base64: the content encoded as base64
+//	file: the name of a file holding the content, which must be
+//	      a local path relative to Options.Dir. It may be a symbolic
+//	      link only if the file it refers to is inside Options.Dir too.
+//	tar: a tar archive of the given files
+//	tarGzip: a gzip-compressed tar archive of the given files
+//	zip: a zip archive of the given files
+//	zstd: the given content as a zstd frame made of raw blocks,
+//	      so the content is stored, not compressed
+//	lf: the given content with CRLF line endings replaced by LF
+//
+// The files in archives and the content of zstd frames
+// and lf are themselves sources.
+func (a *applier) decodeSource(src json.RawMessage) ([]byte, error) {
+	var s string
+	if err := json.Unmarshal(src, &s); err == nil {
+		return []byte(s), nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(src, &fields); err != nil || len(fields) != 1 {
+		return nil, fmt.Errorf("source must be a string or an object with one of the fields %s", strings.Join(sourceKinds, ", "))
+	}
+	for kind, val := range fields {
+		switch kind {
+		case "base64":
+			if err := json.Unmarshal(val, &s); err != nil {
+				return nil, fmt.Errorf("base64 source is not a string")
+			}
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid base64 source: %v", err)
+			}
+			return data, nil
+		case "file":
+			if err := json.Unmarshal(val, &s); err != nil {
+				return nil, fmt.Errorf("file source is not a string")
+			}
+			return a.readFile(s)
+		case "tar", "tarGzip", "zip":
+			var files map[string]json.RawMessage
+			if err := json.Unmarshal(val, &files); err != nil {
+				return nil, fmt.Errorf("%s source is not an object", kind)
+			}
+			if kind == "zip" {
+				return a.getZipSource(files)
+			}
+			data, err := a.getTar(files)
+			if err != nil {
+				return nil, err
+			}
+			if kind == "tarGzip" {
+				return gzipData(data)
+			}
+			return data, nil
+		case "zstd":
+			data, err := a.decodeSource(val)
+			if err != nil {
+				return nil, err
+			}
+			return zstdFrame(data), nil
+		case "lf":
+			data, err := a.decodeSource(val)
+			if err != nil {
+				return nil, err
+			}
+			return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+		default:
+			return nil, fmt.Errorf("unknown source kind %q", kind)
+		}
+	}
+	panic("unreachable")
+}
+
+// readFile returns the content of the named file for a file source.
+// Only files inside the package directory are allowed, so that a
+// configuration can't read arbitrary files on the host. That's checked
+// after resolving symbolic links, so that a link can't be used to
+// escape the directory.
+func (a *applier) readFile(name string) ([]byte, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("file source %q is not a relative path inside the package directory", name)
+	}
+	dir, err := filepath.Abs(a.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	file, err := filepath.EvalSymlinks(filepath.Join(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(dir, file); err != nil || !filepath.IsLocal(rel) {
+		return nil, fmt.Errorf("file source %q refers to a file outside the package directory", name)
+	}
+	return os.ReadFile(file)
+}
+
+// getTar returns a tar archive holding the given files, each of
+// which is described by a source, optionally with a mode field
+// alongside it (see tarEntry). Files are written in lexical order
+// with fixed modification times and ownership, so that the same
+// files always produce the same archive.
+func (a *applier) getTar(files map[string]json.RawMessage) ([]byte, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if !fs.ValidPath(name) || name == "." {
+			return nil, fmt.Errorf("invalid file name %q in tar source", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, name := range names {
+		src, mode, err := tarEntry(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %v", name, err)
+		}
+		data, err := a.decodeSource(src)
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %v", name, err)
+		}
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     int64(len(data)),
+			Mode:     mode,
+			ModTime:  time.Unix(0, 0),
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// tarEntry returns the source and mode of a file in a tar source.
+// The mode defaults to 0o644; it can be set with a mode field
+// in the same object as the source, as in {mode: 0o755, file: "tool"}.
+func tarEntry(src json.RawMessage) (json.RawMessage, int64, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(src, &fields); err != nil {
+		return src, 0o644, nil
+	}
+	modeField, ok := fields["mode"]
+	if !ok {
+		return src, 0o644, nil
+	}
+	var mode int64
+	if err := json.Unmarshal(modeField, &mode); err != nil || mode < 0 || mode > 0o7777 {
+		return nil, 0, fmt.Errorf("invalid mode %s", modeField)
+	}
+	delete(fields, "mode")
+	src, err := json.Marshal(fields)
+	if err != nil {
+		return nil, 0, err
+	}
+	return src, mode, nil
+}
+
+// getZipSource returns a zip archive holding the given files,
+// each of which is described by a source.
+func (a *applier) getZipSource(files map[string]json.RawMessage) ([]byte, error) {
+	contents := make(map[string][]byte)
+	for name, src := range files {
+		data, err := a.decodeSource(src)
+		if err != nil {
+			return nil, fmt.Errorf("file %q: %v", name, err)
+		}
+		contents[name] = data
+	}
+	data, err := getZip(contents)
+	if err != nil {
+		return nil, fmt.Errorf("cannot make zip: %v", err)
+	}
+	return data, nil
+}
+
+// gzipData returns data compressed with gzip. The gzip header
+// holds no name or modification time, so the result depends
+// only on data and the version of compress/gzip: its output
+// is the same every time within a Go release, but it isn't
+// guaranteed to stay the same across releases, so the digests
+// of gzipped content may change when the program is rebuilt.
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// zstdMaxBlockSize holds the largest block size allowed by the zstd format.
+const zstdMaxBlockSize = 128 << 10
+
+// zstdFrame returns data as a single zstd frame (RFC 8878).
+// The data is stored in raw blocks rather than compressed,
+// which keeps the output reproducible without depending on
+// a particular compressor: any zstd decoder can read it, but
+// it's not compressed at all: the frame is 13 bytes larger
+// than data, plus 3 bytes for each block of up to 128KiB.
+func zstdFrame(data []byte) []byte {
+	buf := make([]byte, 0, len(data)+len(data)/zstdMaxBlockSize*3+16)
+	buf = binary.LittleEndian.AppendUint32(buf, 0xFD2FB528)
+	// Frame header descriptor: an 8-byte content size,
+	// a single segment and no checksum or dictionary.
+	buf = append(buf, 0xe0)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(len(data)))
+	for {
+		n := min(len(data), zstdMaxBlockSize)
+		// Block header: last block flag, block type 0 (raw)
+		// and block size.
+		header := uint32(n) << 3
+		if n == len(data) {
+			header |= 1
+		}
+		buf = append(buf, byte(header), byte(header>>8), byte(header>>16))
+		buf = append(buf, data[:n]...)
+		data = data[n:]
+		if header&1 != 0 {
+			return buf
+		}
+	}
+}
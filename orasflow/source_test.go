@@ -0,0 +1,410 @@
+package orasflow
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sourceApplier returns an applier that reads file sources
+// from a temporary directory holding the given files.
+func sourceApplier(t *testing.T, files map[string]string) *applier {
+	dir := t.TempDir()
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &applier{
+		opts: &Options{Dir: filepath.Join(dir, "pkg")},
+	}
+}
+
+var sourceFiles = map[string]string{
+	"pkg/hello.txt":     "hello\n",
+	"pkg/sub/crlf.txt":  "a\r\nb\r\n",
+	"pkg/m.zip":         "not a zip",
+	"secret/secret.txt": "secret",
+}
+
+var decodeSourceTests = []struct {
+	testName string
+	src      string
+	want     string
+	wantErr  string
+}{{
+	testName: "String",
+	src:      `"hello"`,
+	want:     "hello",
+}, {
+	testName: "Base64",
+	src:      `{"base64": "AAECAwT/"}`,
+	want:     "\x00\x01\x02\x03\x04\xff",
+}, {
+	testName: "BadBase64",
+	src:      `{"base64": "!"}`,
+	wantErr:  "invalid base64 source",
+}, {
+	testName: "File",
+	src:      `{"file": "hello.txt"}`,
+	want:     "hello\n",
+}, {
+	testName: "FileInSubdirectory",
+	src:      `{"file": "sub/../hello.txt"}`,
+	want:     "hello\n",
+}, {
+	testName: "FileOutsideDir",
+	src:      `{"file": "../secret/secret.txt"}`,
+	wantErr:  `file source "../secret/secret.txt" is not a relative path inside the package directory`,
+}, {
+	testName: "AbsoluteFile",
+	src:      `{"file": "/etc/passwd"}`,
+	wantErr:  `file source "/etc/passwd" is not a relative path inside the package directory`,
+}, {
+	testName: "SymlinkInsideDir",
+	src:      `{"file": "link.txt"}`,
+	want:     "hello\n",
+}, {
+	testName: "SymlinkOutsideDir",
+	src:      `{"file": "secret.txt"}`,
+	wantErr:  `file source "secret.txt" refers to a file outside the package directory`,
+}, {
+	testName: "SymlinkedDirOutsideDir",
+	src:      `{"file": "secretdir/secret.txt"}`,
+	wantErr:  `file source "secretdir/secret.txt" refers to a file outside the package directory`,
+}, {
+	testName: "MissingFile",
+	src:      `{"file": "nothere.txt"}`,
+	wantErr:  "no such file or directory",
+}, {
+	testName: "LF",
+	src:      `{"lf": {"file": "sub/crlf.txt"}}`,
+	want:     "a\nb\n",
+}, {
+	testName: "TwoKinds",
+	src:      `{"base64": "AA==", "file": "hello.txt"}`,
+	wantErr:  "source must be a string or an object with one of the fields base64, file, tar, tarGzip, zip, zstd, lf",
+}, {
+	testName: "UnknownKind",
+	src:      `{"gzip": "x"}`,
+	wantErr:  `unknown source kind "gzip"`,
+}, {
+	testName: "Number",
+	src:      `1`,
+	wantErr:  "source must be a string or an object",
+}}
+
+func TestDecodeSource(t *testing.T) {
+	a := sourceApplier(t, sourceFiles)
+	for link, target := range map[string]string{
+		"link.txt":   "hello.txt",
+		"secret.txt": "../secret/secret.txt",
+		"secretdir":  "../secret",
+	} {
+		if err := os.Symlink(target, filepath.Join(a.opts.Dir, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range decodeSourceTests {
+		t.Run(test.testName, func(t *testing.T) {
+			got, err := a.decodeSource(json.RawMessage(test.src))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %q; want %q", got, test.want)
+			}
+		})
+	}
+}
+
+type tarFile struct {
+	name string
+	mode int64
+	data string
+}
+
+var tarSourceTests = []struct {
+	testName string
+	src      string
+	want     []tarFile
+	wantErr  string
+}{{
+	testName: "Empty",
+	src:      `{}`,
+}, {
+	testName: "LexicalOrder",
+	src:      `{"z": "last", "a/b": "first", "m": {"file": "hello.txt"}}`,
+	want: []tarFile{
+		{"a/b", 0o644, "first"},
+		{"m", 0o644, "hello\n"},
+		{"z", 0o644, "last"},
+	},
+}, {
+	testName: "Mode",
+	src:      `{"bin/tool": {"mode": 493, "base64": "f0VMRg=="}, "etc/motd": {"mode": 384, "lf": "hi\r\n"}}`,
+	want: []tarFile{
+		{"bin/tool", 0o755, "\x7fELF"},
+		{"etc/motd", 0o600, "hi\n"},
+	},
+}, {
+	testName: "ModeWithString",
+	src:      `{"x": {"mode": 493}}`,
+	wantErr:  `file "x": source must be a string or an object`,
+}, {
+	testName: "BadMode",
+	src:      `{"x": {"mode": "rwx", "base64": ""}}`,
+	wantErr:  `file "x": invalid mode "rwx"`,
+}, {
+	testName: "ModeOutOfRange",
+	src:      `{"x": {"mode": 65536, "base64": ""}}`,
+	wantErr:  `file "x": invalid mode 65536`,
+}, {
+	testName: "BadName",
+	src:      `{"../x": "x"}`,
+	wantErr:  `invalid file name "../x" in tar source`,
+}, {
+	testName: "BadContent",
+	src:      `{"x": {"file": "/etc/passwd"}}`,
+	wantErr:  `file "x": file source "/etc/passwd" is not a relative path`,
+}}
+
+func TestTarSource(t *testing.T) {
+	a := sourceApplier(t, sourceFiles)
+	for _, test := range tarSourceTests {
+		t.Run(test.testName, func(t *testing.T) {
+			data, err := a.decodeSource(json.RawMessage(`{"tar": ` + test.src + `}`))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkTar(t, data, test.want)
+
+			// The same files must always produce the same
+			// archive, and gzip must just compress it.
+			data1, err := a.decodeSource(json.RawMessage(`{"tar": ` + test.src + `}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, data1) {
+				t.Errorf("tar archive is not reproducible")
+			}
+			gz, err := a.decodeSource(json.RawMessage(`{"tarGzip": ` + test.src + `}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(gz))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if zr.Name != "" || !zr.ModTime.IsZero() {
+				t.Errorf("gzip header has name %q and time %v", zr.Name, zr.ModTime)
+			}
+			gunzipped, err := io.ReadAll(zr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(gunzipped, data) {
+				t.Errorf("tarGzip source does not hold the tar source")
+			}
+		})
+	}
+}
+
+func checkTar(t *testing.T, data []byte, want []tarFile) {
+	t.Helper()
+	var got []tarFile
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Typeflag != tar.TypeReg || !h.ModTime.Equal(time.Unix(0, 0)) || h.Uid != 0 || h.Gid != 0 || h.Uname != "" || h.Gname != "" {
+			t.Errorf("unexpected header for %q: %+v", h.Name, h)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, tarFile{h.Name, h.Mode, string(content)})
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got files %v; want %v", got, want)
+	}
+}
+
+var zipBlobTests = []struct {
+	testName  string
+	src       string
+	wantFiles map[string]string // files in the resulting zip
+	want      string            // content, when it's not a zip made by us
+	wantErr   string
+}{{
+	testName:  "Legacy",
+	src:       `{"cue.mod/module.cue": "module: \"x.com\"", "x.cue": "package x"}`,
+	wantFiles: map[string]string{"cue.mod/module.cue": `module: "x.com"`, "x.cue": "package x"},
+}, {
+	testName:  "Zip",
+	src:       `{"zip": {"x.cue": {"file": "hello.txt"}}}`,
+	wantFiles: map[string]string{"x.cue": "hello\n"},
+}, {
+	testName: "File",
+	src:      `{"file": "m.zip"}`,
+	want:     "not a zip",
+}, {
+	testName: "Base64",
+	src:      `{"base64": "UEsFBg=="}`,
+	want:     "PK\x05\x06",
+}, {
+	testName:  "LegacyWithSourceKindNames",
+	src:       `{"file": "x", "lf": "y", "zip": "z"}`,
+	wantFiles: map[string]string{"file": "x", "lf": "y", "zip": "z"},
+}, {
+	testName:  "LegacySingleFile",
+	src:       `{"x.cue": "x"}`,
+	wantFiles: map[string]string{"x.cue": "x"},
+}, {
+	testName: "LF",
+	src:      `{"lf": "a\r\nb"}`,
+	want:     "a\nb",
+}}
+
+func TestZipBlobContent(t *testing.T) {
+	a := sourceApplier(t, sourceFiles)
+	for _, test := range zipBlobTests {
+		t.Run(test.testName, func(t *testing.T) {
+			data, err := a.blobContent("application/zip", json.RawMessage(test.src))
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantFiles == nil {
+				if string(data) != test.want {
+					t.Errorf("got %q; want %q", data, test.want)
+				}
+				return
+			}
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, f := range zr.File {
+				got[f.Name] = readZipFile(t, f)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.wantFiles) {
+				t.Errorf("got files %v; want %v", got, test.wantFiles)
+			}
+		})
+	}
+}
+
+func readZipFile(t *testing.T, f *zip.File) string {
+	t.Helper()
+	r, err := f.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestZstdFrame(t *testing.T) {
+	for _, size := range []int{0, 1, 100, zstdMaxBlockSize - 1, zstdMaxBlockSize, zstdMaxBlockSize + 1, 3*zstdMaxBlockSize + 5} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			data := make([]byte, size)
+			for i := range data {
+				data[i] = byte(i * 7)
+			}
+			frame := zstdFrame(data)
+			blocks := max(1, (size+zstdMaxBlockSize-1)/zstdMaxBlockSize)
+			if want := size + 13 + 3*blocks; len(frame) != want {
+				t.Errorf("frame has %d bytes; want %d", len(frame), want)
+			}
+			got, err := readRawZstdFrame(frame)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("frame does not hold the original data")
+			}
+		})
+	}
+}
+
+// readRawZstdFrame decodes a zstd frame made only of raw blocks,
+// checking it against the format in RFC 8878.
+func readRawZstdFrame(frame []byte) ([]byte, error) {
+	if len(frame) < 13 || binary.LittleEndian.Uint32(frame) != 0xFD2FB528 {
+		return nil, fmt.Errorf("bad magic number")
+	}
+	if frame[4] != 0xe0 {
+		return nil, fmt.Errorf("unexpected frame header descriptor %#x", frame[4])
+	}
+	size := binary.LittleEndian.Uint64(frame[5:])
+	frame = frame[13:]
+	var data []byte
+	for {
+		if len(frame) < 3 {
+			return nil, fmt.Errorf("truncated block header")
+		}
+		header := uint32(frame[0]) | uint32(frame[1])<<8 | uint32(frame[2])<<16
+		last, btype, n := header&1 != 0, (header>>1)&3, int(header>>3)
+		if btype != 0 {
+			return nil, fmt.Errorf("block type %d is not raw", btype)
+		}
+		if n > zstdMaxBlockSize || len(frame)-3 < n {
+			return nil, fmt.Errorf("bad block size %d", n)
+		}
+		data = append(data, frame[3:3+n]...)
+		frame = frame[3+n:]
+		if last {
+			break
+		}
+	}
+	if len(frame) != 0 {
+		return nil, fmt.Errorf("trailing data after last block")
+	}
+	if uint64(len(data)) != size {
+		return nil, fmt.Errorf("content size %d does not match data size %d", size, len(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package orasflow
+
+import (
+	"fmt"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/errors"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/cue-exp/oras/mediatypes"
+)
+
+// CheckManifests checks every manifest and index in v against the
+// schemas in the mediatypes package for its media type and artifact
+// type, without running any tasks or talking to a registry. Manifests
+// whose content depends on other tasks are checked as far as possible.
+// Errors refer to positions in v. If opts is nil, default options are used.
+//
+// [Apply] makes the same checks before running any tasks, and checks
+// each manifest again before pushing it, so there's no need to call
+// CheckManifests before Apply; it's for programs that only validate.
+func CheckManifests(v cue.Value, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	a := &applier{
+		cueCtx: v.Context(),
+		opts:   opts,
+	}
+	if err := a.compileSchemas(); err != nil {
+		return err
+	}
+	if err := a.checkManifests(a.fillDigests(v)); err != nil {
+		return fmt.Errorf("invalid manifests: %v", errors.Details(err, nil))
+	}
+	return nil
+}
+
+// compileSchemas compiles the schemas in the mediatypes package
+// in the same context as the configuration, so that they can be
+// unified with manifests from it.
+func (a *applier) compileSchemas() error {
+	schemas, err := mediatypes.Compile(a.cueCtx)
+	if err != nil {
+		return err
+	}
+	a.schemas = schemas
+	return nil
+}
+
+// checkManifests checks the manifest of every manifest and index
+// task in v against the schemas for its media type and artifact type.
+// Fields that aren't known yet, such as the digests of content
+// that's fetched by other tasks, are allowed to be incomplete.
+func (a *applier) checkManifests(v cue.Value) error {
+	var errs errors.Error
+	walkTasks(v, func(t cue.Value, kind string) {
+		if kind != "manifest" && kind != "index" {
+			return
+		}
+		m := a.manifestValue(t, kind)
+		data, err := m.MarshalJSON()
+		complete := err == nil && descriptorsComplete(data, false)
+		if err := a.checkManifest(m, complete); err != nil {
+			errs = errors.Append(errs, errors.Promote(err, ""))
+		}
+	})
+	return errs
+}
+
+// checkTask checks the manifest of the given manifest
+// or index task just before it's pushed.
+func (a *applier) checkTask(t cue.Value, kind string) error {
+	if err := a.checkManifest(a.manifestValue(t, kind), true); err != nil {
+		return fmt.Errorf("invalid %s: %v", kind, errors.Details(err, nil))
+	}
+	return nil
+}
+
+// manifestValue returns the manifest pushed by the given manifest
+// or index task. An index is built from the task's fields, so that
+// errors still refer to their positions in the configuration.
+func (a *applier) manifestValue(t cue.Value, kind string) cue.Value {
+	if kind == "manifest" {
+		return t.LookupPath(manifestPath)
+	}
+	// Build the index at the same path as the task
+	// so that errors have the paths that users expect.
+	path := t.Path().Selectors()
+	m := a.cueCtx.CompileString("{}").FillPath(cue.MakePath(path...), map[string]any{
+		"schemaVersion": 2,
+		"mediaType":     ocispec.MediaTypeImageIndex,
+	})
+	for _, name := range []string{"manifests", "artifactType", "subject", "annotations"} {
+		if f := t.LookupPath(cue.MakePath(cue.Str(name))); f.Exists() {
+			m = m.FillPath(cue.MakePath(append(path, cue.Str(name))...), f)
+		}
+	}
+	return m.LookupPath(cue.MakePath(path...))
+}
+
+// checkManifest checks the manifest m against the schemas
+// for its media type, which defaults to the image manifest media
+// type, and its artifact type. Manifests with other media types
+// aren't checked. If concrete is true, all fields must be concrete.
+func (a *applier) checkManifest(m cue.Value, concrete bool) error {
+	mtype, err := m.LookupPath(cue.MakePath(cue.Str("mediaType"))).String()
+	if err != nil {
+		mtype = ocispec.MediaTypeImageManifest
+	}
+	m, ok := a.schemas.Unify(mtype, m)
+	if !ok {
+		return nil
+	}
+	return m.Validate(cue.Concrete(concrete))
+}
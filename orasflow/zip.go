@@ -0,0 +1,102 @@
+package orasflow
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"hash/crc32"
+	"sort"
+
+	"github.com/cue-exp/oras/registryclient"
+)
+
+// maxZipSize holds the maximum total size of the
+// uncompressed contents of a zip archive.
+// It's the same as the limit on Go module zip files.
+const maxZipSize = 500 << 20
+
+// zipEpoch holds the modification date of every file in a zip
+// archive, in MS-DOS format: 1980-01-01, the earliest
+// date that the format can represent. The time is always zero.
+const zipEpoch = (1980-1980)<<9 | 1<<5 | 1
+
+// zipCompressionLevel holds the level at which files in
+// zip archives are deflated. It's fixed so that the same
+// files always produce the same archive.
+const zipCompressionLevel = flate.BestCompression
+
+// getZip returns a zip archive holding the given files, keyed by name.
+//
+// The archive is reproducible: the same files always produce the
+// same bytes, regardless of the machine. Files are written in lexical
+// order, deflated at zipCompressionLevel, with a fixed modification
+// time, no directory entries and no extra fields. Like gzip,
+// compress/flate doesn't promise the same output across Go releases,
+// so an archive's digest may change when the Go version does.
+//
+// File names must satisfy [registryclient.CheckZipFileNames], the
+// same rules that clients apply to module zips when they fetch them.
+func getZip(files map[string][]byte) ([]byte, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files found in zip archive")
+	}
+	names := make([]string, 0, len(files))
+	size := 0
+	for name, data := range files {
+		if size += len(data); size > maxZipSize {
+			return nil, fmt.Errorf("zip archive contents larger than %d bytes", maxZipSize)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if err := registryclient.CheckZipFileNames(names); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zipw := zip.NewWriter(&buf)
+	for _, name := range names {
+		data := files[name]
+		compressed, err := deflate(data)
+		if err != nil {
+			return nil, err
+		}
+		// Writing the compressed data raw means that the sizes
+		// are in the header, so there's no data descriptor.
+		w, err := zipw.CreateRaw(&zip.FileHeader{
+			Name:               name,
+			Method:             zip.Deflate,
+			ModifiedDate:       zipEpoch,
+			CRC32:              crc32.ChecksumIEEE(data),
+			CompressedSize64:   uint64(len(compressed)),
+			UncompressedSize64: uint64(len(data)),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(compressed); err != nil {
+			return nil, err
+		}
+	}
+	if err := zipw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deflate returns data compressed at zipCompressionLevel.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, zipCompressionLevel)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package orasflow
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/cue-exp/oras/registryclient"
+)
+
+var getZipTests = []struct {
+	testName string
+	files    map[string]string
+	wantErr  string
+}{{
+	testName: "Module",
+	files: map[string]string{
+		"cue.mod/module.cue": `module: "example.com@v0"`,
+		"x.cue":              "package x\n",
+		"sub/y.cue":          "package sub\n",
+		"README":             "",
+	},
+}, {
+	testName: "NoFiles",
+	files:    map[string]string{},
+	wantErr:  "no files found in zip archive",
+}, {
+	testName: "DotDot",
+	files:    map[string]string{"../x.cue": ""},
+	wantErr:  `invalid file name in module zip: malformed file path "../x.cue"`,
+}, {
+	testName: "Absolute",
+	files:    map[string]string{"/x.cue": ""},
+	wantErr:  `invalid file name in module zip: malformed file path "/x.cue"`,
+}, {
+	testName: "Backslash",
+	files:    map[string]string{`a\b.cue`: ""},
+	wantErr:  `invalid file name in module zip: malformed file path "a\\b.cue"`,
+}, {
+	testName: "Dot",
+	files:    map[string]string{".": ""},
+	wantErr:  "invalid file name in module zip",
+}, {
+	testName: "InvalidChar",
+	files:    map[string]string{"a*.cue": ""},
+	wantErr:  `invalid file name in module zip: malformed file path "a*.cue"`,
+}, {
+	testName: "CaseClash",
+	files:    map[string]string{"A.cue": "", "a.cue": ""},
+	wantErr:  `files "A.cue" and "a.cue" in module zip differ only in case`,
+}, {
+	testName: "FileDirClash",
+	files:    map[string]string{"a": "", "a/b.cue": ""},
+	wantErr:  `file "a" in module zip conflicts with directory "a"`,
+}, {
+	testName: "FileDirCaseClash",
+	files:    map[string]string{"A": "", "a/b/c.cue": ""},
+	wantErr:  `file "A" in module zip conflicts with directory "a"`,
+}}
+
+func TestGetZip(t *testing.T) {
+	for _, test := range getZipTests {
+		t.Run(test.testName, func(t *testing.T) {
+			files := make(map[string][]byte)
+			for name, data := range test.files {
+				files[name] = []byte(data)
+			}
+			data, err := getZip(files)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Anything that getZip produces must be accepted
+			// by clients that fetch it.
+			if err := registryclient.CheckZip(bytes.NewReader(data), int64(len(data)), nil); err != nil {
+				t.Errorf("CheckZip rejects archive: %v", err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			prev := ""
+			for _, f := range zr.File {
+				if f.Name <= prev {
+					t.Errorf("file %q is out of order after %q", f.Name, prev)
+				}
+				prev = f.Name
+				if f.Method != zip.Deflate || len(f.Extra) != 0 || !f.Modified.Equal(time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)) {
+					t.Errorf("unexpected header for %q: %+v", f.Name, f.FileHeader)
+				}
+				if got := readZipFile(t, f); got != test.files[f.Name] {
+					t.Errorf("file %q holds %q; want %q", f.Name, got, test.files[f.Name])
+				}
+			}
+			if len(zr.File) != len(test.files) {
+				t.Errorf("got %d files; want %d", len(zr.File), len(test.files))
+			}
+		})
+	}
+}
+
+func TestGetZipReproducible(t *testing.T) {
+	// The archive must depend only on the files, whatever the
+	// order of map iteration, so check it against a fixed digest
+	// as well as against itself. The digest may need updating
+	// if a new version of Go changes compress/flate's output.
+	files := map[string][]byte{
+		"cue.mod/module.cue": []byte(`module: "example.com@v0"`),
+		"b.cue":              []byte("package b\n"),
+		"a/a.cue":            []byte("package a\n"),
+	}
+	data, err := getZip(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		data1, err := getZip(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, data1) {
+			t.Fatalf("zip archive is not reproducible")
+		}
+	}
+	const want = "sha256:350cd58275aed084d6f755b3b0a1d086e2a1beda5d4769c231e1d91adf4415b8"
+	if got := digest.FromBytes(data); got != want {
+		t.Errorf("zip archive has digest %s; want %s", got, want)
+	}
+}
@@ -1,6 +1,7 @@
 package registryclient
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -84,15 +85,20 @@ func (c *Client) ModuleVersions(ctx context.Context, m string) ([]string, error)
 	if err != nil {
 		return nil, fmt.Errorf("cannot determine remote repository: %v", err)
 	}
+	// Ask only for the tags after "v": registries that support
+	// the last parameter list tags in lexical order, so no versions
+	// come before it. Other registries may list the tags in any
+	// order, so read all of them, skipping any that aren't versions.
 	var versions []string
-	if err := repo.Tags(ctx, "", func(tags []string) error {
+	err = repo.Tags(ctx, "v", func(tags []string) error {
 		for _, tag := range tags {
-			if semver.IsValid(tag) {
+			if strings.HasPrefix(tag, "v") && semver.IsValid(tag) {
 				versions = append(versions, tag)
 			}
 		}
 		return nil
-	}); err != nil {
+	})
+	if err != nil {
 		return nil, err
 	}
 	return versions, nil
@@ -117,8 +123,16 @@ func (m *Module) ModuleFile(ctx context.Context) ([]byte, error) {
 	return fetchBytes(ctx, m.repo, m.manifest.Layers[1])
 }
 
+// GetZip returns the contents of the module's zip file.
+// The zip file is checked with [CheckZip] before it's returned,
+// including checking that its module.cue file agrees with
+// the module's module file layer.
 func (m *Module) GetZip(ctx context.Context) (io.ReadCloser, error) {
-	panic("unimplemented")
+	moduleFile, err := m.ModuleFile(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch module file: %v", err)
+	}
+	return fetchZip(ctx, m.repo, m.manifest.Layers[0], moduleFile)
 }
 
 func (m *Module) Dependencies(ctx context.Context) (map[module.Version]Dependency, error) {
@@ -130,7 +144,7 @@ func (m *Module) Dependencies(ctx context.Context) (map[module.Version]Dependenc
 		}
 		mpath, mver, ok := strings.Cut(mname, "@")
 		if !ok || mver == "" || !semver.IsValid(mver) {
-			return nil, fmt.Errorf("bad module name %q found in module config", m)
+			return nil, fmt.Errorf("bad module name %q found in module config", mname)
 		}
 		mv := module.Version{
 			Path:    mpath,
@@ -138,6 +152,7 @@ func (m *Module) Dependencies(ctx context.Context) (map[module.Version]Dependenc
 		}
 		deps[mv] = Dependency{
 			client:  m.client,
+			repo:    m.repo,
 			version: mv,
 			desc:    desc,
 		}
@@ -147,6 +162,7 @@ func (m *Module) Dependencies(ctx context.Context) (map[module.Version]Dependenc
 
 type Dependency struct {
 	client  *Client
+	repo    registry.Repository
 	version module.Version
 	desc    ocispec.Descriptor
 }
@@ -155,8 +171,26 @@ func (d Dependency) Version() module.Version {
 	return d.version
 }
 
-func (d Dependency) GetZip() (io.ReadCloser, error) {
-	panic("unimplemented")
+// GetZip returns the contents of the dependency's zip file.
+// The zip file is checked with [CheckZip] before it's returned.
+func (d Dependency) GetZip(ctx context.Context) (io.ReadCloser, error) {
+	return fetchZip(ctx, d.repo, d.desc, nil)
+}
+
+// fetchZip fetches and checks the module zip file with the given descriptor.
+func fetchZip(ctx context.Context, from content.Fetcher, desc ocispec.Descriptor, moduleFile []byte) (io.ReadCloser, error) {
+	if desc.Size > MaxZipFile {
+		return nil, fmt.Errorf("module zip file is %d bytes; maximum is %d", desc.Size, MaxZipFile)
+	}
+	// FetchAll checks the size and digest of the content.
+	data, err := content.FetchAll(ctx, from, desc)
+	if err != nil {
+		return nil, fmt.Errorf("cannot fetch module zip: %v", err)
+	}
+	if err := CheckZip(bytes.NewReader(data), int64(len(data)), moduleFile); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
 }
 
 func fetchJSON(ctx context.Context, from content.Fetcher, desc ocispec.Descriptor, dst any) error {
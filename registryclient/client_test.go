@@ -0,0 +1,42 @@
+package registryclient
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestModuleVersionsUnsorted(t *testing.T) {
+	// A registry that ignores the last parameter
+	// and lists tags in no particular order.
+	tags := []string{"v0.2.0", "latest", "v0.1.0", "w", "v1.0.0", "vfoo", "a"}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/v2/cue/example.com/tags/list" {
+			http.NotFound(w, req)
+			return
+		}
+		data, _ := json.Marshal(map[string]any{
+			"name": "cue/example.com",
+			"tags": tags,
+		})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	defer srv.Close()
+	c, err := New(strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.remote.PlainHTTP = true
+	versions, err := c.ModuleVersions(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"v0.2.0", "v0.1.0", "v1.0.0"}
+	if strings.Join(versions, " ") != strings.Join(want, " ") {
+		t.Errorf("got versions %q; want %q", versions, want)
+	}
+}
@@ -0,0 +1,246 @@
+package registryclient
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"cuelang.org/go/cue/cuecontext"
+	"golang.org/x/mod/module"
+
+	"github.com/cue-exp/oras/modpush"
+)
+
+// Limits on the contents of module zip files. They're the
+// same as those that golang.org/x/mod/zip applies to Go modules.
+const (
+	// MaxZipFile holds the maximum size of a module zip file,
+	// and also the maximum total size of the files in it.
+	MaxZipFile = 500 << 20
+
+	// MaxModuleFile holds the maximum size of
+	// the cue.mod/module.cue file.
+	MaxModuleFile = modpush.MaxModuleFile
+)
+
+// maxCompressionRatio holds the maximum ratio of uncompressed
+// to compressed size allowed for any file in a module zip that's
+// larger than minRatioCheckSize. Legitimate source files rarely
+// compress better than this; zip bombs compress much better.
+const (
+	maxCompressionRatio = 200
+	minRatioCheckSize   = 1 << 20
+)
+
+const moduleFilePath = "cue.mod/module.cue"
+
+// CheckZip checks that the module zip file in r, which holds
+// size bytes, is safe to extract. It reports an error if:
+//
+//   - the zip file or the files in it are too large (see [MaxZipFile]),
+//     or any file compresses suspiciously well;
+//   - any file name is not a valid module file path (see
+//     [module.CheckFilePath]), which rules out names that are absolute,
+//     contain "..", backslashes or invalid UTF-8;
+//   - any two files or directories have names that differ only in case;
+//   - any file is a symbolic link or another non-regular file;
+//   - there's no cue.mod/module.cue file, or moduleFile is non-nil and
+//     the module.cue file does not satisfy the registry's schema for
+//     module files or does not hold the same value as moduleFile,
+//     the contents of the module's separate module file layer.
+//
+// The contents of every file are read, so that corrupt
+// data and inaccurate sizes are also reported.
+func CheckZip(r io.ReaderAt, size int64, moduleFile []byte) error {
+	_, err := checkZip(r, size, moduleFile)
+	return err
+}
+
+// checkZip implements CheckZip, returning the files in the zip.
+func checkZip(r io.ReaderAt, size int64, moduleFile []byte) ([]*zip.File, error) {
+	if size > MaxZipFile {
+		return nil, fmt.Errorf("module zip file is %d bytes; maximum is %d", size, MaxZipFile)
+	}
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid module zip file: %v", err)
+	}
+	var (
+		files []*zip.File
+		names []string
+		total uint64
+		modf  *zip.File
+	)
+	for _, f := range z.File {
+		name := f.Name
+		if strings.HasSuffix(name, "/") && f.Mode().IsDir() && f.UncompressedSize64 == 0 {
+			// Directory entries are allowed but ignored.
+			continue
+		}
+		if err := module.CheckFilePath(name); err != nil {
+			return nil, fmt.Errorf("invalid file name in module zip: %v", err)
+		}
+		if !f.Mode().IsRegular() {
+			return nil, fmt.Errorf("file %q in module zip is not a regular file (mode %v)", name, f.Mode())
+		}
+		names = append(names, name)
+		if total += f.UncompressedSize64; total > MaxZipFile {
+			return nil, fmt.Errorf("total size of files in module zip is more than %d bytes", MaxZipFile)
+		}
+		if f.UncompressedSize64 > minRatioCheckSize && f.UncompressedSize64/max(f.CompressedSize64, 1) > maxCompressionRatio {
+			return nil, fmt.Errorf("file %q in module zip has a suspicious compression ratio", name)
+		}
+		if name == moduleFilePath {
+			modf = f
+		}
+		files = append(files, f)
+	}
+	if err := CheckZipFileNames(names); err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		// archive/zip reports an error if the content does
+		// not match the recorded size or checksum.
+		if err := readZipFile(f, io.Discard); err != nil {
+			return nil, err
+		}
+	}
+	if modf == nil {
+		return nil, fmt.Errorf("module zip has no %s file", moduleFilePath)
+	}
+	if modf.UncompressedSize64 > MaxModuleFile {
+		return nil, fmt.Errorf("%s in module zip is %d bytes; maximum is %d", moduleFilePath, modf.UncompressedSize64, MaxModuleFile)
+	}
+	if moduleFile != nil {
+		var buf bytes.Buffer
+		if err := readZipFile(modf, &buf); err != nil {
+			return nil, err
+		}
+		if err := checkModuleFile(buf.Bytes(), moduleFile); err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// CheckZipFileNames checks that names, the names of the files in a
+// module zip, are acceptable to [CheckZip]: each must be a valid module
+// file path (see [module.CheckFilePath]), no two names may differ
+// only in case, and no name may also be the directory of another,
+// ignoring case, as "a" and "A/b" would be. Programs that create
+// module zips can use it to check their file names up front.
+func CheckZipFileNames(names []string) error {
+	folded := make(map[string]string)
+	dirs := make(map[string]string)
+	for _, name := range names {
+		if err := module.CheckFilePath(name); err != nil {
+			return fmt.Errorf("invalid file name in module zip: %v", err)
+		}
+		fold := strings.ToLower(strings.ToUpper(name))
+		if other, ok := folded[fold]; ok {
+			return fmt.Errorf("files %q and %q in module zip differ only in case", other, name)
+		}
+		folded[fold] = name
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[strings.ToLower(strings.ToUpper(dir))] = dir
+		}
+	}
+	for _, name := range names {
+		fold := strings.ToLower(strings.ToUpper(name))
+		if dir, ok := dirs[fold]; ok {
+			return fmt.Errorf("file %q in module zip conflicts with directory %q", name, dir)
+		}
+	}
+	return nil
+}
+
+// checkModuleFile checks that the module.cue file in a module zip
+// satisfies the registry's schema for module files (see
+// [modpush.CheckModuleFileValue]) and holds the same value as the
+// module's module file layer. Neither is evaluated as arbitrary CUE.
+func checkModuleFile(zipData, layerData []byte) error {
+	ctx := cuecontext.New()
+	zipVal, err := modpush.ParseModuleFile(ctx, moduleFilePath, zipData)
+	if err != nil {
+		return fmt.Errorf("invalid %s in module zip: %v", moduleFilePath, err)
+	}
+	if err := modpush.CheckModuleFileValue(zipVal); err != nil {
+		return fmt.Errorf("%s in module zip: %v", moduleFilePath, err)
+	}
+	layerVal, err := modpush.DecodeModuleFile(ctx, layerData)
+	if err != nil {
+		return fmt.Errorf("invalid module file layer: %v", err)
+	}
+	if !zipVal.Equals(layerVal) {
+		return fmt.Errorf("%s in module zip does not match module file layer", moduleFilePath)
+	}
+	return nil
+}
+
+// readZipFile copies the contents of f to w.
+func readZipFile(f *zip.File, w io.Writer) error {
+	r, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("cannot open %q in module zip: %v", f.Name, err)
+	}
+	defer r.Close()
+	// Read at most one byte more than the recorded size
+	// so that archive/zip can detect an inaccurate size
+	// without us reading an unbounded amount of data.
+	if _, err := io.Copy(w, io.LimitReader(r, int64(f.UncompressedSize64)+1)); err != nil {
+		return fmt.Errorf("cannot read %q in module zip: %v", f.Name, err)
+	}
+	return nil
+}
+
+// ExtractZip checks the module zip file in r, which holds size
+// bytes, as [CheckZip] does, and then writes its files to dir,
+// which must not already exist. Nothing is ever written outside dir.
+func ExtractZip(r io.ReaderAt, size int64, dir string, moduleFile []byte) (err error) {
+	files, err := checkZip(r, size, moduleFile)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0o777); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+	for _, f := range files {
+		// CheckZip has already checked that the name is a
+		// valid relative path, but check again so that
+		// this never writes outside dir.
+		if !fs.ValidPath(f.Name) {
+			return fmt.Errorf("invalid file name %q in module zip", f.Name)
+		}
+		file := filepath.Join(dir, filepath.FromSlash(f.Name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o777); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, file string) error {
+	w, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o666)
+	if err != nil {
+		return err
+	}
+	if err := readZipFile(f, w); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
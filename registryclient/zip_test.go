@@ -0,0 +1,274 @@
+package registryclient
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testModuleFile = `{"module":"example.com@v0","deps":{"bar.com@v0":{"v":"v0.5.0"}}}`
+
+type zipFile struct {
+	name   string
+	data   string
+	mode   fs.FileMode
+	method uint16
+}
+
+var checkZipTests = []struct {
+	testName   string
+	files      []zipFile
+	moduleFile string
+	wantErr    string
+}{{
+	testName: "Valid",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "x.cue", data: "package x\n"},
+		{name: "sub/", mode: fs.ModeDir | 0o755},
+		{name: "sub/y.cue", data: "package sub\n", method: zip.Deflate},
+	},
+	moduleFile: testModuleFile,
+}, {
+	testName: "ModuleFileNotChecked",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: `module: "example.com"`},
+	},
+}, {
+	testName: "ModuleFileCUE",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: "module: \"example.com@v0\"\ndeps: \"bar.com@v0\": v: \"v0.5.0\"\n"},
+	},
+	moduleFile: testModuleFile,
+}, {
+	testName: "NoModuleFile",
+	files: []zipFile{
+		{name: "x.cue", data: "package x\n"},
+	},
+	wantErr: "module zip has no cue.mod/module.cue file",
+}, {
+	testName: "DotDot",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "../x.cue"},
+	},
+	wantErr: `invalid file name in module zip: malformed file path "../x.cue"`,
+}, {
+	testName: "Absolute",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "/etc/passwd"},
+	},
+	wantErr: `invalid file name in module zip: malformed file path "/etc/passwd"`,
+}, {
+	testName: "Backslash",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: `..\x.cue`},
+	},
+	wantErr: "invalid file name in module zip",
+}, {
+	testName: "Symlink",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "x.cue", data: "/etc/passwd", mode: fs.ModeSymlink | 0o777},
+	},
+	wantErr: `file "x.cue" in module zip is not a regular file`,
+}, {
+	testName: "CaseClash",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "x.cue"},
+		{name: "X.cue"},
+	},
+	wantErr: `files "x.cue" and "X.cue" in module zip differ only in case`,
+}, {
+	testName: "FileDirClash",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "a/b.cue"},
+		{name: "a"},
+	},
+	wantErr: `file "a" in module zip conflicts with directory "a"`,
+}, {
+	testName: "CompressionRatio",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "big.cue", data: strings.Repeat("\x00", 4<<20), method: zip.Deflate},
+	},
+	wantErr: `file "big.cue" in module zip has a suspicious compression ratio`,
+}, {
+	testName: "ModuleFileMismatch",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: `{"module":"example.com@v0"}`},
+	},
+	moduleFile: testModuleFile,
+	wantErr:    "cue.mod/module.cue in module zip does not match module file layer",
+}, {
+	testName: "ModuleFileInvalid",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: `{"module":"example.com"}`},
+	},
+	moduleFile: `{"module":"example.com"}`,
+	wantErr:    "cue.mod/module.cue in module zip: invalid module file",
+}, {
+	testName: "ModuleFileExpression",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: "m: \"example.com@v0\"\nmodule: m\n"},
+	},
+	moduleFile: testModuleFile,
+	wantErr:    "invalid cue.mod/module.cue in module zip: module file must hold only data",
+}, {
+	testName: "ModuleFileLayerNotJSON",
+	files: []zipFile{
+		{name: "cue.mod/module.cue", data: `{"module":"example.com@v0"}`},
+	},
+	moduleFile: `module: "example.com@v0"`,
+	wantErr:    "invalid module file layer",
+}}
+
+func TestCheckZip(t *testing.T) {
+	for _, test := range checkZipTests {
+		t.Run(test.testName, func(t *testing.T) {
+			data := makeZip(t, test.files)
+			var moduleFile []byte
+			if test.moduleFile != "" {
+				moduleFile = []byte(test.moduleFile)
+			}
+			err := CheckZip(bytes.NewReader(data), int64(len(data)), moduleFile)
+			checkErr(t, err, test.wantErr)
+		})
+	}
+}
+
+func TestCheckZipCorrupt(t *testing.T) {
+	data := makeZip(t, []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "x.cue", data: "package x\n"},
+	})
+	// Corrupt the contents of x.cue, which are stored
+	// uncompressed, so that the checksum doesn't match.
+	i := bytes.Index(data, []byte("package x"))
+	data[i] = 'P'
+	err := CheckZip(bytes.NewReader(data), int64(len(data)), nil)
+	checkErr(t, err, `cannot read "x.cue" in module zip`)
+
+	err = CheckZip(bytes.NewReader(data), MaxZipFile+1, nil)
+	checkErr(t, err, "module zip file is 524288001 bytes; maximum is 524288000")
+}
+
+func TestExtractZip(t *testing.T) {
+	files := []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "x.cue", data: "package x\n"},
+		{name: "sub/y.cue", data: "package sub\n", method: zip.Deflate},
+	}
+	data := makeZip(t, files)
+	dir := filepath.Join(t.TempDir(), "m")
+	if err := ExtractZip(bytes.NewReader(data), int64(len(data)), dir, []byte(testModuleFile)); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		got, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f.name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != f.data {
+			t.Errorf("%s holds %q; want %q", f.name, got, f.data)
+		}
+	}
+	// The directory must not already exist.
+	err := ExtractZip(bytes.NewReader(data), int64(len(data)), dir, nil)
+	checkErr(t, err, "file exists")
+}
+
+func TestExtractZipInvalid(t *testing.T) {
+	parent := t.TempDir()
+	data := makeZip(t, []zipFile{
+		{name: "cue.mod/module.cue", data: testModuleFile},
+		{name: "../escape.cue", data: "package x\n"},
+	})
+	dir := filepath.Join(parent, "m")
+	err := ExtractZip(bytes.NewReader(data), int64(len(data)), dir, nil)
+	checkErr(t, err, "invalid file name in module zip")
+	// Nothing should have been written at all.
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("unexpected files written: %v", entries)
+	}
+}
+
+var checkZipFileNamesTests = []struct {
+	testName string
+	names    []string
+	wantErr  string
+}{{
+	testName: "Valid",
+	names:    []string{"cue.mod/module.cue", "a/b.cue", "a/c.cue", "ab"},
+}, {
+	testName: "Empty",
+	names:    []string{""},
+	wantErr:  "invalid file name in module zip",
+}, {
+	testName: "CaseClash",
+	names:    []string{"a/B.cue", "a/b.cue"},
+	wantErr:  `files "a/B.cue" and "a/b.cue" in module zip differ only in case`,
+}, {
+	testName: "FileDirCaseClash",
+	names:    []string{"x/Y", "x/y/z.cue"},
+	wantErr:  `file "x/Y" in module zip conflicts with directory "x/y"`,
+}}
+
+func TestCheckZipFileNames(t *testing.T) {
+	for _, test := range checkZipFileNamesTests {
+		t.Run(test.testName, func(t *testing.T) {
+			checkErr(t, CheckZipFileNames(test.names), test.wantErr)
+		})
+	}
+}
+
+// makeZip returns a zip archive holding the given files.
+func makeZip(t *testing.T, files []zipFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		h := &zip.FileHeader{
+			Name:   f.name,
+			Method: f.method,
+		}
+		if f.mode != 0 {
+			h.SetMode(f.mode)
+		}
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(f.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func checkErr(t *testing.T, err error, wantErr string) {
+	t.Helper()
+	if wantErr == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if err == nil || !strings.Contains(err.Error(), wantErr) {
+		t.Fatalf("got error %v; want error containing %q", err, wantErr)
+	}
+}
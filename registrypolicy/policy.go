@@ -0,0 +1,200 @@
+// Package registrypolicy checks pushes to a registry against a
+// policy written in CUE. See push.cue for how policies are written.
+package registrypolicy
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"cuelang.org/go/cue"
+	"cuelang.org/go/cue/cuecontext"
+	"cuelang.org/go/cue/errors"
+	"cuelang.org/go/encoding/json"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+//go:embed push.cue
+var pushSchema []byte
+
+var pushPath = cue.MakePath(cue.Str("push"))
+
+// Push describes a push to a registry.
+// It's the Go form of #Push in push.cue.
+type Push struct {
+	Kind     string                  `json:"kind"`
+	Repo     string                  `json:"repo"`
+	Desc     *ociregistry.Descriptor `json:"desc,omitempty"`
+	Manifest []byte                  `json:"-"`
+	Tag      string                  `json:"tag,omitempty"`
+	Digest   ociregistry.Digest      `json:"digest,omitempty"`
+	FromRepo string                  `json:"fromRepo,omitempty"`
+}
+
+// Policy holds a compiled policy. It's safe
+// to call its methods concurrently, but checks
+// are made one at a time.
+type Policy struct {
+	// mu guards ctx, which can't be used concurrently.
+	mu  sync.Mutex
+	ctx *cue.Context
+	v   cue.Value
+}
+
+// ParseFile reads the policy in the given CUE file.
+func ParseFile(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(filename, data)
+}
+
+// Parse compiles the policy in src. The filename
+// is used for the positions in errors.
+func Parse(filename string, src []byte) (*Policy, error) {
+	ctx := cuecontext.New()
+	schema := ctx.CompileBytes(pushSchema, cue.Filename("push.cue"))
+	if err := schema.Err(); err != nil {
+		return nil, fmt.Errorf("cannot compile push schema: %v", errors.Details(err, nil))
+	}
+	// The policy can't be fully checked until it's used, because
+	// its comprehensions usually depend on the push, so only
+	// report errors other than incomplete values.
+	v := ctx.CompileBytes(src, cue.Filename(filename))
+	v = v.FillPath(pushPath, schema.LookupPath(cue.MakePath(cue.Def("#Push"))))
+	if err := v.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid policy: %v", errors.Details(err, nil))
+	}
+	return &Policy{
+		ctx: ctx,
+		v:   v,
+	}, nil
+}
+
+// Check checks the given push against the policy. If the policy
+// doesn't allow it, it returns an error that wraps both
+// [ociregistry.ErrDenied] and the reason for the denial.
+func (p *Policy) Check(push Push) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	v := p.v.FillPath(pushPath, push)
+	if push.Manifest != nil {
+		// The manifest comes from the client, so decode it
+		// as JSON rather than evaluating it as CUE.
+		expr, err := json.Extract("manifest.json", push.Manifest)
+		if err != nil {
+			return fmt.Errorf("%w: invalid manifest: %v", ociregistry.ErrDenied, err)
+		}
+		m := p.ctx.BuildExpr(expr)
+		if err := m.Err(); err != nil {
+			return fmt.Errorf("%w: invalid manifest: %v", ociregistry.ErrDenied, err)
+		}
+		v = v.FillPath(cue.MakePath(cue.Str("push"), cue.Str("manifest")), m)
+	}
+	// Validate reports conflicts with the policy; MarshalJSON
+	// reports fields that the policy requires but are missing.
+	err := v.Validate()
+	if err == nil {
+		_, err = v.LookupPath(pushPath).MarshalJSON()
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s push to %q not allowed by policy: %v", ociregistry.ErrDenied, push.Kind, push.Repo, errors.Details(err, nil))
+	}
+	return nil
+}
+
+// Registry returns an implementation of [ociregistry.Interface]
+// that passes all operations through to r, except that every push,
+// tag and mount is first checked against p. Operations that
+// the policy doesn't allow fail with an error that wraps
+// [ociregistry.ErrDenied], which [ociregistry.Serve] reports as a
+// DENIED error. Deletions aren't checked.
+//
+// The policy is evaluated in a single CUE context, which can't be
+// used concurrently, so checks are serialized: concurrent pushes
+// wait for each other's checks, although not for each other's
+// uploads. Registries that need more throughput can use several
+// Policy values parsed from the same source.
+//
+// If r implements [ociregistry.Lister], so does the returned registry.
+func (p *Policy) Registry(r ociregistry.Interface) ociregistry.Interface {
+	pr := &policyRegistry{
+		Interface: r,
+		p:         p,
+	}
+	if l, ok := r.(ociregistry.Lister); ok {
+		return policyLister{pr, l}
+	}
+	return pr
+}
+
+type policyRegistry struct {
+	ociregistry.Interface
+	p *Policy
+}
+
+type policyLister struct {
+	*policyRegistry
+	ociregistry.Lister
+}
+
+func (r *policyRegistry) PushBlob(ctx context.Context, repo string, c ociregistry.BlobReader, desc ociregistry.Descriptor) (ociregistry.Descriptor, error) {
+	if err := r.p.Check(Push{
+		Kind: "blob",
+		Repo: repo,
+		Desc: &desc,
+	}); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return r.Interface.PushBlob(ctx, repo, c, desc)
+}
+
+func (r *policyRegistry) PushManifest(ctx context.Context, repo string, c ociregistry.BlobReader, desc ociregistry.Descriptor) (ociregistry.Descriptor, error) {
+	data, err := readAll(c.Open())
+	if err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	if err := r.p.Check(Push{
+		Kind:     "manifest",
+		Repo:     repo,
+		Desc:     &desc,
+		Manifest: data,
+	}); err != nil {
+		return ociregistry.Descriptor{}, err
+	}
+	return r.Interface.PushManifest(ctx, repo, ociregistry.BytesBlob(data, desc.MediaType), desc)
+}
+
+func (r *policyRegistry) Tag(ctx context.Context, repo string, digest ociregistry.Digest, tag string) error {
+	if err := r.p.Check(Push{
+		Kind:   "tag",
+		Repo:   repo,
+		Tag:    tag,
+		Digest: digest,
+	}); err != nil {
+		return err
+	}
+	return r.Interface.Tag(ctx, repo, digest, tag)
+}
+
+func (r *policyRegistry) Mount(ctx context.Context, repo string, fromRepo string, digest ociregistry.Digest) error {
+	if err := r.p.Check(Push{
+		Kind:     "mount",
+		Repo:     repo,
+		Digest:   digest,
+		FromRepo: fromRepo,
+	}); err != nil {
+		return err
+	}
+	return r.Interface.Mount(ctx, repo, fromRepo, digest)
+}
+
+func readAll(r io.ReadCloser) ([]byte, error) {
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -0,0 +1,275 @@
+package registrypolicy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/cue-exp/oras/ociregistry"
+)
+
+const testPolicy = `
+import "strings"
+
+push: _
+
+// Blobs are at most 100 bytes.
+if push.kind == "blob" {
+	push: desc: size: <=100
+}
+
+// Module repositories only hold modules.
+if strings.HasPrefix(push.repo, "cue/") && push.kind == "manifest" {
+	push: manifest: config: mediaType: "application/vnd.cue.module.v1+json"
+}
+
+// Manifests must say where they came from.
+if push.kind == "manifest" {
+	push: manifest: annotations: "org.opencontainers.image.source"!: string
+}
+
+// Nothing is tagged latest.
+if push.kind == "tag" {
+	push: tag: !="latest"
+}
+
+// Blobs can only be mounted from public repositories.
+if push.kind == "mount" {
+	push: fromRepo: =~"^public/"
+}
+`
+
+const (
+	testDigest   = "sha256:a948904f2f0f479b8f8197694b30184b0d2ed1c1cd2a1ec0fb85d299a192a447"
+	testManifest = `{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.oci.image.manifest.v1+json",
+	"config": {"mediaType": "application/vnd.cue.module.v1+json", "digest": "` + testDigest + `", "size": 2},
+	"layers": [],
+	"annotations": {"org.opencontainers.image.source": "https://example.com"}
+}`
+)
+
+var checkTests = []struct {
+	testName string
+	push     Push
+	wantErr  string
+}{{
+	testName: "SmallBlob",
+	push: Push{
+		Kind: "blob",
+		Repo: "foo",
+		Desc: &ociregistry.Descriptor{MediaType: "text/plain", Digest: testDigest, Size: 100},
+	},
+}, {
+	testName: "LargeBlob",
+	push: Push{
+		Kind: "blob",
+		Repo: "foo",
+		Desc: &ociregistry.Descriptor{MediaType: "text/plain", Digest: testDigest, Size: 101},
+	},
+	wantErr: `blob push to "foo" not allowed by policy`,
+}, {
+	testName: "Manifest",
+	push: Push{
+		Kind:     "manifest",
+		Repo:     "cue/foo",
+		Desc:     &ociregistry.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: testDigest, Size: 1000},
+		Manifest: []byte(testManifest),
+	},
+}, {
+	testName: "ManifestWithoutSource",
+	push: Push{
+		Kind:     "manifest",
+		Repo:     "foo",
+		Desc:     &ociregistry.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: testDigest, Size: 1000},
+		Manifest: []byte(`{"schemaVersion": 2, "layers": []}`),
+	},
+	wantErr: `manifest push to "foo" not allowed by policy`,
+}, {
+	testName: "ManifestNotModule",
+	push: Push{
+		Kind:     "manifest",
+		Repo:     "cue/foo",
+		Desc:     &ociregistry.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: testDigest, Size: 1000},
+		Manifest: []byte(strings.Replace(testManifest, "vnd.cue.module", "vnd.example", 1)),
+	},
+	wantErr: `manifest push to "cue/foo" not allowed by policy`,
+}, {
+	testName: "ManifestNotJSON",
+	push: Push{
+		Kind: "manifest",
+		Repo: "foo",
+		Desc: &ociregistry.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: testDigest, Size: 1000},
+		// Valid CUE, but not JSON, so it must not be evaluated.
+		Manifest: []byte(`annotations: "org.opencontainers.image.source": "x" & string`),
+	},
+	wantErr: "invalid manifest",
+}, {
+	testName: "ManifestCUEExpression",
+	push: Push{
+		Kind:     "manifest",
+		Repo:     "foo",
+		Desc:     &ociregistry.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: testDigest, Size: 1000},
+		Manifest: []byte(`{"annotations": {"org.opencontainers.image.source": push.repo}}`),
+	},
+	wantErr: "invalid manifest",
+}, {
+	testName: "Tag",
+	push: Push{
+		Kind:   "tag",
+		Repo:   "foo",
+		Tag:    "v1.0.0",
+		Digest: testDigest,
+	},
+}, {
+	testName: "TagLatest",
+	push: Push{
+		Kind:   "tag",
+		Repo:   "foo",
+		Tag:    "latest",
+		Digest: testDigest,
+	},
+	wantErr: `tag push to "foo" not allowed by policy`,
+}, {
+	testName: "MountPublic",
+	push: Push{
+		Kind:     "mount",
+		Repo:     "foo",
+		Digest:   testDigest,
+		FromRepo: "public/bar",
+	},
+}, {
+	testName: "MountPrivate",
+	push: Push{
+		Kind:     "mount",
+		Repo:     "foo",
+		Digest:   testDigest,
+		FromRepo: "private/bar",
+	},
+	wantErr: `mount push to "foo" not allowed by policy`,
+}, {
+	testName: "MissingFields",
+	push: Push{
+		Kind: "blob",
+		Repo: "foo",
+	},
+	wantErr: `blob push to "foo" not allowed by policy`,
+}}
+
+func TestCheck(t *testing.T) {
+	p, err := Parse("policy.cue", []byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range checkTests {
+		t.Run(test.testName, func(t *testing.T) {
+			err := p.Check(test.push)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+			}
+			if !errors.Is(err, ociregistry.ErrDenied) {
+				t.Errorf("error %v does not wrap ErrDenied", err)
+			}
+		})
+	}
+}
+
+func TestCheckConcurrent(t *testing.T) {
+	p, err := Parse("policy.cue", []byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, test := range checkTests {
+				if err := p.Check(test.push); (err == nil) != (test.wantErr == "") {
+					t.Errorf("%s: unexpected result %v", test.testName, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+var parseTests = []struct {
+	testName string
+	policy   string
+	wantErr  string
+}{{
+	testName: "Empty",
+	policy:   "",
+}, {
+	testName: "SyntaxError",
+	policy:   "push: {",
+	wantErr:  "invalid policy",
+}, {
+	testName: "ConflictsWithSchema",
+	policy:   "push: kind: \"delete\"",
+	wantErr:  "invalid policy",
+}}
+
+func TestParse(t *testing.T) {
+	for _, test := range parseTests {
+		t.Run(test.testName, func(t *testing.T) {
+			_, err := Parse("policy.cue", []byte(test.policy))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Fatalf("got error %v; want error containing %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	p, err := Parse("policy.cue", []byte(testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := ociregistry.NewMemRegistry()
+	r := p.Registry(mr)
+	if _, ok := r.(ociregistry.Lister); !ok {
+		t.Errorf("policy registry does not implement Lister")
+	}
+	push := func(repo, data string) error {
+		desc := ociregistry.Descriptor{
+			MediaType: "text/plain",
+			Digest:    digest.FromString(data),
+			Size:      int64(len(data)),
+		}
+		_, err := r.PushBlob(ctx, repo, ociregistry.BytesBlob([]byte(data), desc.MediaType), desc)
+		return err
+	}
+	if err := push("foo", "small"); err != nil {
+		t.Fatal(err)
+	}
+	big := strings.Repeat("x", 101)
+	if err := push("foo", big); !errors.Is(err, ociregistry.ErrDenied) {
+		t.Fatalf("got error %v; want ErrDenied", err)
+	}
+	if _, err := mr.GetBlob(ctx, "foo", digest.FromString(big)); err == nil {
+		t.Errorf("denied blob was pushed")
+	}
+	if err := r.Tag(ctx, "foo", digest.FromString("small"), "latest"); !errors.Is(err, ociregistry.ErrDenied) {
+		t.Fatalf("got error %v; want ErrDenied", err)
+	}
+}
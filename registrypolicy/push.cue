@@ -0,0 +1,76 @@
+// Package registrypolicy defines the pushes that a registry policy
+// constrains. A policy is a CUE file that constrains the push field,
+// which is filled in with a #Push for every push to the registry.
+// A push is denied if the policy conflicts with it, or if the policy
+// requires a field that the push does not have. For example:
+//
+//	import "strings"
+//
+//	push: _
+//
+//	// Blobs are at most 100MiB.
+//	if push.kind == "blob" {
+//		push: desc: size: <=100 << 20
+//	}
+//
+//	// Module repositories only hold modules.
+//	if strings.HasPrefix(push.repo, "cue/") && push.kind == "manifest" {
+//		push: manifest: config: mediaType: "application/vnd.cue.module.v1+json"
+//	}
+//
+//	// Manifests must say where they came from.
+//	if push.kind == "manifest" {
+//		push: manifest: annotations: "org.opencontainers.image.source"!: string
+//	}
+//
+//	// Nothing is tagged latest.
+//	if push.kind == "tag" {
+//		push: tag: !="latest"
+//	}
+//
+// This file must not import any packages, so that it
+// can be compiled on its own.
+package registrypolicy
+
+// #Push describes a push to a registry.
+#Push: {
+	// kind holds the kind of push.
+	kind!: "blob" | "manifest" | "tag" | "mount"
+
+	// repo holds the repository that's pushed to.
+	repo!: string
+
+	// desc holds the descriptor of the pushed
+	// blob or manifest.
+	if kind == "blob" || kind == "manifest" {
+		desc!: {
+			mediaType!:    string
+			artifactType?: string
+			digest!:       string
+			size!:         int
+			annotations?: [string]: string
+			...
+		}
+	}
+
+	// manifest holds the content of the pushed manifest.
+	if kind == "manifest" {
+		manifest!: {...}
+	}
+
+	// tag holds the name of the tag that's pushed.
+	if kind == "tag" {
+		tag!: string
+	}
+
+	// digest holds the digest of the manifest that's
+	// tagged, or of the blob that's mounted.
+	if kind == "tag" || kind == "mount" {
+		digest!: string
+	}
+
+	// fromRepo holds the repository that a blob is mounted from.
+	if kind == "mount" {
+		fromRepo!: string
+	}
+}